@@ -21,6 +21,28 @@ func InitCommands(
 	}
 
 	Commands = map[string]cli.CommandFactory{
+		"fmt": func() (cli.Command, error) {
+			return &FmtCommand{
+				Meta:   meta,
+				Stdin:  os.Stdin,
+				Stdout: os.Stdout,
+			}, nil
+		},
+		"init": func() (cli.Command, error) {
+			return &InitCommand{
+				Meta: meta,
+			}, nil
+		},
+		"run": func() (cli.Command, error) {
+			return &RunCommand{
+				Meta: meta,
+			}, nil
+		},
+		"triggers": func() (cli.Command, error) {
+			return &TriggersCommand{
+				Meta: meta,
+			}, nil
+		},
 		"validate": func() (cli.Command, error) {
 			return &ValidateCommand{
 				Meta: meta,
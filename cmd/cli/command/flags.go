@@ -0,0 +1,40 @@
+package command
+
+import "strings"
+
+// stringSliceFlag is a flag.Value that collects every occurrence of a flag
+// that may be repeated.
+type stringSliceFlag []string
+
+func (f *stringSliceFlag) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *stringSliceFlag) Set(value string) error {
+	*f = append(*f, value)
+	return nil
+}
+
+// varArg is a -var or -var-file flag.
+type varArg struct {
+	// File is set for -var-file flags, in which case Value is the path
+	// of the file.
+	File  bool
+	Value string
+}
+
+// varFlag is a flag.Value that appends -var or -var-file flags to the same
+// list, so that they are applied in the order they are given.
+type varFlag struct {
+	args *[]varArg
+	file bool
+}
+
+func (f *varFlag) String() string {
+	return ""
+}
+
+func (f *varFlag) Set(value string) error {
+	*f.args = append(*f.args, varArg{File: f.file, Value: value})
+	return nil
+}
@@ -0,0 +1,209 @@
+package command
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/factorycicd/factory"
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/hashicorp/hcl/v2/hclwrite"
+	"github.com/pmezard/go-difflib/difflib"
+)
+
+// FmtCommand is a Command implementation that rewrites configuration files
+// in the canonical format.
+type FmtCommand struct {
+	Meta
+
+	// Path is the relative or absolute path to the configuration directory
+	// or file to format, or "-" to format standard input.
+	Path string
+
+	// Recursive is a flag that indicates whether to recursively format all
+	// subdirectories.
+	Recursive bool
+
+	// Check is a flag that indicates whether to only check that files are
+	// formatted, without rewriting them.
+	Check bool
+
+	// Diff is a flag that indicates whether to print the changes made to
+	// every file.
+	Diff bool
+
+	// Stdin and Stdout are read from and written to when Path is "-".
+	Stdin  io.Reader
+	Stdout io.Writer
+}
+
+// Run executes the fmt command and returns an exit code.
+func (c *FmtCommand) Run(rawArgs []string) int {
+	// Parse the command arguments
+	cmdFlags := flag.NewFlagSet("fmt", flag.ContinueOnError)
+	cmdFlags.StringVar(&c.Path, "path", ".", "Path to the configuration directory or file, or - for standard input.")
+	cmdFlags.BoolVar(&c.Recursive, "recursive", false, "Recursively format all subdirectories.")
+	cmdFlags.BoolVar(&c.Check, "check", false, "Check that files are formatted without rewriting them.")
+	cmdFlags.BoolVar(&c.Diff, "diff", false, "Print the changes made to every file.")
+	c.addDiagnosticFlags(cmdFlags)
+	cmdFlags.Usage = func() { c.Ui.Error(c.Help()) }
+	if err := cmdFlags.Parse(rawArgs); err != nil {
+		c.Ui.Error(fmt.Sprintf("Failed to parse fmt command arguments: %s\n", err.Error()))
+		return 1
+	}
+
+	if c.Path == "-" {
+		return c.fmtStdin()
+	}
+
+	dir, err := filepath.Abs(c.Path)
+	if err != nil {
+		c.Ui.Error(fmt.Sprintf("Failed to get absolute path for %s: %s\n", c.Path, err.Error()))
+		return 1
+	}
+
+	paths, diags := factory.ConfigFilePaths(dir, c.Recursive)
+	c.showDiagnostics(diags)
+	if diags.HasErrors() {
+		return 2
+	}
+
+	// Files with syntax errors are reported once every other file is
+	// formatted.
+	c.files = make(map[string]*hcl.File)
+	unformatted := false
+	var syntaxDiags hcl.Diagnostics
+	for _, path := range paths {
+		src, err := os.ReadFile(path)
+		if err != nil {
+			c.Ui.Error(fmt.Sprintf("Failed to read %s: %s\n", path, err.Error()))
+			return 1
+		}
+
+		formatted, diags := c.format(src, path)
+		if diags.HasErrors() {
+			syntaxDiags = append(syntaxDiags, diags...)
+			continue
+		}
+		if bytes.Equal(src, formatted) {
+			continue
+		}
+
+		unformatted = true
+		name := path
+		if rel, err := filepath.Rel(c.WorkingDir, path); err == nil && !strings.HasPrefix(rel, "..") {
+			name = rel
+		}
+		c.Ui.Output(name)
+		if c.Diff {
+			c.Ui.Output(unifiedDiff(name, src, formatted))
+		}
+		if c.Check {
+			continue
+		}
+
+		if err := os.WriteFile(path, formatted, 0644); err != nil {
+			c.Ui.Error(fmt.Sprintf("Failed to write %s: %s\n", path, err.Error()))
+			return 1
+		}
+	}
+
+	if syntaxDiags.HasErrors() {
+		c.showDiagnostics(syntaxDiags)
+		return 2
+	}
+	if c.Check && unformatted {
+		return 3
+	}
+	return 0
+}
+
+// fmtStdin formats standard input. The result is written to standard output,
+// or its diff with the input if -diff is set. Nothing is written with
+// -check.
+func (c *FmtCommand) fmtStdin() int {
+	src, err := io.ReadAll(c.Stdin)
+	if err != nil {
+		c.Ui.Error(fmt.Sprintf("Failed to read standard input: %s\n", err.Error()))
+		return 1
+	}
+
+	c.files = make(map[string]*hcl.File)
+	formatted, diags := c.format(src, "<stdin>")
+	if diags.HasErrors() {
+		c.showDiagnostics(diags)
+		return 2
+	}
+
+	switch {
+	case c.Diff:
+		if !bytes.Equal(src, formatted) {
+			fmt.Fprintln(c.Stdout, unifiedDiff("<stdin>", src, formatted))
+		}
+	case !c.Check:
+		c.Stdout.Write(formatted)
+	}
+
+	if c.Check && !bytes.Equal(src, formatted) {
+		return 3
+	}
+	return 0
+}
+
+// format returns src in the canonical format. Files with syntax errors are
+// not formatted, as the result could change their meaning.
+func (c *FmtCommand) format(src []byte, filename string) ([]byte, hcl.Diagnostics) {
+	file, diags := hclsyntax.ParseConfig(src, filename, hcl.InitialPos)
+	c.files[filename] = file
+	if diags.HasErrors() {
+		return nil, diags
+	}
+	return hclwrite.Format(src), nil
+}
+
+// unifiedDiff returns the changes between the original and formatted
+// contents of the named file.
+func unifiedDiff(name string, original, formatted []byte) string {
+	diff, _ := difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        difflib.SplitLines(string(original)),
+		B:        difflib.SplitLines(string(formatted)),
+		FromFile: "old/" + name,
+		ToFile:   "new/" + name,
+		Context:  3,
+	})
+	return strings.TrimSuffix(diff, "\n")
+}
+
+// Help implements cli.Command.
+func (*FmtCommand) Help() string {
+	helpText := `
+Usage: factory fmt [options]
+
+	Rewrite the configuration files in a directory in the canonical format
+	and style, and print the name of every file that changed.
+
+	Files with syntax errors are reported and left untouched. With -path=-,
+	the configuration is read from standard input and the formatted result
+	is written to standard output, for use in editors.
+
+Options:
+
+  -path <path>     Path to the directory or file to format, or - to read standard
+                   input. Defaults to the current directory.
+  -recursive       Recursively format all subdirectories as well.
+  -check           Check that the files are formatted without rewriting them. The
+                   exit code is 3 if any file is not formatted.
+  -diff            Print the changes made to every file.
+  -no-color        Print diagnostics without color.
+`
+	return strings.TrimSpace(helpText)
+}
+
+func (*FmtCommand) Synopsis() string {
+	return "Rewrite configuration files in the canonical format"
+}
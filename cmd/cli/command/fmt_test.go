@@ -0,0 +1,32 @@
+package command
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mitchellh/cli"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFmtSkipsFilesWithSyntaxErrors(t *testing.T) {
+	dir := t.TempDir()
+	broken := filepath.Join(dir, "a.hcl")
+	unformatted := filepath.Join(dir, "b.hcl")
+	os.WriteFile(broken, []byte("stage \"build\" {\n"), 0644)
+	os.WriteFile(unformatted, []byte("variables {\na=1\n}\n"), 0644)
+
+	ui := cli.NewMockUi()
+	c := &FmtCommand{Meta: Meta{WorkingDir: dir, Ui: ui}}
+
+	assert.Equal(t, 2, c.Run([]string{"-path", dir, "-no-color"}))
+	assert.Contains(t, ui.ErrorWriter.String(), "a.hcl")
+	assert.Equal(t, "b.hcl\n", ui.OutputWriter.String())
+
+	// The file with syntax errors is left untouched, the other one is
+	// still formatted.
+	src, _ := os.ReadFile(broken)
+	assert.Equal(t, "stage \"build\" {\n", string(src))
+	src, _ = os.ReadFile(unformatted)
+	assert.Equal(t, "variables {\n  a = 1\n}\n", string(src))
+}
@@ -0,0 +1,120 @@
+package command
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/factorycicd/factory"
+	"github.com/factorycicd/factory/module"
+)
+
+// InitCommand is a Command implementation that downloads imported sources
+// and records them in the lock file.
+type InitCommand struct {
+	Meta
+
+	// Path is the relative or absolute path to the factory configuration file
+	Path string
+
+	// Recursive is a flag that indicates whether to recursively load all
+	// subdirectories.
+	Recursive bool
+
+	// Upgrade is a flag that indicates whether to ignore the existing lock
+	// file and fetch the latest version of every source.
+	Upgrade bool
+}
+
+// Run executes the init command and returns an exit code.
+func (c *InitCommand) Run(rawArgs []string) int {
+	// Parse the command arguments
+	cmdFlags := flag.NewFlagSet("init", flag.ContinueOnError)
+	cmdFlags.StringVar(&c.Path, "path", ".", "Path to the factory configuration directory.")
+	cmdFlags.BoolVar(&c.Recursive, "recursive", false, "Recursively load all subdirectories.")
+	cmdFlags.BoolVar(&c.Upgrade, "upgrade", false, "Fetch the latest version of every source and update the lock file.")
+	c.addDiagnosticFlags(cmdFlags)
+	cmdFlags.Usage = func() { c.Ui.Error(c.Help()) }
+	if err := cmdFlags.Parse(rawArgs); err != nil {
+		c.Ui.Error(fmt.Sprintf("Failed to parse init command arguments: %s\n", err.Error()))
+		return 1
+	}
+
+	dir, err := filepath.Abs(c.Path)
+	if err != nil {
+		c.Ui.Error(fmt.Sprintf("Failed to get absolute path for %s: %s\n", c.Path, err.Error()))
+		return 1
+	}
+
+	lockPath := filepath.Join(c.WorkingDir, module.DefaultLockFile)
+	modulesDir := filepath.Join(c.WorkingDir, factory.DefaultModulesDir)
+
+	lock := module.NewLock()
+	if c.Upgrade {
+		// Drop every cached source so that refs are resolved again.
+		if err := os.RemoveAll(modulesDir); err != nil {
+			c.Ui.Error(fmt.Sprintf("Failed to clear module cache %s: %s\n", modulesDir, err.Error()))
+			return 1
+		}
+	} else {
+		existing, diags := module.LoadLockFile(lockPath)
+		c.showDiagnostics(diags)
+		if diags.HasErrors() {
+			return 2
+		}
+		if existing != nil {
+			lock = existing
+		}
+	}
+
+	parser := factory.NewParser(nil)
+	fetcher := parser.Modules()
+	fetcher.CacheDir = modulesDir
+	fetcher.Lock = lock
+	fetcher.UpdateLock = true
+
+	_, diags := parser.ParseDirectory(dir, c.Recursive)
+	c.showDiagnostics(diags)
+	if diags.HasErrors() {
+		return 2
+	}
+
+	fetcher.PruneLock()
+	if err := lock.Save(lockPath); err != nil {
+		c.Ui.Error(fmt.Sprintf("Failed to write lock file %s: %s\n", lockPath, err.Error()))
+		return 1
+	}
+
+	c.Ui.Output(fmt.Sprintf("Initialized %d source(s) in %s", len(lock.Sources), module.DefaultLockFile))
+	return 0
+}
+
+// Help implements cli.Command.
+func (*InitCommand) Help() string {
+	helpText := `
+Usage: factory init [options]
+
+	Download every pipeline and stage imported with "source" into the
+	.factory/modules cache and record the resolved commit and content hash
+	of each in .factory.lock.hcl.
+
+	Sources already recorded in the lock file are fetched at their locked
+	commit. Once the lock file exists, every other command fails if a cached
+	source does not match it.
+
+Options:
+
+  -path <path> Path to the configuration directory. Defaults to the current directory.
+  -recursive   Recursively load all subdirectories as well.
+  -upgrade     Ignore the existing lock file, fetch the latest version of every
+               source and record it.
+  -no-color    Print diagnostics without color.
+`
+	return strings.TrimSpace(helpText)
+}
+
+func (*InitCommand) Synopsis() string {
+	return "Download imported sources and update the lock file"
+}
@@ -1,25 +1,140 @@
 package command
 
 import (
+	"bytes"
+	"context"
+	"flag"
+	"os"
+	"strings"
+
+	"github.com/factorycicd/factory"
+	"github.com/factorycicd/factory/git"
+	"github.com/factorycicd/factory/module"
 	"github.com/hashicorp/hcl/v2"
 	"github.com/mitchellh/cli"
+	"golang.org/x/term"
 )
 
 type Meta struct {
 	WorkingDir string
 
 	Ui cli.Ui
+
+	// varArgs holds the -var and -var-file flags, see addVarFlags.
+	varArgs []varArg
+
+	// redactor replaces the sensitive values of the loaded configuration
+	// in diagnostics, see loadConfig.
+	redactor *factory.Redactor
+
+	// files are the configuration files loaded by loadConfig, keyed by
+	// filename, which diagnostics show source code snippets from.
+	files map[string]*hcl.File
+
+	// noColor is the -no-color flag, see addDiagnosticFlags.
+	noColor bool
 }
 
+// showDiagnostics prints every diagnostic along with the source code it
+// refers to. Diagnostics are colored by severity and wrapped to the width of
+// the terminal, unless the error stream is not a terminal or -no-color is
+// set.
 func (m *Meta) showDiagnostics(diags hcl.Diagnostics) {
-	for _, diag := range diags {
+	fd := int(os.Stderr.Fd())
+	isTerminal := term.IsTerminal(fd)
+
+	var width int
+	if isTerminal {
+		width, _, _ = term.GetSize(fd)
+	}
+
+	var buf bytes.Buffer
+	wr := hcl.NewDiagnosticTextWriter(&buf, m.files, uint(width), isTerminal && !m.noColor)
+	for _, diag := range m.redactor.RedactDiagnostics(diags) {
+		buf.Reset()
+		wr.WriteDiagnostic(diag)
+		// Keep a blank line between diagnostics
+		text := strings.TrimRight(buf.String(), "\n") + "\n"
+
 		switch diag.Severity {
 		case hcl.DiagError:
-			m.Ui.Error(diag.Error())
+			m.Ui.Error(text)
 		case hcl.DiagWarning:
-			m.Ui.Warn(diag.Error())
+			m.Ui.Warn(text)
 		default:
-			m.Ui.Output(diag.Error())
+			m.Ui.Output(text)
+		}
+	}
+}
+
+// addDiagnosticFlags adds the -no-color flag to the given flag set.
+func (m *Meta) addDiagnosticFlags(f *flag.FlagSet) {
+	f.BoolVar(&m.noColor, "no-color", false, "Print diagnostics without color.")
+}
+
+// gitInfo reads the branch, HEAD commit and the files changed since the
+// given base revision from the git repository containing the working
+// directory.
+func (m *Meta) gitInfo(ctx context.Context, since string) (*git.Info, error) {
+	repo, err := git.Open(ctx, m.WorkingDir)
+	if err != nil {
+		return nil, err
+	}
+	return repo.Info(ctx, since)
+}
+
+// addVarFlags adds the -var and -var-file flags to the given flag set.
+func (m *Meta) addVarFlags(f *flag.FlagSet) {
+	f.Var(&varFlag{args: &m.varArgs}, "var", "Set a variable, in the form name=value. May be repeated.")
+	f.Var(&varFlag{args: &m.varArgs, file: true}, "var-file", "Set variables from a "+factory.VarFileExt+" file. May be repeated.")
+}
+
+// inputValues collects the variable values given by FACTORY_VAR_
+// environment variables and then by the -var and -var-file flags, each
+// overriding the values collected before it.
+func (m *Meta) inputValues(parser *factory.Parser) (factory.InputValues, hcl.Diagnostics) {
+	var diags hcl.Diagnostics
+
+	inputs := factory.EnvInputValues(os.Environ())
+	for _, arg := range m.varArgs {
+		if arg.File {
+			fileInputs, fileDiags := parser.LoadVarFile(arg.Value)
+			diags = append(diags, fileDiags...)
+			inputs.Override(fileInputs)
+			continue
+		}
+
+		name, input, argDiags := factory.ParseVarFlag(arg.Value)
+		diags = append(diags, argDiags...)
+		if input != nil {
+			inputs[name] = input
 		}
 	}
+
+	return inputs, diags
+}
+
+// loadConfig parses the configuration directory at the given path, with the
+// variable values given on the command line and in the environment
+// overriding the variables of the configuration. runCtx may be nil, in which
+// case the run context namespaces are unknown.
+func (m *Meta) loadConfig(path string, recursive bool, runCtx *factory.RunContext) (*factory.Config, hcl.Diagnostics) {
+	parser := factory.NewParser(nil)
+
+	diags := parser.LoadLockFile(module.DefaultLockFile)
+	inputs, inputDiags := m.inputValues(parser)
+	diags = append(diags, inputDiags...)
+	if diags.HasErrors() {
+		config, _ := factory.NewConfig(nil)
+		return config, diags
+	}
+	parser.SetInputValues(inputs)
+	parser.SetRunContext(runCtx)
+
+	config, configDiags := parser.ParseConfigDirectory(path, recursive)
+	diags = append(diags, configDiags...)
+	m.redactor = config.Redactor()
+	m.files = parser.Files()
+
+	return config, diags
 }
@@ -0,0 +1,193 @@
+package command
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/factorycicd/factory"
+	"github.com/factorycicd/factory/executor"
+	"github.com/factorycicd/factory/secrets"
+)
+
+// RunCommand is a Command implementation that runs a pipeline locally.
+type RunCommand struct {
+	Meta
+
+	// Path is the relative or absolute path to the factory configuration file
+	Path string
+
+	// Recursive is a flag that indicates whether to recursively load all
+	// subdirectories.
+	Recursive bool
+
+	// Parallelism is the maximum number of stages to run at the same time.
+	Parallelism int
+
+	// Since is a git revision. When set, the pipeline only runs if its
+	// filter matches the changes made since that revision.
+	Since string
+
+	// SecretsFile is the path of a JSON file the secrets of the stages are
+	// read from instead of Vault.
+	SecretsFile string
+}
+
+// Run executes the run command and returns an exit code.
+func (c *RunCommand) Run(rawArgs []string) int {
+	// Parse the command arguments
+	cmdFlags := flag.NewFlagSet("run", flag.ContinueOnError)
+	cmdFlags.StringVar(&c.Path, "path", ".", "Path to the factory configuration directory.")
+	cmdFlags.BoolVar(&c.Recursive, "recursive", false, "Recursively load all subdirectories.")
+	cmdFlags.IntVar(&c.Parallelism, "parallelism", runtime.NumCPU(), "Maximum number of stages to run at the same time.")
+	cmdFlags.StringVar(&c.Since, "since", "", "Only run the pipeline if its filter matches the changes since this git revision.")
+	cmdFlags.StringVar(&c.SecretsFile, "secrets-file", "", "Read the secrets of the stages from this JSON file instead of Vault.")
+	c.addVarFlags(cmdFlags)
+	c.addDiagnosticFlags(cmdFlags)
+	cmdFlags.Usage = func() { c.Ui.Error(c.Help()) }
+	if err := cmdFlags.Parse(rawArgs); err != nil {
+		c.Ui.Error(fmt.Sprintf("Failed to parse run command arguments: %s\n", err.Error()))
+		return 1
+	}
+
+	args := cmdFlags.Args()
+	if len(args) != 1 {
+		c.Ui.Error("The run command expects exactly one argument: the name of the pipeline to run.\n")
+		c.Ui.Error(c.Help())
+		return 1
+	}
+	name := args[0]
+
+	dir, err := filepath.Abs(c.Path)
+	if err != nil {
+		c.Ui.Error(fmt.Sprintf("Failed to get absolute path for %s: %s\n", c.Path, err.Error()))
+		return 1
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	// The git namespace is left empty outside a git repository, unless
+	// the changes are needed for -since.
+	runCtx := factory.NewRunContext(name)
+	info, err := c.gitInfo(ctx, c.Since)
+	switch {
+	case err != nil && c.Since != "":
+		c.Ui.Error(fmt.Sprintf("Failed to read changes since %s: %s\n", c.Since, err.Error()))
+		return 1
+	case err != nil:
+		log.Printf("[DEBUG] git information is not available: %s", err)
+	default:
+		runCtx.GitBranch = info.Branch
+		runCtx.GitCommit = info.Commit
+	}
+
+	config, diags := c.loadConfig(dir, c.Recursive, runCtx)
+	diags = append(diags, config.Validate()...)
+	c.showDiagnostics(diags)
+	if diags.HasErrors() {
+		return 2
+	}
+
+	if c.Since != "" {
+		pipeline, ok := config.Pipelines[name]
+		if !ok {
+			c.Ui.Error(fmt.Sprintf("Failed to run pipeline %q: pipeline %q is not declared\n", name, name))
+			return 1
+		}
+
+		match, reason := pipeline.Filter.Matches(info.Branch, info.ChangedFiles)
+		if !match {
+			c.Ui.Output(fmt.Sprintf("Pipeline %q was not triggered: %s", name, reason))
+			return 0
+		}
+		c.Ui.Output(fmt.Sprintf("Pipeline %q was triggered: %s", name, reason))
+	}
+
+	exec := executor.NewExecutor(config, c.WorkingDir)
+	exec.Parallelism = c.Parallelism
+	if c.SecretsFile != "" {
+		exec.Secrets = secrets.NewFileProvider(c.SecretsFile)
+	} else if vault := secrets.VaultProviderFromEnv(); vault != nil {
+		exec.Secrets = vault
+	}
+	result, err := exec.RunPipeline(ctx, name)
+	if err != nil {
+		c.Ui.Error(fmt.Sprintf("Failed to run pipeline %q: %s\n", name, err.Error()))
+		return 1
+	}
+
+	c.showPipelineResult(result)
+	if result.Failed() {
+		return 1
+	}
+
+	return 0
+}
+
+// showPipelineResult prints a summary line for every stage and run block.
+func (c *RunCommand) showPipelineResult(result *executor.PipelineResult) {
+	c.Ui.Output(fmt.Sprintf("\nPipeline %q %s", result.Name, result.Status))
+	for _, stage := range result.Stages {
+		c.Ui.Output(fmt.Sprintf("  stage %q: %s", stage.Name, stage.Status))
+		for _, run := range stage.Runs {
+			line := fmt.Sprintf("    run %q: %s", run.Name, run.Status)
+			if run.Status == executor.StatusSucceeded || run.Status == executor.StatusFailed {
+				line += fmt.Sprintf(" (%s)", run.Duration.Round(1e6))
+			}
+			if run.Err != nil {
+				line += fmt.Sprintf(": %s", run.Err)
+			}
+			c.Ui.Output(line)
+		}
+	}
+}
+
+// Help implements cli.Command.
+func (*RunCommand) Help() string {
+	helpText := `
+Usage: factory run [options] <pipeline>
+
+	Run the stages of a pipeline on the local machine.
+
+	Stages start as soon as every stage in their depends_on list has
+	succeeded, and stages that do not depend on each other run concurrently.
+	Each stage's run blocks are executed in order through a shell, with their
+	output streamed to the terminal. A stage stops at the first command that
+	exits with a non-zero code, and every stage that depends on it is skipped.
+
+Options:
+
+  -path <path>        Path to the configuration directory. Defaults to the current directory.
+  -recursive          Recursively load all subdirectories as well.
+  -parallelism <n>    Maximum number of stages to run at the same time. Defaults to the
+                      number of CPUs. Zero or less means no limit.
+  -since <ref>        Only run the pipeline if its filter matches the current git branch
+                      and the files changed since this revision.
+  -no-color           Print diagnostics without color.
+  -secrets-file=foo   Read the secrets of the stages from this JSON file instead of
+                      Vault.
+  -var 'foo=bar'      Set a variable. May be repeated.
+  -var-file=foo       Set variables from a .factoryvars file. May be repeated.
+
+Variables can also be set with FACTORY_VAR_<name> environment variables.
+-var and -var-file flags override them, and later flags override earlier
+ones. See the Variables section of the documentation for the full
+precedence order.
+
+The secrets of the namespaces listed by a stage are read from Vault, using
+the VAULT_ADDR, VAULT_TOKEN and VAULT_NAMESPACE environment variables, and
+passed to the stage's commands as environment variables.
+`
+	return strings.TrimSpace(helpText)
+}
+
+func (*RunCommand) Synopsis() string {
+	return "Run a pipeline locally"
+}
@@ -0,0 +1,120 @@
+package command
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// TriggersCommand is a Command implementation that lists the pipelines whose
+// filters match a change.
+type TriggersCommand struct {
+	Meta
+
+	// Path is the relative or absolute path to the factory configuration file
+	Path string
+
+	// Recursive is a flag that indicates whether to recursively load all
+	// subdirectories.
+	Recursive bool
+
+	// Branch is the branch the change was made on.
+	Branch string
+
+	// ChangedFiles are the paths modified by the change.
+	ChangedFiles stringSliceFlag
+
+	// Since is a git revision. When set, the branch and changed files are
+	// read from the git repository instead of being passed as flags.
+	Since string
+}
+
+// Run executes the triggers command and returns an exit code.
+func (c *TriggersCommand) Run(rawArgs []string) int {
+	// Parse the command arguments
+	cmdFlags := flag.NewFlagSet("triggers", flag.ContinueOnError)
+	cmdFlags.StringVar(&c.Path, "path", ".", "Path to the factory configuration directory.")
+	cmdFlags.BoolVar(&c.Recursive, "recursive", false, "Recursively load all subdirectories.")
+	cmdFlags.StringVar(&c.Branch, "branch", "", "Branch the change was made on.")
+	cmdFlags.Var(&c.ChangedFiles, "changed-file", "Path modified by the change. May be repeated.")
+	cmdFlags.StringVar(&c.Since, "since", "", "Read the branch and changed files from git, relative to this revision.")
+	c.addVarFlags(cmdFlags)
+	c.addDiagnosticFlags(cmdFlags)
+	cmdFlags.Usage = func() { c.Ui.Error(c.Help()) }
+	if err := cmdFlags.Parse(rawArgs); err != nil {
+		c.Ui.Error(fmt.Sprintf("Failed to parse triggers command arguments: %s\n", err.Error()))
+		return 1
+	}
+
+	dir, err := filepath.Abs(c.Path)
+	if err != nil {
+		c.Ui.Error(fmt.Sprintf("Failed to get absolute path for %s: %s\n", c.Path, err.Error()))
+		return 1
+	}
+
+	config, diags := c.loadConfig(dir, c.Recursive, nil)
+	c.showDiagnostics(diags)
+	if diags.HasErrors() {
+		return 2
+	}
+
+	branch, changedFiles := c.Branch, []string(c.ChangedFiles)
+	if c.Since != "" {
+		info, err := c.gitInfo(context.Background(), c.Since)
+		if err != nil {
+			c.Ui.Error(fmt.Sprintf("Failed to read changes since %s: %s\n", c.Since, err.Error()))
+			return 1
+		}
+		if branch == "" {
+			branch = info.Branch
+		}
+		changedFiles = append(changedFiles, info.ChangedFiles...)
+	}
+
+	for _, name := range config.PipelineNames() {
+		pipeline := config.Pipelines[name]
+		match, reason := pipeline.Filter.Matches(branch, changedFiles)
+
+		status := "skipped"
+		if match {
+			status = "triggered"
+		}
+		c.Ui.Output(fmt.Sprintf("%s: %s (%s)", name, status, reason))
+	}
+
+	return 0
+}
+
+// Help implements cli.Command.
+func (*TriggersCommand) Help() string {
+	helpText := `
+Usage: factory triggers [options]
+
+	List the pipelines that would be triggered by a change, and explain why.
+
+	A pipeline is triggered when its filter matches the branch and at least
+	one of the changed files. Excluded branches and paths always take
+	precedence over included ones. Patterns support "*", "?", character
+	classes and "**" to match any number of directories.
+
+Options:
+
+  -path <path>            Path to the configuration directory. Defaults to the current directory.
+  -recursive              Recursively load all subdirectories as well.
+  -branch <name>          Branch the change was made on.
+  -changed-file <path>    Path modified by the change. May be repeated.
+  -since <ref>            Read the current branch and the files changed since this git
+                          revision from the repository. -branch overrides the branch and
+                          -changed-file adds to the changed files.
+  -no-color               Print diagnostics without color.
+  -var 'foo=bar'          Set a variable. May be repeated.
+  -var-file=foo           Set variables from a .factoryvars file. May be repeated.
+`
+	return strings.TrimSpace(helpText)
+}
+
+func (*TriggersCommand) Synopsis() string {
+	return "List the pipelines triggered by a change"
+}
@@ -1,12 +1,12 @@
 package command
 
 import (
+	"encoding/json"
 	"flag"
 	"fmt"
 	"path/filepath"
 	"strings"
 
-	"github.com/factorycicd/factory"
 	"github.com/hashicorp/hcl/v2"
 )
 
@@ -20,6 +20,10 @@ type ValidateCommand struct {
 	// Recursive is a flag that indicates whether to recursively validate all
 	// subdirectories.
 	Recursive bool
+
+	// JSON is a flag that indicates whether to print the result as a JSON
+	// document instead of human-readable diagnostics.
+	JSON bool
 }
 
 // Run executes the validate command and returns an exit code.
@@ -28,6 +32,9 @@ func (c *ValidateCommand) Run(rawArgs []string) int {
 	cmdFlags := flag.NewFlagSet("validate", flag.ContinueOnError)
 	cmdFlags.StringVar(&c.Path, "path", ".", "Path to the factory configuration directory.")
 	cmdFlags.BoolVar(&c.Recursive, "recursive", false, "Recursively validate all subdirectories.")
+	cmdFlags.BoolVar(&c.JSON, "json", false, "Print the result as a JSON document.")
+	c.addVarFlags(cmdFlags)
+	c.addDiagnosticFlags(cmdFlags)
 	cmdFlags.Usage = func() { c.Ui.Error(c.Help()) }
 	if err := cmdFlags.Parse(rawArgs); err != nil {
 		c.Ui.Error(fmt.Sprintf("Failed to parse validate command arguments: %s\n", err.Error()))
@@ -41,7 +48,16 @@ func (c *ValidateCommand) Run(rawArgs []string) int {
 
 	diags := c.validate(dir)
 
-	c.showDiagnostics(diags)
+	if c.JSON {
+		out, err := json.MarshalIndent(newValidateResult(c.redactor.RedactDiagnostics(diags)), "", "  ")
+		if err != nil {
+			c.Ui.Error(fmt.Sprintf("Failed to encode the result as JSON: %s\n", err.Error()))
+			return 1
+		}
+		c.Ui.Output(string(out))
+	} else {
+		c.showDiagnostics(diags)
+	}
 	if diags.HasErrors() {
 		return 2
 	}
@@ -50,13 +66,72 @@ func (c *ValidateCommand) Run(rawArgs []string) int {
 }
 
 // validate validates the given path by processing the directory, loading the files,
-// and returning any diagnostics encountered during the process.
+// running the semantic checks on the merged configuration, and returning any
+// diagnostics encountered during the process.
 func (c *ValidateCommand) validate(path string) hcl.Diagnostics {
-	_, diags := factory.ParseDirectory(path, c.Recursive)
+	config, diags := c.loadConfig(path, c.Recursive, nil)
+	diags = append(diags, config.Validate()...)
 
 	return diags
 }
 
+// validateResult is the document printed by validate -json.
+type validateResult struct {
+	Valid        bool                 `json:"valid"`
+	ErrorCount   int                  `json:"error_count"`
+	WarningCount int                  `json:"warning_count"`
+	Diagnostics  []validateDiagnostic `json:"diagnostics"`
+}
+
+type validateDiagnostic struct {
+	Severity string         `json:"severity"`
+	Summary  string         `json:"summary"`
+	Detail   string         `json:"detail,omitempty"`
+	Range    *validateRange `json:"range,omitempty"`
+}
+
+type validateRange struct {
+	Filename string      `json:"filename"`
+	Start    validatePos `json:"start"`
+	End      validatePos `json:"end"`
+}
+
+type validatePos struct {
+	Line   int `json:"line"`
+	Column int `json:"column"`
+	Byte   int `json:"byte"`
+}
+
+func newValidateResult(diags hcl.Diagnostics) *validateResult {
+	result := &validateResult{
+		Valid:       !diags.HasErrors(),
+		Diagnostics: make([]validateDiagnostic, 0, len(diags)),
+	}
+	for _, diag := range diags {
+		d := validateDiagnostic{
+			Summary: diag.Summary,
+			Detail:  diag.Detail,
+		}
+		switch diag.Severity {
+		case hcl.DiagError:
+			d.Severity = "error"
+			result.ErrorCount++
+		case hcl.DiagWarning:
+			d.Severity = "warning"
+			result.WarningCount++
+		}
+		if diag.Subject != nil {
+			d.Range = &validateRange{
+				Filename: diag.Subject.Filename,
+				Start:    validatePos{Line: diag.Subject.Start.Line, Column: diag.Subject.Start.Column, Byte: diag.Subject.Start.Byte},
+				End:      validatePos{Line: diag.Subject.End.Line, Column: diag.Subject.End.Column, Byte: diag.Subject.End.Byte},
+			}
+		}
+		result.Diagnostics = append(result.Diagnostics, d)
+	}
+	return result
+}
+
 // Help implements cli.Command.
 func (*ValidateCommand) Help() string {
 	helpText := `
@@ -65,12 +140,20 @@ Usage: factory validate [options]
 	Validate the configuration files in a directory, referring only to the configuration without execution.
 
 	Validate runs checks that verify whether a configuration is syntactically
-	valid. It is primarily intended for verification of configuration files.
+	valid and internally consistent: every stage a pipeline references must be
+	declared, depends_on may only name stages of the same pipeline and must
+	not form cycles, and every run block needs exactly one of command or file.
+	It is primarily intended for verification of configuration files.
 	
 Options:
 
-  -path <path> Path to the directory to validate. Defaults to the current directory.
-  -recursive   Recursively validate all subdirectories as well.
+  -path <path>     Path to the directory to validate. Defaults to the current directory.
+  -recursive       Recursively validate all subdirectories as well.
+  -json            Print the result as a JSON document listing every diagnostic,
+                   with its severity, summary, detail and source range.
+  -no-color        Print diagnostics without color.
+  -var 'foo=bar'   Set a variable. May be repeated.
+  -var-file=foo    Set variables from a .factoryvars file. May be repeated.
 `
 	return strings.TrimSpace(helpText)
 }
@@ -0,0 +1,129 @@
+package factory
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/factorycicd/factory/module"
+	"github.com/hashicorp/hcl/v2"
+	"github.com/spf13/afero"
+)
+
+// Config is the project-level view of a factory configuration. It merges
+// every File parsed from a directory into a single namespace so that a
+// pipeline declared in one file can refer to stages and variables declared
+// in another.
+type Config struct {
+	// Pipelines holds every pipeline in the project, keyed by name.
+	Pipelines map[string]*Pipeline
+
+	// Stages holds every stage in the project, keyed by name.
+	Stages map[string]*Stage
+
+	// Variables holds the merged global and stage variables of all files.
+	Variables *Variables
+
+	// Files are the individual files the configuration was built from.
+	Files []*File
+}
+
+// NewConfig merges the given files into a single Config.
+//
+// Pipelines, stages and global variables share one namespace across all
+// files, so declaring the same name twice produces an error diagnostic that
+// points at both declarations. The first declaration wins.
+func NewConfig(files []*File) (*Config, hcl.Diagnostics) {
+	var diags hcl.Diagnostics
+
+	config := &Config{
+		Pipelines: make(map[string]*Pipeline),
+		Stages:    make(map[string]*Stage),
+		Variables: NewVariables(),
+		Files:     files,
+	}
+
+	for _, file := range files {
+		for _, pipeline := range file.Pipelines {
+			if existing, ok := config.Pipelines[pipeline.Name]; ok {
+				diags = append(diags, duplicateDiagnostic("pipeline", pipeline.Name, existing.DeclRange, pipeline.DeclRange))
+				continue
+			}
+			config.Pipelines[pipeline.Name] = pipeline
+		}
+
+		for _, stage := range file.Stages {
+			if existing, ok := config.Stages[stage.Name]; ok {
+				diags = append(diags, duplicateDiagnostic("stage", stage.Name, existing.DeclRange, stage.DeclRange))
+				continue
+			}
+			config.Stages[stage.Name] = stage
+		}
+
+		diags = append(diags, config.Variables.merge(file.Variables)...)
+	}
+	diags = append(diags, config.Variables.prepareDeclared()...)
+
+	return config, diags
+}
+
+// PipelineNames returns the names of every pipeline in lexical order.
+func (c *Config) PipelineNames() []string {
+	return sortedKeys(c.Pipelines)
+}
+
+// StageFor returns the stage a pipeline's stage definition runs: the stage
+// imported with the definition or with its pipeline, if any, or else the
+// stage declared with the definition's name. A definition with a source
+// never falls back to a declared stage.
+func (c *Config) StageFor(sd *StageDefinition) (*Stage, bool) {
+	if sd.Stage != nil {
+		return sd.Stage, true
+	}
+	if sd.Source != "" {
+		return nil, false
+	}
+	stage, ok := c.Stages[sd.Name]
+	return stage, ok
+}
+
+// ParseConfigDirectory parses every file in the directory at the given path
+// and merges them into a single Config.
+//
+// The returned Config is never nil, even if errors were encountered, so that
+// callers can still inspect whatever could be decoded.
+func ParseConfigDirectory(path string, recursive bool) (*Config, hcl.Diagnostics) {
+	parser := NewParser(afero.NewOsFs())
+
+	diags := parser.LoadLockFile(module.DefaultLockFile)
+	if diags.HasErrors() {
+		config, _ := NewConfig(nil)
+		return config, diags
+	}
+
+	config, parseDiags := parser.ParseConfigDirectory(path, recursive)
+	return config, append(diags, parseDiags...)
+}
+
+// ParseConfigDirectory parses every file in the directory at the given path
+// using this parser and merges them into a single Config. See the
+// package-level ParseConfigDirectory for details.
+func (p *Parser) ParseConfigDirectory(path string, recursive bool) (*Config, hcl.Diagnostics) {
+	files, diags := p.ParseDirectory(path, recursive)
+
+	log.Printf("[DEBUG] merging %d config files", len(files))
+	config, configDiags := NewConfig(files)
+	diags = append(diags, configDiags...)
+
+	return config, diags
+}
+
+// duplicateDiagnostic builds the error reported when two declarations of the
+// same kind share a name.
+func duplicateDiagnostic(kind, name string, first, second hcl.Range) *hcl.Diagnostic {
+	return &hcl.Diagnostic{
+		Severity: hcl.DiagError,
+		Summary:  fmt.Sprintf("Duplicate %s", kind),
+		Detail:   fmt.Sprintf("A %s named %q was already declared at %s. Names must be unique across all configuration files.", kind, name, first),
+		Subject:  second.Ptr(),
+	}
+}
@@ -0,0 +1,87 @@
+package factory
+
+import (
+	"testing"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/zclconf/go-cty/cty"
+)
+
+func TestNewConfigMergesFiles(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	afero.WriteFile(fs, "pipelines.hcl", []byte(`
+		pipeline "build" {
+			stages = [{ name = "stage1" }]
+		}
+		variables {
+			foo = "bar"
+		}
+	`), 0644)
+	afero.WriteFile(fs, "stages.hcl", []byte(`
+		stage "stage1" {
+			variables {
+				baz = "qux"
+			}
+			run "Install Docker" {
+				command = "apt-get install docker"
+			}
+		}
+	`), 0644)
+
+	files, diags := NewParser(fs).LoadFiles([]string{"pipelines.hcl", "stages.hcl"})
+	if diags.HasErrors() {
+		t.Fatalf("Error loading files: %s", diags)
+	}
+
+	config, diags := NewConfig(files)
+	if diags.HasErrors() {
+		t.Fatalf("Error merging files: %s", diags)
+	}
+
+	assert.Len(t, config.Pipelines, 1)
+	assert.Len(t, config.Stages, 1)
+	assert.Equal(t, "pipelines.hcl", config.Pipelines["build"].DeclRange.Filename)
+	assert.Equal(t, "stages.hcl", config.Stages["stage1"].DeclRange.Filename)
+	assert.Equal(t, cty.StringVal("bar"), config.Variables.GlobalVariables["foo"])
+	assert.Equal(t, "pipelines.hcl", config.Variables.GlobalVariableRanges["foo"].Filename)
+	assert.Equal(t, cty.StringVal("qux"), config.Variables.StageVariables["stage1"]["baz"])
+}
+
+func TestNewConfigReportsDuplicates(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	afero.WriteFile(fs, "a.hcl", []byte(`
+		pipeline "build" {
+			stages = []
+		}
+		stage "stage1" {}
+		variables {
+			foo = "a"
+		}
+	`), 0644)
+	afero.WriteFile(fs, "b.hcl", []byte(`
+		pipeline "build" {
+			stages = []
+		}
+		stage "stage1" {}
+		variables {
+			foo = "b"
+		}
+	`), 0644)
+
+	files, diags := NewParser(fs).LoadFiles([]string{"a.hcl", "b.hcl"})
+	if diags.HasErrors() {
+		t.Fatalf("Error loading files: %s", diags)
+	}
+
+	config, diags := NewConfig(files)
+	assert.Len(t, diags.Errs(), 3, "Expected 3 duplicate errors got %d", len(diags.Errs()))
+	for _, diag := range diags {
+		assert.Equal(t, "b.hcl", diag.Subject.Filename)
+	}
+
+	// The first declaration wins
+	assert.Equal(t, "a.hcl", config.Pipelines["build"].DeclRange.Filename)
+	assert.Equal(t, "a.hcl", config.Stages["stage1"].DeclRange.Filename)
+	assert.Equal(t, cty.StringVal("a"), config.Variables.GlobalVariables["foo"])
+}
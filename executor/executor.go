@@ -0,0 +1,371 @@
+package executor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/factorycicd/factory"
+	"github.com/hashicorp/hcl/v2"
+)
+
+// DefaultShell is the shell used to run commands when an Executor does not
+// specify one. The "-e" flag stops a multiline command on its first failure.
+var DefaultShell = []string{"/bin/sh", "-e", "-c"}
+
+// Executor runs the stages of a pipeline on the local machine.
+type Executor struct {
+	// Config is the project configuration pipelines and stages are read from.
+	Config *factory.Config
+
+	// WorkingDir is the directory commands run in and files are resolved
+	// against. Defaults to the current working directory.
+	WorkingDir string
+
+	// Shell is the command used to run a run block's commands. The command
+	// string is appended as the last argument. Defaults to DefaultShell.
+	Shell []string
+
+	// Env is the environment of every process, in addition to the
+	// environment of the current process.
+	Env []string
+
+	// Stdout and Stderr receive the output of every process. Defaults to
+	// the output streams of the current process.
+	Stdout io.Writer
+	Stderr io.Writer
+
+	// Parallelism is the maximum number of stages that run at the same
+	// time. Zero or less means no limit. Defaults to the number of CPUs.
+	Parallelism int
+
+	// Secrets provides the secrets of the namespaces of every stage
+	// definition. They are passed to the processes of the stage as
+	// environment variables. A stage that lists namespaces fails when
+	// Secrets is nil.
+	Secrets factory.SecretsProvider
+
+	// Redactor replaces sensitive values in the output of every process
+	// and in the commands that are logged. The secrets of each stage are
+	// added to it once they are read. Defaults to the Redactor of Config.
+	Redactor *factory.Redactor
+
+	// outputMu serialises writes to Stdout and Stderr from stages that run
+	// concurrently.
+	outputMu sync.Mutex
+}
+
+// NewExecutor creates and returns a new Executor that runs pipelines from
+// the given configuration inside workingDir.
+func NewExecutor(config *factory.Config, workingDir string) *Executor {
+	return &Executor{
+		Config:      config,
+		WorkingDir:  workingDir,
+		Shell:       DefaultShell,
+		Stdout:      os.Stdout,
+		Stderr:      os.Stderr,
+		Parallelism: runtime.NumCPU(),
+		Redactor:    config.Redactor(),
+	}
+}
+
+// RunPipeline runs the stages of the named pipeline.
+//
+// Stages are ordered by their depends_on lists, and stages that do not
+// depend on each other run concurrently, up to Parallelism at a time. When a
+// stage fails, every stage that depends on it is skipped while independent
+// stages carry on. An error is only returned if the pipeline cannot be run
+// at all.
+func (e *Executor) RunPipeline(ctx context.Context, name string) (*PipelineResult, error) {
+	pipeline, ok := e.Config.Pipelines[name]
+	if !ok {
+		return nil, fmt.Errorf("pipeline %q is not declared", name)
+	}
+
+	g, err := NewGraph(e.Config, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	if e.Redactor == nil {
+		e.Redactor = factory.NewRedactor()
+	}
+
+	result := &PipelineResult{
+		Name:   name,
+		Status: StatusSucceeded,
+		Stages: e.schedule(ctx, g, e.Parallelism),
+	}
+	for _, stage := range result.Stages {
+		switch {
+		case stage.Status == StatusFailed:
+			result.Status = StatusFailed
+		case stage.Status == StatusCancelled && result.Status == StatusSucceeded:
+			result.Status = StatusCancelled
+		}
+	}
+
+	return result, nil
+}
+
+// RunStage runs the run blocks of the given stage in order, stopping at the
+// first one that fails.
+func (e *Executor) RunStage(ctx context.Context, stage *factory.Stage) *StageResult {
+	return e.runPipelineStage(ctx, nil, nil, stage, nil)
+}
+
+// runPipelineStage runs a stage of a pipeline with the secrets of the
+// namespaces of its stage definition in the environment of its processes,
+// and its run blocks rendered in the context the pipeline runs it in, see
+// factory.Stage.EvalContext, together with the outputs of the stages it
+// depends on, keyed by stage name. pipeline and def are nil for a stage run
+// on its own. The stage fails without running anything if the secrets cannot
+// be read or its variables and locals cannot be evaluated.
+func (e *Executor) runPipelineStage(ctx context.Context, pipeline *factory.Pipeline, def *factory.StageDefinition, stage *factory.Stage, outputs map[string]factory.Outputs) *StageResult {
+	var namespaces []string
+	if def != nil {
+		namespaces = def.Namespaces
+	}
+	secrets, err := factory.ResolveSecrets(ctx, e.Secrets, namespaces)
+	var env []string
+	if err == nil {
+		env, err = secrets.Environ()
+	}
+	if err != nil {
+		result := stageWithStatus(stage, StatusFailed)
+		result.Err = err
+		return result
+	}
+
+	evalCtx, diags := stage.EvalContext(pipeline, def, secrets, outputs)
+	if e.Redactor != nil {
+		// Variables and locals computed from secrets are sensitive too.
+		e.Redactor.AddValue(secrets.Value())
+		e.Redactor.AddValue(evalCtx.Variables["var"])
+		e.Redactor.AddValue(evalCtx.Variables["local"])
+	}
+	if diags.HasErrors() {
+		result := stageWithStatus(stage, StatusFailed)
+		result.Err = diags
+		return result
+	}
+
+	return e.runStage(ctx, stage, env, evalCtx)
+}
+
+func (e *Executor) runStage(ctx context.Context, stage *factory.Stage, env []string, evalCtx *hcl.EvalContext) *StageResult {
+	log.Printf("[INFO] running stage %q", stage.Name)
+
+	result := &StageResult{
+		Name:   stage.Name,
+		Status: StatusSucceeded,
+	}
+	for _, rb := range stage.RunBlocks {
+		if result.Status != StatusSucceeded {
+			result.Runs = append(result.Runs, skippedRun(stage.Name, rb))
+			continue
+		}
+
+		runResult := e.runBlock(ctx, stage.Name, rb, env, evalCtx)
+		result.Runs = append(result.Runs, runResult)
+		if runResult.Status != StatusSucceeded {
+			result.Status = runResult.Status
+			result.Err = fmt.Errorf("run %q: %w", rb.Name, runResult.Err)
+			continue
+		}
+		for name, value := range runResult.Outputs {
+			if result.Outputs == nil {
+				result.Outputs = make(factory.Outputs)
+			}
+			result.Outputs[name] = value
+		}
+	}
+
+	return result
+}
+
+// RunBlock runs the commands or file of a single run block.
+//
+// Commands are passed to the shell one at a time. A file is made executable
+// and run directly, so it must start with a shebang line.
+func (e *Executor) RunBlock(ctx context.Context, stageName string, rb factory.RunBlock) *RunResult {
+	return e.runBlock(ctx, stageName, rb, nil, nil)
+}
+
+// runBlock runs a run block with env added to the environment of its
+// processes. If evalCtx is not nil, the run block is rendered in it first,
+// see factory.RunBlock.Render. The outputs the run block declares are read
+// once it has succeeded.
+func (e *Executor) runBlock(ctx context.Context, stageName string, rb factory.RunBlock, env []string, evalCtx *hcl.EvalContext) *RunResult {
+	result := &RunResult{
+		Stage:     stageName,
+		Name:      rb.Name,
+		Status:    StatusSucceeded,
+		StartedAt: time.Now(),
+	}
+	defer func() {
+		result.Duration = time.Since(result.StartedAt)
+	}()
+
+	if evalCtx != nil {
+		if diags := rb.Render(evalCtx); diags.HasErrors() {
+			result.fail(-1, diags)
+			return result
+		}
+	}
+
+	// Commands and files that refer to unknown run context values are
+	// left out when the configuration is loaded, see factory.RunContext.
+	if (rb.CommandExpr != nil && len(rb.Commands) == 0) || (rb.FileExpr != nil && rb.File == "") {
+		result.fail(-1, errors.New("the run block refers to values that are unknown because the configuration was loaded without a run context"))
+		return result
+	}
+
+	var outputPath string
+	if len(rb.Outputs) > 0 {
+		path, err := newOutputFile()
+		if err != nil {
+			result.fail(-1, err)
+			return result
+		}
+		defer os.Remove(path)
+		outputPath = path
+		env = append(env[:len(env):len(env)], factory.OutputEnvVar+"="+path)
+	}
+
+	var cmds []*exec.Cmd
+	for _, command := range rb.Commands {
+		cmds = append(cmds, e.shellCommand(ctx, env, command))
+	}
+	if rb.File != "" {
+		cmd, err := e.fileCommand(ctx, env, rb.File, rb.Dir)
+		if err != nil {
+			result.fail(-1, err)
+			return result
+		}
+		cmds = append(cmds, cmd)
+	}
+
+	for _, cmd := range cmds {
+		log.Printf("[DEBUG] running %q in stage %q: %s", rb.Name, stageName, e.Redactor.Redact(cmd.String()))
+		err := cmd.Run()
+		flushOutput(cmd)
+		if err != nil {
+			if ctx.Err() != nil {
+				result.Status = StatusCancelled
+				result.ExitCode = -1
+				result.Err = ctx.Err()
+				return result
+			}
+
+			var exitErr *exec.ExitError
+			if errors.As(err, &exitErr) {
+				result.fail(exitErr.ExitCode(), fmt.Errorf("exited with code %d", exitErr.ExitCode()))
+			} else {
+				result.fail(-1, err)
+			}
+			return result
+		}
+	}
+
+	if outputPath != "" {
+		outputs, err := readOutputs(outputPath, rb.Outputs)
+		if err != nil {
+			result.fail(-1, err)
+			return result
+		}
+		result.Outputs = outputs
+	}
+
+	return result
+}
+
+func (e *Executor) shellCommand(ctx context.Context, env []string, command string) *exec.Cmd {
+	shell := e.Shell
+	if len(shell) == 0 {
+		shell = DefaultShell
+	}
+
+	args := append(append([]string{}, shell[1:]...), command)
+	return e.command(ctx, env, shell[0], args...)
+}
+
+// fileCommand returns the command that runs file, resolved against dir if it
+// is relative, or against WorkingDir if dir is empty.
+func (e *Executor) fileCommand(ctx context.Context, env []string, file, dir string) (*exec.Cmd, error) {
+	if dir == "" {
+		dir = e.WorkingDir
+	}
+	path := file
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(dir, path)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read file %s: %w", file, err)
+	}
+	if err := os.Chmod(path, info.Mode()|0111); err != nil {
+		return nil, fmt.Errorf("cannot make %s executable: %w", file, err)
+	}
+
+	return e.command(ctx, env, path), nil
+}
+
+func (e *Executor) command(ctx context.Context, env []string, name string, args ...string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Dir = e.WorkingDir
+	cmd.Env = append(append(os.Environ(), e.Env...), env...)
+	cmd.Stdout = &redactWriter{r: e.Redactor, w: &lockedWriter{mu: &e.outputMu, w: e.Stdout}}
+	cmd.Stderr = &redactWriter{r: e.Redactor, w: &lockedWriter{mu: &e.outputMu, w: e.Stderr}}
+	return cmd
+}
+
+// lockedWriter is an io.Writer that holds mu for the duration of every
+// write to w.
+type lockedWriter struct {
+	mu *sync.Mutex
+	w  io.Writer
+}
+
+func (l *lockedWriter) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.w.Write(p)
+}
+
+func (r *RunResult) fail(exitCode int, err error) {
+	r.Status = StatusFailed
+	r.ExitCode = exitCode
+	r.Err = err
+}
+
+func skippedRun(stageName string, rb factory.RunBlock) *RunResult {
+	return &RunResult{
+		Stage:    stageName,
+		Name:     rb.Name,
+		Status:   StatusSkipped,
+		ExitCode: -1,
+	}
+}
+
+// stageWithStatus returns the result of a stage that was never started.
+func stageWithStatus(stage *factory.Stage, status Status) *StageResult {
+	result := &StageResult{
+		Name:   stage.Name,
+		Status: status,
+	}
+	for _, rb := range stage.RunBlocks {
+		run := skippedRun(stage.Name, rb)
+		run.Status = status
+		result.Runs = append(result.Runs, run)
+	}
+	return result
+}
@@ -0,0 +1,382 @@
+package executor
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/factorycicd/factory"
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+)
+
+func testExecutor(t *testing.T, stages []*factory.Stage, defs []*factory.StageDefinition) (*Executor, *bytes.Buffer) {
+	t.Helper()
+
+	config, diags := factory.NewConfig([]*factory.File{
+		{
+			Pipelines: []*factory.Pipeline{{Name: "test", Stages: defs}},
+			Variables: factory.NewVariables(),
+			Stages:    stages,
+		},
+	})
+	if diags.HasErrors() {
+		t.Fatalf("Error building config: %s", diags)
+	}
+
+	var out bytes.Buffer
+	e := NewExecutor(config, t.TempDir())
+	e.Stdout = &out
+	e.Stderr = &out
+	return e, &out
+}
+
+func TestRunPipeline(t *testing.T) {
+	e, out := testExecutor(t, []*factory.Stage{
+		{Name: "stage1", RunBlocks: []factory.RunBlock{
+			{Name: "first", Commands: []string{"echo one"}},
+			{Name: "second", Commands: []string{"echo two\necho three"}},
+		}},
+		{Name: "stage2", RunBlocks: []factory.RunBlock{
+			{Name: "third", Commands: []string{"echo four"}},
+		}},
+	}, []*factory.StageDefinition{{Name: "stage1"}, {Name: "stage2", DependsOn: []string{"stage1"}}})
+
+	result, err := e.RunPipeline(context.Background(), "test")
+	if err != nil {
+		t.Fatalf("Error running pipeline: %s", err)
+	}
+
+	assert.Equal(t, StatusSucceeded, result.Status)
+	assert.False(t, result.Failed())
+	assert.Len(t, result.Stages, 2)
+	assert.Len(t, result.Stages[0].Runs, 2)
+	assert.Equal(t, "one\ntwo\nthree\nfour\n", out.String())
+}
+
+func TestRunPipelineStopsOnFailure(t *testing.T) {
+	e, out := testExecutor(t, []*factory.Stage{
+		{Name: "stage1", RunBlocks: []factory.RunBlock{
+			{Name: "first", Commands: []string{"echo one\nexit 3\necho unreachable"}},
+			{Name: "second", Commands: []string{"echo two"}},
+		}},
+		{Name: "stage2", RunBlocks: []factory.RunBlock{
+			{Name: "third", Commands: []string{"echo three"}},
+		}},
+	}, []*factory.StageDefinition{{Name: "stage1"}, {Name: "stage2", DependsOn: []string{"stage1"}}})
+
+	result, err := e.RunPipeline(context.Background(), "test")
+	if err != nil {
+		t.Fatalf("Error running pipeline: %s", err)
+	}
+
+	assert.True(t, result.Failed())
+	assert.Equal(t, "one\n", out.String())
+
+	stage1 := result.Stages[0]
+	assert.Equal(t, StatusFailed, stage1.Status)
+	assert.Error(t, stage1.Err)
+	assert.Equal(t, StatusFailed, stage1.Runs[0].Status)
+	assert.Equal(t, 3, stage1.Runs[0].ExitCode)
+	assert.Equal(t, StatusSkipped, stage1.Runs[1].Status)
+
+	stage2 := result.Stages[1]
+	assert.Equal(t, StatusSkipped, stage2.Status)
+	assert.Equal(t, StatusSkipped, stage2.Runs[0].Status)
+}
+
+func TestRunBlockFile(t *testing.T) {
+	e, out := testExecutor(t, nil, nil)
+	script := filepath.Join(e.WorkingDir, "deploy.sh")
+	if err := os.WriteFile(script, []byte("#!/bin/sh\necho deployed from $(basename $PWD)\n"), 0644); err != nil {
+		t.Fatalf("Error writing script: %s", err)
+	}
+
+	result := e.RunBlock(context.Background(), "stage", factory.RunBlock{Name: "deploy", File: "deploy.sh"})
+
+	assert.Equal(t, StatusSucceeded, result.Status, "Expected run to succeed got %s", result.Err)
+	assert.Equal(t, "deployed from "+filepath.Base(e.WorkingDir)+"\n", out.String())
+}
+
+func TestRunPipelineImportedStageFile(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "templates", "deploy")
+	project := filepath.Join(dir, "project")
+	for path, content := range map[string]string{
+		filepath.Join(src, "stage.hcl"): `
+stage "deploy" {
+  run "deploy" {
+    file = "deploy.sh"
+  }
+}
+`,
+		filepath.Join(src, "deploy.sh"): "#!/bin/sh\necho deployed from $(basename $PWD)\n",
+		filepath.Join(project, "pipeline.hcl"): `
+stage "deploy" {
+  source = "../templates/deploy"
+}
+pipeline "test" {
+  stages = [{ name = "deploy" }]
+}
+`,
+	} {
+		os.MkdirAll(filepath.Dir(path), 0755)
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("Error writing %s: %s", path, err)
+		}
+	}
+
+	files, diags := factory.NewParser(afero.NewOsFs()).LoadFiles([]string{filepath.Join(project, "pipeline.hcl")})
+	config, mergeDiags := factory.NewConfig(files)
+	diags = append(diags, mergeDiags...)
+	if diags.HasErrors() {
+		t.Fatalf("Error loading config: %s", diags)
+	}
+
+	var out bytes.Buffer
+	e := NewExecutor(config, project)
+	e.Stdout = &out
+
+	result, err := e.RunPipeline(context.Background(), "test")
+	if err != nil {
+		t.Fatalf("Error running pipeline: %s", err)
+	}
+
+	// The file is read from the source, but runs in the working directory.
+	assert.Equal(t, StatusSucceeded, result.Status, "Expected pipeline to succeed got %s", result.Stages[0].Err)
+	assert.Equal(t, "deployed from project\n", out.String())
+}
+
+func TestRunBlockMissingFile(t *testing.T) {
+	e, _ := testExecutor(t, nil, nil)
+
+	result := e.RunBlock(context.Background(), "stage", factory.RunBlock{Name: "deploy", File: "missing.sh"})
+
+	assert.Equal(t, StatusFailed, result.Status)
+	assert.Equal(t, -1, result.ExitCode)
+	assert.Error(t, result.Err)
+}
+
+func TestRunBlockUnknownCommand(t *testing.T) {
+	e, _ := testExecutor(t, nil, nil)
+
+	expr, diags := hclsyntax.ParseTemplate([]byte("echo ${git.sha}"), "test", hcl.InitialPos)
+	if diags.HasErrors() {
+		t.Fatalf("Error parsing command: %s", diags)
+	}
+	result := e.RunBlock(context.Background(), "stage", factory.RunBlock{Name: "push", CommandExpr: expr})
+
+	assert.Equal(t, StatusFailed, result.Status)
+	assert.Error(t, result.Err)
+}
+
+func TestRunPipelineUnknownPipeline(t *testing.T) {
+	e, _ := testExecutor(t, nil, nil)
+
+	_, err := e.RunPipeline(context.Background(), "missing")
+	assert.Error(t, err)
+}
+
+type testSecretsProvider map[string]map[string]string
+
+func (p testSecretsProvider) Secrets(_ context.Context, namespace string) (map[string]string, error) {
+	secrets, ok := p[namespace]
+	if !ok {
+		return nil, errors.New("not found")
+	}
+	return secrets, nil
+}
+
+func TestRunPipelineSecrets(t *testing.T) {
+	e, out := testExecutor(t, []*factory.Stage{
+		{Name: "push", RunBlocks: []factory.RunBlock{
+			{Name: "login", Commands: []string{"echo user=$DOCKER_USER"}},
+		}},
+		{Name: "deploy", RunBlocks: []factory.RunBlock{
+			{Name: "apply", Commands: []string{"echo deployed"}},
+		}},
+	}, []*factory.StageDefinition{{Name: "push", Namespaces: []string{"ci/docker"}}, {Name: "deploy", Namespaces: []string{"ci/missing"}}})
+	e.Parallelism = 1
+	e.Secrets = testSecretsProvider{"ci/docker": {"DOCKER_USER": "ci-bot"}}
+
+	result, err := e.RunPipeline(context.Background(), "test")
+	if err != nil {
+		t.Fatalf("Error running pipeline: %s", err)
+	}
+
+	assert.Equal(t, StatusSucceeded, result.Stages[0].Status, "Expected push to succeed got %s", result.Stages[0].Err)
+	assert.Equal(t, StatusFailed, result.Stages[1].Status)
+	assert.ErrorContains(t, result.Stages[1].Err, `namespace "ci/missing"`)
+	// Secrets are redacted from the output
+	assert.Equal(t, "user=***\n", out.String())
+}
+
+func TestRunPipelineUsesPipelineVariables(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	afero.WriteFile(fs, "test.hcl", []byte(`
+variables {
+  env = "dev"
+}
+stage "deploy" {
+  run "deploy" {
+    command = "echo deploy to ${var.env}"
+  }
+}
+pipeline "dev" {
+  stages = [{ name = "deploy" }]
+}
+pipeline "prod" {
+  stages = [{ name = "deploy" }]
+  variables {
+    env = "prod"
+  }
+}
+`), 0644)
+	files, diags := factory.NewParser(fs).LoadFiles([]string{"test.hcl"})
+	config, mergeDiags := factory.NewConfig(files)
+	diags = append(diags, mergeDiags...)
+	if diags.HasErrors() {
+		t.Fatalf("Error loading config: %s", diags)
+	}
+
+	var out bytes.Buffer
+	e := NewExecutor(config, t.TempDir())
+	e.Stdout = &out
+	for _, name := range []string{"dev", "prod"} {
+		result, err := e.RunPipeline(context.Background(), name)
+		if err != nil {
+			t.Fatalf("Error running pipeline %s: %s", name, err)
+		}
+		assert.Equal(t, StatusSucceeded, result.Status, "Expected %s to succeed got %s", name, result.Stages[0].Err)
+	}
+
+	assert.Equal(t, "deploy to dev\ndeploy to prod\n", out.String())
+}
+
+func TestRunPipelineRendersSecrets(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	afero.WriteFile(fs, "test.hcl", []byte(`
+stage "login" {
+  locals {
+    auth = base64encode("${secret.DOCKER_USER}:${secret.DOCKER_PASSWORD}")
+  }
+  run "login" {
+    command = "echo ${secret.DOCKER_USER} ${local.auth}"
+  }
+}
+pipeline "test" {
+  stages = [{ name = "login", namespaces = ["ci/docker"] }]
+}
+`), 0644)
+	files, diags := factory.NewParser(fs).LoadFiles([]string{"test.hcl"})
+	config, mergeDiags := factory.NewConfig(files)
+	diags = append(diags, mergeDiags...)
+	if diags.HasErrors() {
+		t.Fatalf("Error loading config: %s", diags)
+	}
+
+	var out bytes.Buffer
+	e := NewExecutor(config, t.TempDir())
+	e.Stdout = &out
+	e.Secrets = testSecretsProvider{"ci/docker": {"DOCKER_USER": "ci-bot", "DOCKER_PASSWORD": "hunter2"}}
+
+	result, err := e.RunPipeline(context.Background(), "test")
+	if err != nil {
+		t.Fatalf("Error running pipeline: %s", err)
+	}
+
+	assert.Equal(t, StatusSucceeded, result.Status, "Expected pipeline to succeed got %s", result.Stages[0].Err)
+	// Values computed from secrets are redacted as well
+	assert.Equal(t, "*** ***\n", out.String())
+}
+
+func TestRunPipelineOutputs(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	afero.WriteFile(fs, "test.hcl", []byte(`
+stage "build" {
+  run "build" {
+    command = "echo tag=v1 >> $FACTORY_OUTPUT"
+    outputs = ["tag", "digest"]
+  }
+}
+stage "push" {
+  run "push" {
+    command = "echo push app:${stage.build.outputs.tag}"
+  }
+}
+pipeline "test" {
+  stages = [{ name = "build" }, { name = "push", depends_on = ["build"] }]
+}
+`), 0644)
+	files, diags := factory.NewParser(fs).LoadFiles([]string{"test.hcl"})
+	config, mergeDiags := factory.NewConfig(files)
+	diags = append(diags, mergeDiags...)
+	if diags.HasErrors() {
+		t.Fatalf("Error loading config: %s", diags)
+	}
+
+	var out bytes.Buffer
+	e := NewExecutor(config, t.TempDir())
+	e.Stdout = &out
+
+	result, err := e.RunPipeline(context.Background(), "test")
+	if err != nil {
+		t.Fatalf("Error running pipeline: %s", err)
+	}
+
+	assert.Equal(t, StatusSucceeded, result.Status, "Expected pipeline to succeed got %s %s", result.Stages[0].Err, result.Stages[1].Err)
+	assert.Equal(t, factory.Outputs{"tag": "v1", "digest": ""}, result.Stages[0].Outputs)
+	assert.Equal(t, "push app:v1\n", out.String())
+}
+
+func TestRunBlockUndeclaredOutput(t *testing.T) {
+	e, _ := testExecutor(t, nil, nil)
+
+	result := e.RunBlock(context.Background(), "build", factory.RunBlock{
+		Name:     "build",
+		Commands: []string{"echo tag=v1 >> $FACTORY_OUTPUT", "echo digest=x >> $FACTORY_OUTPUT"},
+		Outputs:  []string{"tag"},
+	})
+
+	assert.Equal(t, StatusFailed, result.Status)
+	assert.ErrorContains(t, result.Err, `wrote output "digest"`)
+}
+
+func TestRunBlockRedactsOutput(t *testing.T) {
+	e, out := testExecutor(t, nil, nil)
+	e.Redactor = factory.NewRedactor("hunter2")
+
+	result := e.RunBlock(context.Background(), "stage", factory.RunBlock{
+		Name:     "login",
+		Commands: []string{"printf 'password: hun'; printf 'ter2\\n'; printf 'hunter2 no newline'"},
+	})
+
+	assert.Equal(t, StatusSucceeded, result.Status, "Expected run to succeed got %s", result.Err)
+	assert.Equal(t, "password: ***\n*** no newline", out.String())
+}
+
+func TestRedactWriter(t *testing.T) {
+	key := "-----BEGIN KEY-----\nc2VjcmV0\n-----END KEY-----"
+	var out bytes.Buffer
+	rw := &redactWriter{r: factory.NewRedactor(key, "hunter2"), w: &out}
+
+	// Progress output is shown as it is written, not held back until the
+	// end of a line.
+	rw.Write([]byte("10%\r20%\r" + strings.Repeat(".", len(key))))
+	assert.Equal(t, "10%\r20%\r.", out.String())
+
+	// A value that spans lines, split across writes, is redacted whole.
+	out.Reset()
+	rw.buf = rw.buf[:0]
+	rw.Write([]byte("key: -----BEGIN KEY-----\nc2Vj"))
+	rw.Write([]byte("cmV0\n-----END KEY-----\npassword: hunter2\n"))
+	rw.Flush()
+	assert.Equal(t, "key: ***\npassword: ***\n", out.String())
+	assert.NotContains(t, out.String(), "c2Vj")
+}
@@ -0,0 +1,117 @@
+package executor
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/factorycicd/factory"
+)
+
+// stageNode is a single stage of a pipeline within a Graph.
+type stageNode struct {
+	index      int
+	def        *factory.StageDefinition
+	stage      *factory.Stage
+	dependsOn  []*stageNode
+	dependents []*stageNode
+}
+
+// Graph is the dependency graph formed by the DependsOn lists of a
+// pipeline's stage definitions.
+type Graph struct {
+	pipeline *factory.Pipeline
+
+	// nodes are kept in the order the stages are declared in the pipeline.
+	nodes []*stageNode
+}
+
+// NewGraph builds the dependency graph of the named pipeline.
+//
+// It returns an error if the pipeline references a stage that is not
+// declared, if a stage depends on a stage that is not part of the pipeline,
+// or if the dependencies form a cycle.
+func NewGraph(config *factory.Config, pipeline *factory.Pipeline) (*Graph, error) {
+	g := &Graph{pipeline: pipeline}
+	byName := make(map[string]*stageNode)
+
+	for i, sd := range pipeline.Stages {
+		stage, ok := config.StageFor(sd)
+		if !ok {
+			return nil, fmt.Errorf("pipeline %q references undeclared stage %q", pipeline.Name, sd.Name)
+		}
+		if _, ok := byName[sd.Name]; ok {
+			return nil, fmt.Errorf("pipeline %q lists stage %q more than once", pipeline.Name, sd.Name)
+		}
+
+		node := &stageNode{index: i, def: sd, stage: stage}
+		byName[sd.Name] = node
+		g.nodes = append(g.nodes, node)
+	}
+
+	for _, node := range g.nodes {
+		seen := make(map[string]bool)
+		for _, dep := range node.def.DependsOn {
+			if seen[dep] {
+				continue
+			}
+			seen[dep] = true
+
+			depNode, ok := byName[dep]
+			if !ok {
+				return nil, fmt.Errorf("stage %q depends on %q, which is not part of pipeline %q", node.def.Name, dep, pipeline.Name)
+			}
+			node.dependsOn = append(node.dependsOn, depNode)
+			depNode.dependents = append(depNode.dependents, node)
+		}
+	}
+
+	if _, err := g.Order(); err != nil {
+		return nil, err
+	}
+
+	return g, nil
+}
+
+// Order returns the stage names in an order that satisfies every
+// dependency. Stages that are ready at the same time keep their declaration
+// order.
+func (g *Graph) Order() ([]string, error) {
+	pending := make([]int, len(g.nodes))
+	var ready []*stageNode
+	for _, node := range g.nodes {
+		pending[node.index] = len(node.dependsOn)
+		if pending[node.index] == 0 {
+			ready = append(ready, node)
+		}
+	}
+
+	var order []string
+	for len(ready) > 0 {
+		node := ready[0]
+		ready = ready[1:]
+		order = append(order, node.def.Name)
+
+		for _, dependent := range node.dependents {
+			pending[dependent.index]--
+			if pending[dependent.index] == 0 {
+				ready = append(ready, dependent)
+				sort.SliceStable(ready, func(i, j int) bool {
+					return ready[i].index < ready[j].index
+				})
+			}
+		}
+	}
+
+	if len(order) != len(g.nodes) {
+		var cycle []string
+		for _, node := range g.nodes {
+			if pending[node.index] > 0 {
+				cycle = append(cycle, fmt.Sprintf("%q", node.def.Name))
+			}
+		}
+		return nil, fmt.Errorf("dependency cycle between stages %s", strings.Join(cycle, ", "))
+	}
+
+	return order, nil
+}
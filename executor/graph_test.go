@@ -0,0 +1,59 @@
+package executor
+
+import (
+	"testing"
+
+	"github.com/factorycicd/factory"
+	"github.com/stretchr/testify/assert"
+)
+
+func testGraph(defs []*factory.StageDefinition) (*Graph, error) {
+	config := &factory.Config{
+		Pipelines: map[string]*factory.Pipeline{},
+		Stages:    map[string]*factory.Stage{},
+	}
+	for _, sd := range defs {
+		config.Stages[sd.Name] = &factory.Stage{Name: sd.Name}
+	}
+	config.Stages["unused"] = &factory.Stage{Name: "unused"}
+
+	return NewGraph(config, &factory.Pipeline{Name: "test", Stages: defs})
+}
+
+func TestGraphOrder(t *testing.T) {
+	g, err := testGraph([]*factory.StageDefinition{
+		{Name: "deploy", DependsOn: []string{"build", "test"}},
+		{Name: "test", DependsOn: []string{"build"}},
+		{Name: "lint"},
+		{Name: "build"},
+	})
+	if err != nil {
+		t.Fatalf("Error building graph: %s", err)
+	}
+
+	order, err := g.Order()
+	if err != nil {
+		t.Fatalf("Error ordering graph: %s", err)
+	}
+
+	assert.Equal(t, []string{"lint", "build", "test", "deploy"}, order)
+}
+
+func TestGraphReturnsErrorForCycle(t *testing.T) {
+	_, err := testGraph([]*factory.StageDefinition{
+		{Name: "a", DependsOn: []string{"c"}},
+		{Name: "b", DependsOn: []string{"a"}},
+		{Name: "c", DependsOn: []string{"b"}},
+		{Name: "d"},
+	})
+
+	assert.EqualError(t, err, `dependency cycle between stages "a", "b", "c"`)
+}
+
+func TestGraphReturnsErrorForDependencyOutsidePipeline(t *testing.T) {
+	_, err := testGraph([]*factory.StageDefinition{
+		{Name: "a", DependsOn: []string{"unused"}},
+	})
+
+	assert.EqualError(t, err, `stage "a" depends on "unused", which is not part of pipeline "test"`)
+}
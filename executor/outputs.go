@@ -0,0 +1,44 @@
+package executor
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/factorycicd/factory"
+)
+
+// newOutputFile creates an empty file for a run block to write its outputs
+// to, see factory.OutputEnvVar, and returns its path.
+func newOutputFile() (string, error) {
+	f, err := os.CreateTemp("", "factory-output-")
+	if err != nil {
+		return "", fmt.Errorf("cannot create outputs file: %w", err)
+	}
+	return f.Name(), f.Close()
+}
+
+// readOutputs reads the outputs a run block wrote to the file at path. It
+// fails if the run block wrote an output it does not declare. Declared
+// outputs that were not written are empty.
+func readOutputs(path string, declared []string) (factory.Outputs, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read outputs: %w", err)
+	}
+	defer f.Close()
+
+	written, err := factory.ParseOutputs(f)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read outputs: %w", err)
+	}
+
+	outputs := make(factory.Outputs, len(declared))
+	for _, name := range declared {
+		outputs[name] = written[name]
+		delete(written, name)
+	}
+	for name := range written {
+		return nil, fmt.Errorf("wrote output %q, which the run block does not declare", name)
+	}
+	return outputs, nil
+}
@@ -0,0 +1,51 @@
+package executor
+
+import (
+	"io"
+	"os/exec"
+
+	"github.com/factorycicd/factory"
+)
+
+// redactWriter is an io.Writer that replaces sensitive values in what is
+// written to w. Output is passed on as it is written, except for the end
+// that may be the start of a sensitive value, which is held back until more
+// output follows, so that a value split across writes is still redacted.
+// Flush writes whatever is left.
+type redactWriter struct {
+	r   *factory.Redactor
+	w   io.Writer
+	buf []byte
+}
+
+func (rw *redactWriter) Write(p []byte) (int, error) {
+	rw.buf = append(rw.buf, p...)
+	redacted, rest := rw.r.RedactPartial(string(rw.buf))
+	if redacted != "" {
+		if _, err := io.WriteString(rw.w, redacted); err != nil {
+			return 0, err
+		}
+	}
+	rw.buf = append(rw.buf[:0], rest...)
+	return len(p), nil
+}
+
+// Flush writes the output held back, if any.
+func (rw *redactWriter) Flush() error {
+	if len(rw.buf) == 0 {
+		return nil
+	}
+	_, err := io.WriteString(rw.w, rw.r.Redact(string(rw.buf)))
+	rw.buf = rw.buf[:0]
+	return err
+}
+
+// flushOutput writes the output of a finished command that is held back by
+// its redactWriters.
+func flushOutput(cmd *exec.Cmd) {
+	for _, w := range []io.Writer{cmd.Stdout, cmd.Stderr} {
+		if rw, ok := w.(*redactWriter); ok {
+			rw.Flush()
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package executor
+
+import (
+	"time"
+
+	"github.com/factorycicd/factory"
+)
+
+// Status describes the outcome of a run block, stage or pipeline.
+type Status string
+
+const (
+	StatusSucceeded Status = "succeeded"
+	StatusFailed    Status = "failed"
+	StatusSkipped   Status = "skipped"
+	StatusCancelled Status = "cancelled"
+)
+
+// RunResult is the outcome of executing a single run block.
+type RunResult struct {
+	// Stage is the name of the stage the run block belongs to.
+	Stage string
+
+	// Name is the label of the run block.
+	Name string
+
+	Status Status
+
+	// ExitCode is the exit code of the process, or -1 if the process could
+	// not be started or was never run.
+	ExitCode int
+
+	// Err is set when the run block did not succeed.
+	Err error
+
+	// Outputs holds the outputs the run block declares, once it has
+	// succeeded.
+	Outputs factory.Outputs
+
+	StartedAt time.Time
+	Duration  time.Duration
+}
+
+// StageResult is the outcome of executing every run block of a stage.
+type StageResult struct {
+	Name   string
+	Status Status
+	Runs   []*RunResult
+
+	// Err is set when the stage did not succeed.
+	Err error
+
+	// Outputs holds the outputs of every run block of the stage that
+	// succeeded. They are passed to the stages that depend on it.
+	Outputs factory.Outputs
+}
+
+// PipelineResult is the outcome of executing every stage of a pipeline.
+type PipelineResult struct {
+	Name   string
+	Status Status
+	Stages []*StageResult
+}
+
+// Failed reports whether any stage of the pipeline did not succeed.
+func (r *PipelineResult) Failed() bool {
+	return r.Status != StatusSucceeded
+}
@@ -0,0 +1,104 @@
+package executor
+
+import (
+	"context"
+	"log"
+
+	"github.com/factorycicd/factory"
+)
+
+// schedule runs the stages of the graph, starting each stage as soon as all
+// of the stages it depends on have succeeded. At most limit stages run at
+// the same time; a limit of zero or less runs every ready stage at once.
+//
+// A stage whose dependency did not succeed is skipped, and a stage that has
+// not started when ctx is cancelled is reported as cancelled. The results
+// are returned in declaration order.
+func (e *Executor) schedule(ctx context.Context, g *Graph, limit int) []*StageResult {
+	total := len(g.nodes)
+	if limit <= 0 || limit > total {
+		limit = total
+	}
+
+	results := make([]*StageResult, total)
+	pending := make([]int, total)
+	blocked := make([]bool, total)
+
+	var ready []*stageNode
+	for _, node := range g.nodes {
+		pending[node.index] = len(node.dependsOn)
+		if pending[node.index] == 0 {
+			ready = append(ready, node)
+		}
+	}
+
+	finished := 0
+	var finish func(node *stageNode)
+	finish = func(node *stageNode) {
+		finished++
+		status := results[node.index].Status
+		for _, dependent := range node.dependents {
+			pending[dependent.index]--
+			if status != StatusSucceeded {
+				blocked[dependent.index] = true
+			}
+			if pending[dependent.index] > 0 {
+				continue
+			}
+
+			switch {
+			case ctx.Err() != nil:
+				results[dependent.index] = stageWithStatus(dependent.stage, StatusCancelled)
+				finish(dependent)
+			case blocked[dependent.index]:
+				log.Printf("[INFO] skipping stage %q: a dependency did not succeed", dependent.def.Name)
+				results[dependent.index] = stageWithStatus(dependent.stage, StatusSkipped)
+				finish(dependent)
+			default:
+				ready = append(ready, dependent)
+			}
+		}
+	}
+
+	done := make(chan *stageNode)
+	running := 0
+	for finished < total {
+		for running < limit && len(ready) > 0 {
+			node := ready[0]
+			ready = ready[1:]
+
+			if ctx.Err() != nil {
+				results[node.index] = stageWithStatus(node.stage, StatusCancelled)
+				finish(node)
+				continue
+			}
+
+			running++
+			outputs := dependencyOutputs(node, results)
+			go func(node *stageNode) {
+				results[node.index] = e.runPipelineStage(ctx, g.pipeline, node.def, node.stage, outputs)
+				done <- node
+			}(node)
+		}
+
+		if running == 0 {
+			continue
+		}
+
+		node := <-done
+		running--
+		finish(node)
+	}
+
+	return results
+}
+
+// dependencyOutputs returns the outputs of the stages node depends on, which
+// have all succeeded, keyed by stage name.
+func dependencyOutputs(node *stageNode, results []*StageResult) map[string]factory.Outputs {
+	outputs := make(map[string]factory.Outputs, len(node.dependsOn))
+	for _, dep := range node.dependsOn {
+		outputs[dep.def.Name] = results[dep.index].Outputs
+	}
+	return outputs
+}
@@ -0,0 +1,94 @@
+package executor
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/factorycicd/factory"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRunPipelineRunsIndependentStagesConcurrently(t *testing.T) {
+	// stage1 can only finish once stage2 has run, so this only passes when
+	// both stages run at the same time.
+	e, _ := testExecutor(t, []*factory.Stage{
+		{Name: "stage1", RunBlocks: []factory.RunBlock{
+			{Name: "wait", Commands: []string{"while [ ! -f ready ]; do sleep 0.01; done"}},
+		}},
+		{Name: "stage2", RunBlocks: []factory.RunBlock{
+			{Name: "signal", Commands: []string{"touch ready"}},
+		}},
+		{Name: "stage3", RunBlocks: []factory.RunBlock{
+			{Name: "check", Commands: []string{"test -f ready"}},
+		}},
+	}, []*factory.StageDefinition{
+		{Name: "stage1"},
+		{Name: "stage2"},
+		{Name: "stage3", DependsOn: []string{"stage1", "stage2"}},
+	})
+	e.Parallelism = 2
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	result, err := e.RunPipeline(ctx, "test")
+	if err != nil {
+		t.Fatalf("Error running pipeline: %s", err)
+	}
+
+	assert.Equal(t, StatusSucceeded, result.Status)
+	for _, stage := range result.Stages {
+		assert.Equal(t, StatusSucceeded, stage.Status, "Expected stage %s to succeed got %s", stage.Name, stage.Status)
+	}
+}
+
+func TestRunPipelineSkipsDependentsOfFailedStage(t *testing.T) {
+	e, _ := testExecutor(t, []*factory.Stage{
+		{Name: "build", RunBlocks: []factory.RunBlock{{Name: "fail", Commands: []string{"exit 1"}}}},
+		{Name: "test", RunBlocks: []factory.RunBlock{{Name: "ok", Commands: []string{"true"}}}},
+		{Name: "deploy", RunBlocks: []factory.RunBlock{{Name: "ok", Commands: []string{"true"}}}},
+		{Name: "lint", RunBlocks: []factory.RunBlock{{Name: "ok", Commands: []string{"true"}}}},
+	}, []*factory.StageDefinition{
+		{Name: "build"},
+		{Name: "test", DependsOn: []string{"build"}},
+		{Name: "deploy", DependsOn: []string{"test"}},
+		{Name: "lint"},
+	})
+
+	result, err := e.RunPipeline(context.Background(), "test")
+	if err != nil {
+		t.Fatalf("Error running pipeline: %s", err)
+	}
+
+	assert.Equal(t, StatusFailed, result.Status)
+	statuses := make([]Status, 0, len(result.Stages))
+	for _, stage := range result.Stages {
+		statuses = append(statuses, stage.Status)
+	}
+	assert.Equal(t, []Status{StatusFailed, StatusSkipped, StatusSkipped, StatusSucceeded}, statuses)
+}
+
+func TestRunPipelineCancelled(t *testing.T) {
+	e, _ := testExecutor(t, []*factory.Stage{
+		{Name: "build", RunBlocks: []factory.RunBlock{{Name: "ok", Commands: []string{"true"}}}},
+		{Name: "deploy", RunBlocks: []factory.RunBlock{{Name: "ok", Commands: []string{"true"}}}},
+	}, []*factory.StageDefinition{
+		{Name: "build"},
+		{Name: "deploy", DependsOn: []string{"build"}},
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	result, err := e.RunPipeline(ctx, "test")
+	if err != nil {
+		t.Fatalf("Error running pipeline: %s", err)
+	}
+
+	assert.Equal(t, StatusCancelled, result.Status)
+	for _, stage := range result.Stages {
+		assert.Equal(t, StatusCancelled, stage.Status)
+		assert.Equal(t, StatusCancelled, stage.Runs[0].Status)
+	}
+}
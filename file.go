@@ -5,58 +5,249 @@ import (
 	"strings"
 
 	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclwrite"
 	"github.com/zclconf/go-cty/cty"
+	"github.com/zclconf/go-cty/cty/function"
 )
 
 type File struct {
 	Pipelines []*Pipeline
 	Variables *Variables
 	Stages    []*Stage
+
+	// Locals holds the values of the file's locals blocks, and
+	// StageLocals those of the locals blocks of each stage, keyed by stage
+	// name.
+	Locals      map[string]cty.Value
+	StageLocals map[string]map[string]cty.Value
+
+	// outputReferences holds the references of each stage to the outputs
+	// of other stages, keyed by stage name, see outputReferences.
+	outputReferences map[string][]hcl.Traversal
+
+	// functions are the functions available in the file's expressions,
+	// see Functions.
+	functions map[string]function.Function
+
+	// runContext holds the values of the run context namespaces, or nil
+	// if they are unknown.
+	runContext *RunContext
+
+	// scope holds the variables of every file loaded together with this
+	// one, which expressions in the file can refer to. If nil, only the
+	// file's own Variables are in scope.
+	scope *Variables
 }
 
-func (f *File) GetEvalContext(scopeID *string) *hcl.EvalContext {
-	v := f.Variables
-	// No Scope, get the global context
-	if scopeID == nil {
-		if len(v.GlobalVariables) == 0 {
-			return nil
+// scopeVariables returns the variables expressions in the file can refer
+// to.
+func (f *File) scopeVariables() *Variables {
+	if f.scope != nil {
+		return f.scope
+	}
+	return f.Variables
+}
+
+// insertGlobal stores the value of a global variable assigned in the file,
+// converted to its declared type if possible. Problems with the value are
+// reported once all files are merged, see prepareDeclared.
+func (f *File) insertGlobal(name string, value cty.Value, rng hcl.Range) {
+	if decl, ok := f.scopeVariables().Declarations[name]; ok {
+		if prepared, d := decl.Prepare(value, rng); !d.HasErrors() {
+			value = prepared
 		}
+	}
+
+	f.Variables.InsertGlobal(name, &value)
+	f.Variables.GlobalVariableRanges[name] = rng
+
+	// When the same variable is assigned in several files, the first
+	// assignment is in scope and the others are reported as duplicates.
+	if f.scope != nil {
+		if _, ok := f.scope.GlobalVariables[name]; !ok {
+			f.scope.InsertGlobal(name, &value)
+			f.scope.GlobalVariableRanges[name] = rng
+		}
+	}
+}
+
+// insertStage stores the value of a variable assigned in the variables block
+// of the stage scopeID.
+func (f *File) insertStage(name string, value cty.Value, scopeID string) {
+	f.Variables.InsertStage(name, &value, scopeID)
+	if f.scope != nil {
+		f.scope.InsertStage(name, &value, scopeID)
+	}
+}
 
-		return &hcl.EvalContext{
-			Variables: map[string]cty.Value{
-				"var": cty.MapVal(v.GlobalVariables),
-			},
+// GetEvalContext returns the context expressions in the file are evaluated
+// in: at the top level of the file if scopeID is nil, or else inside the
+// stage scopeID.
+func (f *File) GetEvalContext(scopeID *string) *hcl.EvalContext {
+	scope := evalScope{stage: scopeID}
+	if scopeID != nil {
+		scope.stageVariables = f.scopeVariables().StageVariables[*scopeID]
+		scope.stageLocals = f.StageLocals[*scopeID]
+		scope.outputReferences = f.outputReferences[*scopeID]
+	}
+	return f.evalContext(scope)
+}
+
+// evalScope holds the values an evaluation context layers over the
+// variables and locals of a file.
+type evalScope struct {
+	// stage is the name of the stage expressions are evaluated in, or nil
+	// at the top level of the file.
+	stage *string
+
+	// pipelineVariables, stageVariables and definitionVariables are the
+	// values of the variables blocks of the pipeline that runs the stage,
+	// of the stage and of the pipeline's definition of the stage, in
+	// increasing order of precedence. Any of them can be nil.
+	pipelineVariables   map[string]cty.Value
+	stageVariables      map[string]cty.Value
+	definitionVariables map[string]cty.Value
+
+	// stageLocals are the locals of the stage, which take precedence over
+	// the locals of the file.
+	stageLocals map[string]cty.Value
+
+	// secrets are the secrets of the stage, or nil if they are unknown.
+	secrets Secrets
+
+	// outputReferences are the references of the stage to the outputs of
+	// other stages, and outputs holds the outputs of the stages it depends
+	// on once they have run, keyed by stage name.
+	outputReferences []hcl.Traversal
+	outputs          map[string]Outputs
+}
+
+// stageNamespace returns the value of the stage namespace: the name of the
+// enclosing stage, and the outputs of the other stages it refers to. The
+// outputs of a stage are unknown until it has run. A stage named "name"
+// cannot be referred to, as stage.name is the name of the enclosing stage.
+func (es evalScope) stageNamespace() cty.Value {
+	attrs := map[string]cty.Value{
+		"name": cty.StringVal(*es.stage),
+	}
+	for _, ref := range es.outputReferences {
+		attrs[ref[1].(hcl.TraverseAttr).Name] = cty.DynamicVal
+	}
+	for name, outputs := range es.outputs {
+		if name != "name" {
+			attrs[name] = cty.ObjectVal(map[string]cty.Value{
+				"outputs": outputs.Value(),
+			})
 		}
 	}
-	// Combine the stage Scope with the global scope, overriding global variables
+	return cty.ObjectVal(attrs)
+}
+
+func (f *File) evalContext(es evalScope) *hcl.EvalContext {
+	v := f.scopeVariables()
+	// Combine the pipeline, stage and stage definition scopes with the
+	// global scope, each overriding the ones before it, then apply any
+	// overrides on top.
 	scope := make(map[string]cty.Value)
-	// First add the global variables
+	// Start with the defaults of declared variables
+	for k, decl := range v.Declarations {
+		if !decl.Required() {
+			scope[k] = decl.Default
+		}
+	}
+	// Then add the global variables
 	for k, v := range v.GlobalVariables {
 		scope[k] = v
 	}
-	if stageScope, ok := v.StageVariables[*scopeID]; ok {
-		// Add the stage scope
-		for k, v := range stageScope {
-			scope[k] = v
+	// Objects are merged into the objects of the same name of the scopes
+	// below, attribute by attribute.
+	for _, layer := range []map[string]cty.Value{es.pipelineVariables, es.stageVariables, es.definitionVariables} {
+		for k, v := range layer {
+			scope[k] = deepMerge(scope[k], v)
+		}
+	}
+	for k, v := range v.Overrides {
+		scope[k] = v
+	}
+	// Values assigned to sensitive variables are marked by Prepare, but
+	// stage values and defaults that failed to convert are not.
+	for k, decl := range v.Declarations {
+		if val, ok := scope[k]; ok && decl.Sensitive {
+			scope[k] = val.Mark(SensitiveMark)
 		}
 	}
 
-	// Cannot create MapVal with an empty map, so return nil
-	if len(scope) == 0 {
-		return nil
+	// An object rather than a map, so that variables of different types
+	// can live side by side.
+	// Stage locals take precedence over the locals of the file
+	locals := make(map[string]cty.Value)
+	for k, v := range f.Locals {
+		locals[k] = v
+	}
+	for k, v := range es.stageLocals {
+		locals[k] = v
 	}
+
+	variables := f.runContext.variables(es.stage)
+	if es.stage != nil {
+		variables["stage"] = es.stageNamespace()
+		if es.secrets != nil {
+			variables["secret"] = es.secrets.Value()
+		}
+	}
+	variables["var"] = cty.ObjectVal(scope)
+	variables["local"] = cty.ObjectVal(locals)
 	return &hcl.EvalContext{
-		Variables: map[string]cty.Value{
-			"var": cty.MapVal(scope),
-		},
+		Variables: variables,
+		Functions: f.functions,
+	}
+}
+
+// functionContext returns a context holding the functions of the file but no
+// variables, for expressions that are evaluated before any variable is
+// known, such as filters and variable declarations.
+func (f *File) functionContext() *hcl.EvalContext {
+	return &hcl.EvalContext{Functions: f.functions}
+}
+
+// deepMerge merges override into base. When both are objects or maps their
+// attributes are merged recursively, otherwise override replaces base. The
+// marks of both are kept on the result.
+func deepMerge(base, override cty.Value) cty.Value {
+	if base == cty.NilVal || !isMergeable(base) || !isMergeable(override) {
+		return override
+	}
+
+	base, baseMarks := base.Unmark()
+	override, overrideMarks := override.Unmark()
+
+	merged := base.AsValueMap()
+	if merged == nil {
+		merged = make(map[string]cty.Value)
 	}
+	for k, v := range override.AsValueMap() {
+		if existing, ok := merged[k]; ok {
+			v = deepMerge(existing, v)
+		}
+		merged[k] = v
+	}
+	return cty.ObjectVal(merged).WithMarks(baseMarks, overrideMarks)
+}
+
+// isMergeable reports whether val is a known, non-null object or map.
+func isMergeable(val cty.Value) bool {
+	ty := val.Type()
+	return (ty.IsObjectType() || ty.IsMapType()) && val.IsWhollyKnown() && !val.IsNull()
 }
 
 func NewFile() *File {
 	return &File{
-		Pipelines: make([]*Pipeline, 0),
-		Variables: NewVariables(),
-		Stages:    make([]*Stage, 0),
+		Pipelines:   make([]*Pipeline, 0),
+		Variables:   NewVariables(),
+		Stages:      make([]*Stage, 0),
+		Locals:      make(map[string]cty.Value),
+		StageLocals: make(map[string]map[string]cty.Value),
+		functions:   Functions(nil, "."),
 	}
 }
 
@@ -66,35 +257,36 @@ func (f *File) String() string {
 	// Filters
 	sb.WriteString(fmt.Sprintf("%s Pipeline: [\n", indent(1)))
 	for _, pipeline := range f.Pipelines {
-		// Filter
-		filter := pipeline.Filter
 		sb.WriteString(fmt.Sprintf("%s %s: {\n", indent(2), pipeline.Name))
-		sb.WriteString(fmt.Sprintf("%s Filter: {\n", indent(3)))
-		sb.WriteString(fmt.Sprintf("%s Exclude: {\n", indent(4)))
-		sb.WriteString(fmt.Sprintf("%s Paths: [\n", indent(5)))
-		for _, path := range filter.Exclude.Paths {
-			sb.WriteString(fmt.Sprintf("%s %s\n", indent(6), path))
-		}
-		sb.WriteString(fmt.Sprintf("%s ]\n", indent(5)))
-		sb.WriteString(fmt.Sprintf("%s Branches: [\n", indent(5)))
-		for _, branch := range filter.Exclude.Branches {
-			sb.WriteString(fmt.Sprintf("%s %s\n", indent(6), branch))
-		}
-		sb.WriteString(fmt.Sprintf("%s ]\n", indent(5)))
-		sb.WriteString(fmt.Sprintf("%s }\n", indent(4)))
-		sb.WriteString(fmt.Sprintf("%s Include {\n", indent(4)))
-		sb.WriteString(fmt.Sprintf("%s Paths: [\n", indent(5)))
-		for _, path := range filter.Include.Paths {
-			sb.WriteString(fmt.Sprintf("%s %s\n", indent(6), path))
-		}
-		sb.WriteString(fmt.Sprintf("%s ]\n", indent(5)))
-		sb.WriteString(fmt.Sprintf("%s Branches: [\n", indent(5)))
-		for _, branch := range filter.Include.Branches {
-			sb.WriteString(fmt.Sprintf("%s %s\n", indent(6), branch))
+		// Filter, if the pipeline has one
+		if filter := pipeline.Filter; filter != nil {
+			sb.WriteString(fmt.Sprintf("%s Filter: {\n", indent(3)))
+			sb.WriteString(fmt.Sprintf("%s Exclude: {\n", indent(4)))
+			sb.WriteString(fmt.Sprintf("%s Paths: [\n", indent(5)))
+			for _, path := range filter.Exclude.Paths {
+				sb.WriteString(fmt.Sprintf("%s %s\n", indent(6), path))
+			}
+			sb.WriteString(fmt.Sprintf("%s ]\n", indent(5)))
+			sb.WriteString(fmt.Sprintf("%s Branches: [\n", indent(5)))
+			for _, branch := range filter.Exclude.Branches {
+				sb.WriteString(fmt.Sprintf("%s %s\n", indent(6), branch))
+			}
+			sb.WriteString(fmt.Sprintf("%s ]\n", indent(5)))
+			sb.WriteString(fmt.Sprintf("%s }\n", indent(4)))
+			sb.WriteString(fmt.Sprintf("%s Include {\n", indent(4)))
+			sb.WriteString(fmt.Sprintf("%s Paths: [\n", indent(5)))
+			for _, path := range filter.Include.Paths {
+				sb.WriteString(fmt.Sprintf("%s %s\n", indent(6), path))
+			}
+			sb.WriteString(fmt.Sprintf("%s ]\n", indent(5)))
+			sb.WriteString(fmt.Sprintf("%s Branches: [\n", indent(5)))
+			for _, branch := range filter.Include.Branches {
+				sb.WriteString(fmt.Sprintf("%s %s\n", indent(6), branch))
+			}
+			sb.WriteString(fmt.Sprintf("%s ]\n", indent(5)))
+			sb.WriteString(fmt.Sprintf("%s }\n", indent(4)))
+			sb.WriteString(fmt.Sprintf("%s }\n", indent(3)))
 		}
-		sb.WriteString(fmt.Sprintf("%s ]\n", indent(5)))
-		sb.WriteString(fmt.Sprintf("%s }\n", indent(4)))
-		sb.WriteString(fmt.Sprintf("%s }\n", indent(3)))
 		// Stages
 		sb.WriteString(fmt.Sprintf("%s Stage Definitions: [\n", indent(3)))
 		for _, stage := range pipeline.Stages {
@@ -113,14 +305,14 @@ func (f *File) String() string {
 	// Variables
 	sb.WriteString(fmt.Sprintf("%s Global Variables: [\n", indent(2)))
 	for k, v := range f.Variables.GlobalVariables {
-		sb.WriteString(fmt.Sprintf("%s {%s: %s}\n", indent(3), k, v.AsString()))
+		sb.WriteString(fmt.Sprintf("%s {%s: %s}\n", indent(3), k, formatValue(v)))
 	}
 	sb.WriteString(fmt.Sprintf("%s ]\n", indent(2)))
 	sb.WriteString(fmt.Sprintf("%s Stage Variables: [\n", indent(2)))
 	for k, v := range f.Variables.StageVariables {
 		sb.WriteString(fmt.Sprintf("%s %s: [\n", indent(3), k))
 		for variable, value := range v {
-			sb.WriteString(fmt.Sprintf("%s {%s: %s}\n", indent(4), variable, value.AsString()))
+			sb.WriteString(fmt.Sprintf("%s {%s: %s}\n", indent(4), variable, formatValue(value)))
 		}
 		sb.WriteString(fmt.Sprintf("%s ]\n", indent(3)))
 	}
@@ -145,7 +337,22 @@ func (f *File) String() string {
 	}
 	sb.WriteString(fmt.Sprintf("%s ]\n", indent(2)))
 	sb.WriteString(fmt.Sprintf("%s }\n\n", indent(1)))
-	return sb.String()
+
+	// Commands hold the values of the variables they refer to.
+	return f.Redactor().Redact(sb.String())
+}
+
+// formatValue returns val as it would be written in HCL, with sensitive
+// values replaced by Redacted. Strings are returned as is.
+func formatValue(val cty.Value) string {
+	val = redactValue(val)
+	if !val.IsWhollyKnown() {
+		return "(unknown)"
+	}
+	if val.Type() == cty.String && !val.IsNull() {
+		return val.AsString()
+	}
+	return string(hclwrite.TokensForValue(val).Bytes())
 }
 
 func indent(spaces int) string {
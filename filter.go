@@ -1,6 +1,9 @@
 package factory
 
 import (
+	"fmt"
+	"strings"
+
 	"github.com/hashicorp/hcl/v2"
 	"github.com/zclconf/go-cty/cty"
 )
@@ -15,11 +18,99 @@ type Exclude struct {
 	Branches []string
 }
 
+// Filter decides whether a pipeline is triggered for a change. Paths and
+// branches are glob patterns matched with MatchGlob.
 type Filter struct {
 	Include Include
 	Exclude Exclude
 }
 
+// Matches reports whether a change to the given branch that modifies the
+// given paths triggers the pipeline, along with a human readable reason.
+//
+// The branch must not match any excluded branch and, if included branches
+// are declared, must match at least one of them. When path filters are
+// declared, at least one changed path must match an included path (or any
+// path if none are included) without matching an excluded path. Exclusions
+// therefore always take precedence over inclusions. A nil filter matches
+// every change.
+func (f *Filter) Matches(branch string, changedPaths []string) (bool, string) {
+	if f == nil {
+		return true, "the pipeline has no filter"
+	}
+
+	ok, branchReason := f.matchesBranch(branch)
+	if !ok {
+		return false, branchReason
+	}
+
+	ok, pathReason := f.matchesPaths(changedPaths)
+	if !ok {
+		return false, pathReason
+	}
+
+	return true, fmt.Sprintf("%s and %s", branchReason, pathReason)
+}
+
+func (f *Filter) matchesBranch(branch string) (bool, string) {
+	for _, pattern := range f.Exclude.Branches {
+		if ok, _ := MatchGlob(pattern, branch); ok {
+			return false, fmt.Sprintf("branch %q is excluded by %q", branch, pattern)
+		}
+	}
+
+	if len(f.Include.Branches) == 0 {
+		return true, "every branch is included"
+	}
+
+	for _, pattern := range f.Include.Branches {
+		if ok, _ := MatchGlob(pattern, branch); ok {
+			return true, fmt.Sprintf("branch %q is included by %q", branch, pattern)
+		}
+	}
+
+	return false, fmt.Sprintf("branch %q does not match any of %s", branch, quoteAll(f.Include.Branches))
+}
+
+func (f *Filter) matchesPaths(changedPaths []string) (bool, string) {
+	if len(f.Include.Paths) == 0 && len(f.Exclude.Paths) == 0 {
+		return true, "every path is included"
+	}
+
+	if len(changedPaths) == 0 {
+		return false, "no paths changed"
+	}
+
+paths:
+	for _, changed := range changedPaths {
+		for _, pattern := range f.Exclude.Paths {
+			if ok, _ := MatchGlob(pattern, changed); ok {
+				continue paths
+			}
+		}
+
+		if len(f.Include.Paths) == 0 {
+			return true, fmt.Sprintf("path %q is not excluded", changed)
+		}
+
+		for _, pattern := range f.Include.Paths {
+			if ok, _ := MatchGlob(pattern, changed); ok {
+				return true, fmt.Sprintf("path %q is included by %q", changed, pattern)
+			}
+		}
+	}
+
+	return false, fmt.Sprintf("none of the %d changed paths are included", len(changedPaths))
+}
+
+func quoteAll(values []string) string {
+	quoted := make([]string, len(values))
+	for i, v := range values {
+		quoted[i] = fmt.Sprintf("%q", v)
+	}
+	return strings.Join(quoted, ", ")
+}
+
 var filterBlockSchema = &hcl.BodySchema{
 	Attributes: []hcl.AttributeSchema{
 		{Name: "stages"},
@@ -30,7 +121,9 @@ var filterBlockSchema = &hcl.BodySchema{
 	},
 }
 
-func decodeFilterBlock(block *hcl.Block) (*Filter, hcl.Diagnostics) {
+// decodeFilterBlock decodes a filter block. Its paths and branches are
+// evaluated in ctx, which may hold functions but no variables.
+func decodeFilterBlock(block *hcl.Block, ctx *hcl.EvalContext) (*Filter, hcl.Diagnostics) {
 	content, diags := block.Body.Content(filterBlockSchema)
 	filter := &Filter{}
 
@@ -38,11 +131,11 @@ func decodeFilterBlock(block *hcl.Block) (*Filter, hcl.Diagnostics) {
 	for _, block := range content.Blocks {
 		switch block.Type {
 		case "include":
-			include, incDiags := decodeIncludeOrExcludeBlock(block)
+			include, incDiags := decodeIncludeOrExcludeBlock(block, ctx)
 			diags = append(diags, incDiags...)
 			filter.Include = *include.(*Include)
 		case "exclude":
-			exclude, exDiags := decodeIncludeOrExcludeBlock(block)
+			exclude, exDiags := decodeIncludeOrExcludeBlock(block, ctx)
 			diags = append(diags, exDiags...)
 			filter.Exclude = *exclude.(*Exclude)
 		}
@@ -51,7 +144,7 @@ func decodeFilterBlock(block *hcl.Block) (*Filter, hcl.Diagnostics) {
 	return filter, diags
 }
 
-func decodeIncludeOrExcludeBlock(block *hcl.Block) (interface{}, hcl.Diagnostics) {
+func decodeIncludeOrExcludeBlock(block *hcl.Block, ctx *hcl.EvalContext) (interface{}, hcl.Diagnostics) {
 	attributes, diags := block.Body.JustAttributes()
 	var result interface{}
 	switch block.Type {
@@ -66,14 +159,16 @@ func decodeIncludeOrExcludeBlock(block *hcl.Block) (interface{}, hcl.Diagnostics
 	for _, attr := range attributes {
 		switch attr.Name {
 		case "paths":
-			paths := decodeStringSliceAttribute(attr, &diags)
+			paths := decodeStringSliceAttribute(attr, ctx, &diags)
+			validateGlobAttribute(attr, paths, &diags)
 			if block.Type == "include" {
 				result.(*Include).Paths = paths
 			} else {
 				result.(*Exclude).Paths = paths
 			}
 		case "branches":
-			branches := decodeStringSliceAttribute(attr, &diags)
+			branches := decodeStringSliceAttribute(attr, ctx, &diags)
+			validateGlobAttribute(attr, branches, &diags)
 			if block.Type == "include" {
 				result.(*Include).Branches = branches
 			} else {
@@ -85,16 +180,61 @@ func decodeIncludeOrExcludeBlock(block *hcl.Block) (interface{}, hcl.Diagnostics
 	return result, diags
 }
 
-func decodeStringSliceAttribute(attr *hcl.Attribute, diags *hcl.Diagnostics) []string {
+// validateGlobAttribute adds an error diagnostic for every malformed glob
+// pattern among the decoded values of attr.
+func validateGlobAttribute(attr *hcl.Attribute, patterns []string, diags *hcl.Diagnostics) {
+	for _, pattern := range patterns {
+		if err := ValidateGlob(pattern); err != nil {
+			*diags = append(*diags, &hcl.Diagnostic{
+				Severity:   hcl.DiagError,
+				Summary:    "Invalid glob pattern",
+				Detail:     fmt.Sprintf("The pattern %q in %s is malformed: %s.", pattern, attr.Name, err),
+				Subject:    attr.Expr.Range().Ptr(),
+				Expression: attr.Expr,
+			})
+		}
+	}
+}
+
+// decodeStringSliceAttribute decodes the paths or branches attribute of a
+// filter in ctx, which must be a list of strings.
+func decodeStringSliceAttribute(attr *hcl.Attribute, ctx *hcl.EvalContext, diags *hcl.Diagnostics) []string {
 	var result []string
-	p, d := attr.Expr.Value(nil)
+	p, d := attr.Expr.Value(ctx)
 	*diags = append(*diags, d...)
+	if d.HasErrors() {
+		return result
+	}
+
+	ty := p.Type()
+	if p.IsNull() || !(ty.IsListType() || ty.IsTupleType() || ty.IsSetType()) {
+		*diags = append(*diags, &hcl.Diagnostic{
+			Severity:   hcl.DiagError,
+			Summary:    fmt.Sprintf("Invalid %s", attr.Name),
+			Detail:     fmt.Sprintf("The %s of a filter must be a list of strings.", attr.Name),
+			Subject:    attr.Expr.Range().Ptr(),
+			Expression: attr.Expr,
+		})
+		return result
+	}
+
 	for _, val := range p.AsValueSlice() {
 		if val.Type() != cty.String {
 			*diags = append(*diags, &hcl.Diagnostic{
 				Severity:   hcl.DiagError,
 				Summary:    "Value within paths or branches must be of string type",
 				Detail:     "Invalid type for branch or path",
+				Subject:    attr.Expr.Range().Ptr(),
+				Expression: attr.Expr,
+			})
+			return []string{}
+		}
+		if val.IsNull() {
+			*diags = append(*diags, &hcl.Diagnostic{
+				Severity:   hcl.DiagError,
+				Summary:    fmt.Sprintf("Invalid %s", attr.Name),
+				Detail:     fmt.Sprintf("The %s of a filter cannot contain null.", attr.Name),
+				Subject:    attr.Expr.Range().Ptr(),
 				Expression: attr.Expr,
 			})
 			return []string{}
@@ -28,7 +28,7 @@ func TestDecodeFilterBlock(t *testing.T) {
 		t.Fatalf("Error decoding filter block: %s", diags)
 	}
 
-	filter, d := decodeFilterBlock(pipeline.Blocks[0])
+	filter, d := decodeFilterBlock(pipeline.Blocks[0], nil)
 	if d.HasErrors() {
 		t.Fatalf("Error decoding filter block: %s", d)
 	}
@@ -60,7 +60,7 @@ func TestDecodeFilterBlockReturnsErrorForIncorrectType(t *testing.T) {
 		t.Fatalf("Error decoding filter block: %s", diags)
 	}
 
-	_, d := decodeFilterBlock(pipeline.Blocks[0])
+	_, d := decodeFilterBlock(pipeline.Blocks[0], nil)
 	if !d.HasErrors() {
 		t.Error("Expected diags to have error but was empty")
 	}
@@ -72,3 +72,132 @@ func TestDecodeFilterBlockReturnsErrorForIncorrectType(t *testing.T) {
 		assert.Equal(t, "Invalid type for branch or path", err.Detail)
 	}
 }
+
+func TestFilterMatches(t *testing.T) {
+	filter := &Filter{
+		Include: Include{
+			Paths:    []string{"src/**"},
+			Branches: []string{"main", "feature/*"},
+		},
+		Exclude: Exclude{
+			Paths:    []string{"src/**/*.md"},
+			Branches: []string{"feature/wip-*"},
+		},
+	}
+
+	tests := []struct {
+		Branch string
+		Paths  []string
+		Match  bool
+		Reason string
+	}{
+		{"main", []string{"src/a/main.go"}, true, `branch "main" is included by "main" and path "src/a/main.go" is included by "src/**"`},
+		{"feature/login", []string{"README.md", "src/main.go"}, true, `branch "feature/login" is included by "feature/*" and path "src/main.go" is included by "src/**"`},
+		{"feature/wip-login", []string{"src/main.go"}, false, `branch "feature/wip-login" is excluded by "feature/wip-*"`},
+		{"dev", []string{"src/main.go"}, false, `branch "dev" does not match any of "main", "feature/*"`},
+		{"main", []string{"src/docs/README.md"}, false, "none of the 1 changed paths are included"},
+		{"main", []string{"docs/main.go"}, false, "none of the 1 changed paths are included"},
+		{"main", nil, false, "no paths changed"},
+	}
+
+	for _, test := range tests {
+		match, reason := filter.Matches(test.Branch, test.Paths)
+		assert.Equal(t, test.Match, match, "Expected %s %v to match: %t", test.Branch, test.Paths, test.Match)
+		assert.Equal(t, test.Reason, reason)
+	}
+}
+
+func TestFilterMatchesWithoutPatterns(t *testing.T) {
+	var nilFilter *Filter
+	match, reason := nilFilter.Matches("main", nil)
+	assert.True(t, match)
+	assert.Equal(t, "the pipeline has no filter", reason)
+
+	match, reason = (&Filter{}).Matches("main", nil)
+	assert.True(t, match)
+	assert.Equal(t, "every branch is included and every path is included", reason)
+
+	match, reason = (&Filter{Exclude: Exclude{Paths: []string{"docs/**"}}}).Matches("main", []string{"docs/a.md", "main.go"})
+	assert.True(t, match)
+	assert.Equal(t, `every branch is included and path "main.go" is not excluded`, reason)
+}
+
+func TestDecodeFilterBlockReturnsErrorForInvalidPattern(t *testing.T) {
+	parser := hclparse.NewParser()
+	file, _ := parser.ParseHCL([]byte(`
+			filter {
+				include {
+					paths = ["foo/[a-"]
+				}
+			}
+			stages = []
+	`), "test")
+
+	pipeline, diags := file.Body.Content(pipelineBlockSchema)
+	if diags.HasErrors() {
+		t.Fatalf("Error decoding filter block: %s", diags)
+	}
+
+	_, d := decodeFilterBlock(pipeline.Blocks[0], nil)
+	if assert.Len(t, d, 1) {
+		assert.Equal(t, "Invalid glob pattern", d[0].Summary)
+	}
+}
+
+func TestDecodeFilterBlockReturnsErrorForInvalidList(t *testing.T) {
+	for _, src := range []string{`paths = "x"`, `paths = null`, `branches = ["a", null]`, `branches = { a = "b" }`} {
+		parser := hclparse.NewParser()
+		file, _ := parser.ParseHCL([]byte("filter {\ninclude {\n"+src+"\n}\n}\nstages = []"), "test")
+		pipeline, diags := file.Body.Content(pipelineBlockSchema)
+		if diags.HasErrors() {
+			t.Fatalf("Error decoding filter block: %s", diags)
+		}
+
+		_, d := decodeFilterBlock(pipeline.Blocks[0], nil)
+		if assert.True(t, d.HasErrors(), "Expected errors for %s", src) {
+			assert.NotNil(t, d[0].Subject)
+		}
+	}
+}
+
+func FuzzDecodeFilterBlock(f *testing.F) {
+	f.Add(`paths = ["src/**"]`, `branches = ["main"]`)
+	f.Add(`paths = "x"`, `branches = null`)
+	f.Add(`paths = [1, null]`, `branches = [[]]`)
+	f.Add(`paths = ["["]`, `branches = {}`)
+
+	f.Fuzz(func(t *testing.T, include, exclude string) {
+		parser := hclparse.NewParser()
+		file, diags := parser.ParseHCL([]byte("filter {\ninclude {\n"+include+"\n}\nexclude {\n"+exclude+"\n}\n}\n"), "test")
+		if diags.HasErrors() {
+			return
+		}
+		pipeline, diags := file.Body.Content(pipelineBlockSchema)
+		if diags.HasErrors() || len(pipeline.Blocks) != 1 {
+			return
+		}
+
+		decodeFilterBlock(pipeline.Blocks[0], nil)
+	})
+}
+
+func TestPipelineFilterCallsFunctions(t *testing.T) {
+	config, diags := loadTestFiles(t, map[string]string{"test.hcl": `
+pipeline "build" {
+  filter {
+    include {
+      paths    = [format("%s/**", "src")]
+      branches = split(",", "main,release/*")
+    }
+  }
+  stages = []
+}
+`})
+	if diags.HasErrors() {
+		t.Fatalf("Error loading config: %s", diags)
+	}
+
+	filter := config.Pipelines["build"].Filter
+	assert.Equal(t, []string{"src/**"}, filter.Include.Paths)
+	assert.Equal(t, []string{"main", "release/*"}, filter.Include.Branches)
+}
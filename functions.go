@@ -0,0 +1,315 @@
+package factory
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"hash"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/hashicorp/hcl/v2/ext/tryfunc"
+	"github.com/spf13/afero"
+	"github.com/zclconf/go-cty/cty"
+	"github.com/zclconf/go-cty/cty/function"
+	"github.com/zclconf/go-cty/cty/function/stdlib"
+)
+
+// Functions returns the functions available in every expression, keyed by
+// name. The filesystem functions read files through fs, with relative paths
+// resolved from baseDir. A nil fs reads files from the operating system.
+func Functions(fs afero.Fs, baseDir string) map[string]function.Function {
+	if fs == nil {
+		fs = afero.NewOsFs()
+	}
+
+	return map[string]function.Function{
+		// String functions
+		"chomp":      stdlib.ChompFunc,
+		"endswith":   endsWithFunc,
+		"format":     stdlib.FormatFunc,
+		"formatlist": stdlib.FormatListFunc,
+		"indent":     stdlib.IndentFunc,
+		"join":       stdlib.JoinFunc,
+		"lower":      stdlib.LowerFunc,
+		"regex":      stdlib.RegexFunc,
+		"regexall":   stdlib.RegexAllFunc,
+		"replace":    stdlib.ReplaceFunc,
+		"split":      stdlib.SplitFunc,
+		"startswith": startsWithFunc,
+		"strlen":     stdlib.StrlenFunc,
+		"strrev":     stdlib.ReverseFunc,
+		"substr":     stdlib.SubstrFunc,
+		"title":      stdlib.TitleFunc,
+		"trim":       stdlib.TrimFunc,
+		"trimprefix": stdlib.TrimPrefixFunc,
+		"trimspace":  stdlib.TrimSpaceFunc,
+		"trimsuffix": stdlib.TrimSuffixFunc,
+		"upper":      stdlib.UpperFunc,
+
+		// Numeric functions
+		"abs":      stdlib.AbsoluteFunc,
+		"ceil":     stdlib.CeilFunc,
+		"floor":    stdlib.FloorFunc,
+		"log":      stdlib.LogFunc,
+		"max":      stdlib.MaxFunc,
+		"min":      stdlib.MinFunc,
+		"parseint": stdlib.ParseIntFunc,
+		"pow":      stdlib.PowFunc,
+		"signum":   stdlib.SignumFunc,
+
+		// Collection functions
+		"chunklist":       stdlib.ChunklistFunc,
+		"coalesce":        stdlib.CoalesceFunc,
+		"coalescelist":    stdlib.CoalesceListFunc,
+		"compact":         stdlib.CompactFunc,
+		"concat":          stdlib.ConcatFunc,
+		"contains":        stdlib.ContainsFunc,
+		"distinct":        stdlib.DistinctFunc,
+		"element":         stdlib.ElementFunc,
+		"flatten":         stdlib.FlattenFunc,
+		"index":           stdlib.IndexFunc,
+		"keys":            stdlib.KeysFunc,
+		"length":          stdlib.LengthFunc,
+		"lookup":          stdlib.LookupFunc,
+		"merge":           stdlib.MergeFunc,
+		"range":           stdlib.RangeFunc,
+		"reverse":         stdlib.ReverseListFunc,
+		"setintersection": stdlib.SetIntersectionFunc,
+		"setproduct":      stdlib.SetProductFunc,
+		"setsubtract":     stdlib.SetSubtractFunc,
+		"setunion":        stdlib.SetUnionFunc,
+		"slice":           stdlib.SliceFunc,
+		"sort":            stdlib.SortFunc,
+		"values":          stdlib.ValuesFunc,
+		"zipmap":          stdlib.ZipmapFunc,
+
+		// Type conversion functions
+		"can":      tryfunc.CanFunc,
+		"tobool":   stdlib.MakeToFunc(cty.Bool),
+		"tolist":   stdlib.MakeToFunc(cty.List(cty.DynamicPseudoType)),
+		"tomap":    stdlib.MakeToFunc(cty.Map(cty.DynamicPseudoType)),
+		"tonumber": stdlib.MakeToFunc(cty.Number),
+		"toset":    stdlib.MakeToFunc(cty.Set(cty.DynamicPseudoType)),
+		"tostring": stdlib.MakeToFunc(cty.String),
+		"try":      tryfunc.TryFunc,
+
+		// Encoding functions
+		"base64decode": base64DecodeFunc,
+		"base64encode": base64EncodeFunc,
+		"csvdecode":    stdlib.CSVDecodeFunc,
+		"jsondecode":   stdlib.JSONDecodeFunc,
+		"jsonencode":   stdlib.JSONEncodeFunc,
+		"urlencode":    urlEncodeFunc,
+
+		// Hash functions
+		"base64sha256": makeBase64HashFunc(sha256.New),
+		"base64sha512": makeBase64HashFunc(sha512.New),
+		"md5":          makeHashFunc(md5.New),
+		"sha1":         makeHashFunc(sha1.New),
+		"sha256":       makeHashFunc(sha256.New),
+		"sha512":       makeHashFunc(sha512.New),
+
+		// Filesystem functions
+		"basename":   basenameFunc,
+		"dirname":    dirnameFunc,
+		"file":       makeFileFunc(fs, baseDir, false),
+		"filebase64": makeFileFunc(fs, baseDir, true),
+		"fileexists": makeFileExistsFunc(fs, baseDir),
+
+		// Date and time functions
+		"formatdate": stdlib.FormatDateFunc,
+		"timeadd":    stdlib.TimeAddFunc,
+		"timestamp":  timestampFunc,
+	}
+}
+
+// startsWithFunc returns true if a string starts with a prefix.
+var startsWithFunc = function.New(&function.Spec{
+	Params: []function.Parameter{
+		{Name: "str", Type: cty.String},
+		{Name: "prefix", Type: cty.String},
+	},
+	Type: function.StaticReturnType(cty.Bool),
+	Impl: func(args []cty.Value, retType cty.Type) (cty.Value, error) {
+		return cty.BoolVal(strings.HasPrefix(args[0].AsString(), args[1].AsString())), nil
+	},
+})
+
+// endsWithFunc returns true if a string ends with a suffix.
+var endsWithFunc = function.New(&function.Spec{
+	Params: []function.Parameter{
+		{Name: "str", Type: cty.String},
+		{Name: "suffix", Type: cty.String},
+	},
+	Type: function.StaticReturnType(cty.Bool),
+	Impl: func(args []cty.Value, retType cty.Type) (cty.Value, error) {
+		return cty.BoolVal(strings.HasSuffix(args[0].AsString(), args[1].AsString())), nil
+	},
+})
+
+// base64EncodeFunc encodes a string with standard base64.
+var base64EncodeFunc = function.New(&function.Spec{
+	Params: []function.Parameter{
+		{Name: "str", Type: cty.String},
+	},
+	Type: function.StaticReturnType(cty.String),
+	Impl: func(args []cty.Value, retType cty.Type) (cty.Value, error) {
+		return cty.StringVal(base64.StdEncoding.EncodeToString([]byte(args[0].AsString()))), nil
+	},
+})
+
+// base64DecodeFunc decodes a standard base64 string, which must decode to
+// valid UTF-8.
+var base64DecodeFunc = function.New(&function.Spec{
+	Params: []function.Parameter{
+		{Name: "str", Type: cty.String},
+	},
+	Type: function.StaticReturnType(cty.String),
+	Impl: func(args []cty.Value, retType cty.Type) (cty.Value, error) {
+		decoded, err := base64.StdEncoding.DecodeString(args[0].AsString())
+		if err != nil {
+			return cty.UnknownVal(cty.String), function.NewArgErrorf(0, "failed to decode base64 data: %s", err)
+		}
+		if !utf8.Valid(decoded) {
+			return cty.UnknownVal(cty.String), function.NewArgErrorf(0, "the decoded result is not valid UTF-8")
+		}
+		return cty.StringVal(string(decoded)), nil
+	},
+})
+
+// urlEncodeFunc escapes a string so it can be placed in a URL query.
+var urlEncodeFunc = function.New(&function.Spec{
+	Params: []function.Parameter{
+		{Name: "str", Type: cty.String},
+	},
+	Type: function.StaticReturnType(cty.String),
+	Impl: func(args []cty.Value, retType cty.Type) (cty.Value, error) {
+		return cty.StringVal(url.QueryEscape(args[0].AsString())), nil
+	},
+})
+
+// makeHashFunc returns a function that hashes a string and returns the
+// hex encoded digest.
+func makeHashFunc(newHash func() hash.Hash) function.Function {
+	return function.New(&function.Spec{
+		Params: []function.Parameter{
+			{Name: "str", Type: cty.String},
+		},
+		Type: function.StaticReturnType(cty.String),
+		Impl: func(args []cty.Value, retType cty.Type) (cty.Value, error) {
+			h := newHash()
+			h.Write([]byte(args[0].AsString()))
+			return cty.StringVal(hex.EncodeToString(h.Sum(nil))), nil
+		},
+	})
+}
+
+// makeBase64HashFunc returns a function that hashes a string and returns
+// the base64 encoded digest.
+func makeBase64HashFunc(newHash func() hash.Hash) function.Function {
+	return function.New(&function.Spec{
+		Params: []function.Parameter{
+			{Name: "str", Type: cty.String},
+		},
+		Type: function.StaticReturnType(cty.String),
+		Impl: func(args []cty.Value, retType cty.Type) (cty.Value, error) {
+			h := newHash()
+			h.Write([]byte(args[0].AsString()))
+			return cty.StringVal(base64.StdEncoding.EncodeToString(h.Sum(nil))), nil
+		},
+	})
+}
+
+// basenameFunc returns the last element of a path.
+var basenameFunc = function.New(&function.Spec{
+	Params: []function.Parameter{
+		{Name: "path", Type: cty.String},
+	},
+	Type: function.StaticReturnType(cty.String),
+	Impl: func(args []cty.Value, retType cty.Type) (cty.Value, error) {
+		return cty.StringVal(filepath.Base(args[0].AsString())), nil
+	},
+})
+
+// dirnameFunc returns every element of a path but the last.
+var dirnameFunc = function.New(&function.Spec{
+	Params: []function.Parameter{
+		{Name: "path", Type: cty.String},
+	},
+	Type: function.StaticReturnType(cty.String),
+	Impl: func(args []cty.Value, retType cty.Type) (cty.Value, error) {
+		return cty.StringVal(filepath.Dir(args[0].AsString())), nil
+	},
+})
+
+// makeFileFunc returns a function that reads the contents of a file, as a
+// UTF-8 string or, if encodeBase64 is set, as base64.
+func makeFileFunc(fs afero.Fs, baseDir string, encodeBase64 bool) function.Function {
+	return function.New(&function.Spec{
+		Params: []function.Parameter{
+			{Name: "path", Type: cty.String},
+		},
+		Type: function.StaticReturnType(cty.String),
+		Impl: func(args []cty.Value, retType cty.Type) (cty.Value, error) {
+			path := resolvePath(baseDir, args[0].AsString())
+			src, err := afero.ReadFile(fs, path)
+			if err != nil {
+				return cty.UnknownVal(cty.String), function.NewArgErrorf(0, "failed to read %s: %s", path, err)
+			}
+
+			if encodeBase64 {
+				return cty.StringVal(base64.StdEncoding.EncodeToString(src)), nil
+			}
+			if !utf8.Valid(src) {
+				return cty.UnknownVal(cty.String), function.NewArgErrorf(0, "the contents of %s are not valid UTF-8; use filebase64 instead", path)
+			}
+			return cty.StringVal(string(src)), nil
+		},
+	})
+}
+
+// makeFileExistsFunc returns a function that reports whether a file
+// exists. It fails if the path exists but is not a regular file.
+func makeFileExistsFunc(fs afero.Fs, baseDir string) function.Function {
+	return function.New(&function.Spec{
+		Params: []function.Parameter{
+			{Name: "path", Type: cty.String},
+		},
+		Type: function.StaticReturnType(cty.Bool),
+		Impl: func(args []cty.Value, retType cty.Type) (cty.Value, error) {
+			path := resolvePath(baseDir, args[0].AsString())
+			info, err := fs.Stat(path)
+			if err != nil {
+				return cty.False, nil
+			}
+			if !info.Mode().IsRegular() {
+				return cty.UnknownVal(cty.Bool), function.NewArgErrorf(0, "%s is not a regular file", path)
+			}
+			return cty.True, nil
+		},
+	})
+}
+
+// resolvePath returns path, relative to baseDir unless it is absolute.
+func resolvePath(baseDir, path string) string {
+	if filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(baseDir, path)
+}
+
+// timestampFunc returns the current UTC time in RFC 3339 format.
+var timestampFunc = function.New(&function.Spec{
+	Params: []function.Parameter{},
+	Type:   function.StaticReturnType(cty.String),
+	Impl: func(args []cty.Value, retType cty.Type) (cty.Value, error) {
+		return cty.StringVal(time.Now().UTC().Format(time.RFC3339)), nil
+	},
+})
@@ -0,0 +1,108 @@
+package factory
+
+import (
+	"testing"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/zclconf/go-cty/cty"
+)
+
+func TestFunctions(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	afero.WriteFile(fs, "/project/scripts/deploy.sh", []byte("echo deploy\n"), 0644)
+
+	ctx := &hcl.EvalContext{
+		Variables: map[string]cty.Value{
+			"var": cty.ObjectVal(map[string]cty.Value{
+				"env":   cty.StringVal("prod"),
+				"zones": cty.ListVal([]cty.Value{cty.StringVal("a"), cty.StringVal("b")}),
+			}),
+		},
+		Functions: Functions(fs, "/project"),
+	}
+
+	tests := []struct {
+		Expr string
+		Want cty.Value
+	}{
+		{`upper(var.env)`, cty.StringVal("PROD")},
+		{`join(",", var.zones)`, cty.StringVal("a,b")},
+		{`format("%s-%d", var.env, 3)`, cty.StringVal("prod-3")},
+		{`startswith(var.env, "pr")`, cty.True},
+		{`endswith(var.env, "pr")`, cty.False},
+		{`length(var.zones)`, cty.NumberIntVal(2)},
+		{`contains(var.zones, "b")`, cty.True},
+		{`max(1, 5, 3)`, cty.NumberIntVal(5)},
+		{`tostring(5)`, cty.StringVal("5")},
+		{`try(var.missing, "fallback")`, cty.StringVal("fallback")},
+		{`base64encode("hello")`, cty.StringVal("aGVsbG8=")},
+		{`base64decode("aGVsbG8=")`, cty.StringVal("hello")},
+		{`urlencode("a b&c")`, cty.StringVal("a+b%26c")},
+		{`jsonencode({a = 1})`, cty.StringVal(`{"a":1}`)},
+		{`md5("hello")`, cty.StringVal("5d41402abc4b2a76b9719d911017c592")},
+		{`sha256("hello")`, cty.StringVal("2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824")},
+		{`file("scripts/deploy.sh")`, cty.StringVal("echo deploy\n")},
+		{`file("/project/scripts/deploy.sh")`, cty.StringVal("echo deploy\n")},
+		{`filebase64("scripts/deploy.sh")`, cty.StringVal("ZWNobyBkZXBsb3kK")},
+		{`fileexists("scripts/deploy.sh")`, cty.True},
+		{`fileexists("scripts/missing.sh")`, cty.False},
+		{`basename("scripts/deploy.sh")`, cty.StringVal("deploy.sh")},
+		{`dirname("scripts/deploy.sh")`, cty.StringVal("scripts")},
+		{`formatdate("YYYY-MM-DD", "2024-03-01T10:00:00Z")`, cty.StringVal("2024-03-01")},
+		{`timeadd("2024-03-01T10:00:00Z", "1h")`, cty.StringVal("2024-03-01T11:00:00Z")},
+	}
+
+	for _, test := range tests {
+		expr, diags := hclsyntax.ParseExpression([]byte(test.Expr), "test", hcl.InitialPos)
+		if diags.HasErrors() {
+			t.Fatalf("Error parsing %s: %s", test.Expr, diags)
+		}
+		got, diags := expr.Value(ctx)
+		if diags.HasErrors() {
+			t.Fatalf("Error evaluating %s: %s", test.Expr, diags)
+		}
+		assert.True(t, test.Want.RawEquals(got), "%s: want %#v, got %#v", test.Expr, test.Want, got)
+	}
+}
+
+func TestFunctionsReturnErrors(t *testing.T) {
+	ctx := &hcl.EvalContext{Functions: Functions(afero.NewMemMapFs(), "/project")}
+
+	for _, src := range []string{
+		`file("missing.sh")`,
+		`base64decode("not base64")`,
+		`upper(1, 2)`,
+	} {
+		expr, diags := hclsyntax.ParseExpression([]byte(src), "test", hcl.InitialPos)
+		if diags.HasErrors() {
+			t.Fatalf("Error parsing %s: %s", src, diags)
+		}
+		_, diags = expr.Value(ctx)
+		assert.True(t, diags.HasErrors(), "Expected errors for %s", src)
+	}
+}
+
+func TestFunctionsInConfigFiles(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	afero.WriteFile(fs, "/project/.factory/scripts/build.sh", []byte("make build"), 0644)
+	afero.WriteFile(fs, "/project/.factory/stages.hcl", []byte(`
+variables {
+  env = "prod"
+}
+stage "build" {
+  run "build" {
+    command = "${trimspace(file("scripts/build.sh"))} ENV=${upper(var.env)}"
+  }
+}
+`), 0644)
+
+	file, diags := NewParser(fs).LoadConfigFile("/project/.factory/stages.hcl")
+	if diags.HasErrors() {
+		t.Fatalf("Error loading config: %s", diags)
+	}
+
+	assert.Equal(t, []string{"make build ENV=PROD"}, file.Stages[0].RunBlocks[0].Commands)
+}
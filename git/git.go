@@ -0,0 +1,140 @@
+// Package git reads the state of a local git repository by shelling out to
+// the git command, so that pipeline filters can be evaluated against the
+// current branch and the files changed since a base revision.
+package git
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// Repository is a local git working tree.
+type Repository struct {
+	// Dir is the top-level directory of the working tree.
+	Dir string
+}
+
+// Info describes the state of a repository relative to a base revision.
+type Info struct {
+	// Branch is the name of the checked out branch, or empty if HEAD is
+	// detached.
+	Branch string
+
+	// Commit is the full hash of the HEAD commit.
+	Commit string
+
+	// ChangedFiles are the paths, relative to the top-level directory,
+	// changed between the base revision and the working tree. Empty when no
+	// base revision was given.
+	ChangedFiles []string
+}
+
+// Open returns the repository containing dir. It returns an error if git is
+// not installed or dir is not inside a git working tree.
+func Open(ctx context.Context, dir string) (*Repository, error) {
+	r := &Repository{Dir: dir}
+	top, err := r.git(ctx, "rev-parse", "--show-toplevel")
+	if err != nil {
+		return nil, err
+	}
+
+	r.Dir = strings.TrimSpace(top)
+	return r, nil
+}
+
+// Branch returns the name of the checked out branch, or an empty string if
+// HEAD is detached, as is common on build agents.
+func (r *Repository) Branch(ctx context.Context) (string, error) {
+	out, err := r.git(ctx, "rev-parse", "--abbrev-ref", "HEAD")
+	if err != nil {
+		return "", err
+	}
+
+	branch := strings.TrimSpace(out)
+	if branch == "HEAD" {
+		return "", nil
+	}
+	return branch, nil
+}
+
+// Head returns the full hash of the HEAD commit.
+func (r *Repository) Head(ctx context.Context) (string, error) {
+	out, err := r.git(ctx, "rev-parse", "--verify", "HEAD")
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(out), nil
+}
+
+// ChangedFiles returns the tracked files that differ between the base
+// revision and the working tree. This covers both commits made since base
+// and uncommitted changes; untracked files are not included. A base that
+// starts with "-" is rejected, as git would read it as an option.
+func (r *Repository) ChangedFiles(ctx context.Context, base string) ([]string, error) {
+	if strings.HasPrefix(base, "-") {
+		return nil, fmt.Errorf("invalid base revision %q", base)
+	}
+
+	out, err := r.git(ctx, "diff", "--name-only", "--no-renames", "-z", base, "--")
+	if err != nil {
+		return nil, err
+	}
+
+	var files []string
+	for _, file := range strings.Split(out, "\x00") {
+		if file != "" {
+			files = append(files, file)
+		}
+	}
+	return files, nil
+}
+
+// Info returns the branch, HEAD commit and, if base is not empty, the files
+// changed since base.
+func (r *Repository) Info(ctx context.Context, base string) (*Info, error) {
+	branch, err := r.Branch(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	commit, err := r.Head(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	info := &Info{
+		Branch: branch,
+		Commit: commit,
+	}
+	if base != "" {
+		info.ChangedFiles, err = r.ChangedFiles(ctx, base)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return info, nil
+}
+
+// git runs git with the given arguments inside the repository and returns
+// its standard output.
+func (r *Repository) git(ctx context.Context, args ...string) (string, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Dir = r.Dir
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		msg := strings.TrimSpace(stderr.String())
+		if msg == "" {
+			msg = err.Error()
+		}
+		return "", fmt.Errorf("git %s: %s", strings.Join(args, " "), msg)
+	}
+
+	return stdout.String(), nil
+}
@@ -0,0 +1,115 @@
+package git
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// initRepo creates a repository with a single commit on the "main" branch.
+func initRepo(t *testing.T) string {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+
+	dir := t.TempDir()
+	run(t, dir, "init", "-q", "-b", "main")
+	writeFile(t, dir, "README.md", "hello")
+	writeFile(t, dir, "src/main.go", "package main")
+	run(t, dir, "add", "-A")
+	run(t, dir, "commit", "-q", "-m", "initial")
+	return dir
+}
+
+func run(t *testing.T, dir string, args ...string) string {
+	t.Helper()
+	args = append([]string{"-c", "user.name=test", "-c", "user.email=test@example.com", "-c", "commit.gpgsign=false"}, args...)
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("git %v: %s: %s", args, err, out)
+	}
+	return string(out)
+}
+
+func writeFile(t *testing.T, dir, name, content string) {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestInfo(t *testing.T) {
+	dir := initRepo(t)
+	ctx := context.Background()
+
+	run(t, dir, "checkout", "-q", "-b", "feature/login")
+	writeFile(t, dir, "src/login.go", "package main")
+	run(t, dir, "add", "-A")
+	run(t, dir, "commit", "-q", "-m", "login")
+	writeFile(t, dir, "README.md", "changed")
+
+	repo, err := Open(ctx, filepath.Join(dir, "src"))
+	if err != nil {
+		t.Fatalf("Error opening repository: %s", err)
+	}
+
+	info, err := repo.Info(ctx, "main")
+	if err != nil {
+		t.Fatalf("Error reading repository: %s", err)
+	}
+
+	assert.Equal(t, "feature/login", info.Branch)
+	assert.Len(t, info.Commit, 40)
+	assert.Equal(t, []string{"README.md", "src/login.go"}, info.ChangedFiles)
+}
+
+func TestBranchDetachedHead(t *testing.T) {
+	dir := initRepo(t)
+	ctx := context.Background()
+	run(t, dir, "checkout", "-q", "--detach")
+
+	repo, err := Open(ctx, dir)
+	if err != nil {
+		t.Fatalf("Error opening repository: %s", err)
+	}
+
+	branch, err := repo.Branch(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, "", branch)
+}
+
+func TestOpenOutsideRepository(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+
+	_, err := Open(context.Background(), t.TempDir())
+	assert.Error(t, err)
+}
+
+func TestChangedFilesUnknownBase(t *testing.T) {
+	dir := initRepo(t)
+	ctx := context.Background()
+
+	repo, err := Open(ctx, dir)
+	if err != nil {
+		t.Fatalf("Error opening repository: %s", err)
+	}
+
+	_, err = repo.ChangedFiles(ctx, "does-not-exist")
+	assert.Error(t, err)
+
+	_, err = repo.ChangedFiles(ctx, "--output=changed.txt")
+	assert.ErrorContains(t, err, "invalid base revision")
+}
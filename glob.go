@@ -0,0 +1,70 @@
+package factory
+
+import (
+	"path"
+	"strings"
+)
+
+// MatchGlob reports whether name matches the shell pattern. Patterns use the
+// syntax of path.Match for each slash-separated segment, and additionally
+// support "**" as a whole segment to match zero or more segments. For
+// example "src/**/*.go" matches "src/main.go" and "src/a/b/main.go".
+//
+// The only possible returned error is path.ErrBadPattern, when the pattern
+// is malformed.
+func MatchGlob(pattern, name string) (bool, error) {
+	if err := ValidateGlob(pattern); err != nil {
+		return false, err
+	}
+
+	return matchSegments(strings.Split(pattern, "/"), strings.Split(cleanGlobName(name), "/")), nil
+}
+
+// ValidateGlob returns path.ErrBadPattern if the pattern is malformed.
+func ValidateGlob(pattern string) error {
+	for _, segment := range strings.Split(pattern, "/") {
+		if _, err := path.Match(segment, ""); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func matchSegments(pattern, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			// Collapse consecutive "**" segments, then try to match the
+			// rest of the pattern against every suffix of name.
+			for len(pattern) > 0 && pattern[0] == "**" {
+				pattern = pattern[1:]
+			}
+			if len(pattern) == 0 {
+				return true
+			}
+			for i := range name {
+				if matchSegments(pattern, name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+
+		if len(name) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], name[0]); !ok {
+			return false
+		}
+		pattern = pattern[1:]
+		name = name[1:]
+	}
+
+	return len(name) == 0
+}
+
+// cleanGlobName normalises a path or branch name before it is matched.
+func cleanGlobName(name string) string {
+	name = strings.TrimPrefix(name, "./")
+	name = strings.TrimPrefix(name, "/")
+	return name
+}
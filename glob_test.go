@@ -0,0 +1,46 @@
+package factory
+
+import (
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMatchGlob(t *testing.T) {
+	tests := []struct {
+		Pattern string
+		Name    string
+		Match   bool
+	}{
+		{"foo/*", "foo/bar", true},
+		{"foo/*", "foo/bar/baz", false},
+		{"foo/**", "foo/bar/baz", true},
+		{"foo/**", "foo", true},
+		{"**/*.go", "main.go", true},
+		{"**/*.go", "cmd/cli/main.go", true},
+		{"**/*.go", "cmd/cli/main.hcl", false},
+		{"src/**/test/*.hcl", "src/test/a.hcl", true},
+		{"src/**/test/*.hcl", "src/a/b/test/a.hcl", true},
+		{"src/**/test/*.hcl", "src/a/b/a.hcl", false},
+		{"**", "anything/at/all", true},
+		{"feature/*", "feature/login", true},
+		{"feature/*", "bugfix/login", false},
+		{"release-?", "release-1", true},
+		{"[a-c]*", "bar", true},
+		{"main", "./main", true},
+	}
+
+	for _, test := range tests {
+		match, err := MatchGlob(test.Pattern, test.Name)
+		if err != nil {
+			t.Fatalf("Error matching %q: %s", test.Pattern, err)
+		}
+		assert.Equal(t, test.Match, match, "Expected %q matching %q to be %t", test.Pattern, test.Name, test.Match)
+	}
+}
+
+func TestMatchGlobReturnsErrorForBadPattern(t *testing.T) {
+	_, err := MatchGlob("foo/[a-", "foo/a")
+	assert.ErrorIs(t, err, path.ErrBadPattern)
+}
@@ -4,12 +4,14 @@ go 1.20
 
 require (
 	github.com/mitchellh/cli v1.1.5
+	github.com/pmezard/go-difflib v1.0.0
 	github.com/stretchr/testify v1.9.0
+	golang.org/x/term v0.10.0
 )
 
 require (
 	github.com/davecgh/go-spew v1.1.1 // indirect
-	github.com/pmezard/go-difflib v1.0.0 // indirect
+	github.com/google/go-cmp v0.5.4 // indirect
 	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
 
@@ -39,6 +41,6 @@ require (
 	github.com/spf13/cast v1.3.1 // indirect
 	github.com/zclconf/go-cty v1.13.0
 	golang.org/x/crypto v0.0.0-20220722155217-630584e8d5aa // indirect
-	golang.org/x/sys v0.0.0-20220722155257-8c9f86f7a55f // indirect
+	golang.org/x/sys v0.10.0 // indirect
 	golang.org/x/text v0.3.8 // indirect
 )
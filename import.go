@@ -0,0 +1,249 @@
+package factory
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"path/filepath"
+	"strings"
+
+	"github.com/factorycicd/factory/module"
+	"github.com/hashicorp/hcl/v2"
+	"github.com/zclconf/go-cty/cty"
+)
+
+// importPipeline fetches the source of an imported pipeline and copies the
+// pipeline of the same name from it. If the source declares exactly one
+// pipeline it is used regardless of its name. The stages of the imported
+// pipeline are resolved against the stages declared by the source and kept
+// on its stage definitions, see StageDefinition.Stage, so that they do not
+// clash with the stages of the importing configuration or of other imports
+// of the same source. A filter declared by the importing pipeline takes
+// precedence over the imported one, and so do its variables.
+func (p *Parser) importPipeline(pipeline *Pipeline, baseDir string) hcl.Diagnostics {
+	files, diags := p.loadSource(pipeline.Source, pipeline.SourceRange, baseDir, pipeline.Variables)
+	if diags.HasErrors() {
+		return diags
+	}
+
+	var candidates []*Pipeline
+	var imported *Pipeline
+	stages := make(map[string]*Stage)
+	for _, f := range files {
+		for _, pl := range f.Pipelines {
+			candidates = append(candidates, pl)
+			if pl.Name == pipeline.Name {
+				imported = pl
+			}
+		}
+		for _, s := range f.Stages {
+			stages[s.Name] = s
+		}
+	}
+	if imported == nil && len(candidates) == 1 {
+		imported = candidates[0]
+	}
+	if imported == nil {
+		return append(diags, &hcl.Diagnostic{
+			Severity: hcl.DiagError,
+			Summary:  "Pipeline not found in source",
+			Detail:   fmt.Sprintf("The source %q does not declare a pipeline named %q.", pipeline.Source, pipeline.Name),
+			Subject:  pipeline.SourceRange.Ptr(),
+		})
+	}
+
+	log.Printf("[DEBUG] imported pipeline %q from %s", pipeline.Name, pipeline.Source)
+	pipeline.Stages = imported.Stages
+	for _, sd := range pipeline.Stages {
+		if sd.Stage == nil {
+			sd.Stage = stages[sd.Name]
+		}
+	}
+	if pipeline.Filter == nil {
+		pipeline.Filter = imported.Filter
+	}
+	for name, value := range imported.Variables {
+		if _, ok := pipeline.Variables[name]; !ok {
+			pipeline.Variables[name] = value
+		}
+	}
+
+	return diags
+}
+
+// importStage fetches the source of an imported stage and copies the run
+// blocks of the stage of the same name from it. If the source declares
+// exactly one stage it is used regardless of its name. The importing
+// stage's variables override the variables of the source.
+func (p *Parser) importStage(file *File, stage *Stage, baseDir string) hcl.Diagnostics {
+	imported, diags := p.loadStage(stage.Name, stage.Source, stage.SourceRange, baseDir, file.Variables.StageVariables[stage.Name])
+	if imported == nil {
+		return diags
+	}
+
+	// The run blocks are evaluated in the scope of the source, see
+	// Stage.EvalContext.
+	stage.RunBlocks = imported.RunBlocks
+	stage.file = imported.file
+	stage.variables = imported.variables
+	stage.locals = imported.locals
+	stage.outputReferences = imported.outputReferences
+	if file.outputReferences == nil {
+		file.outputReferences = make(map[string][]hcl.Traversal)
+	}
+	file.outputReferences[stage.Name] = imported.outputReferences
+	return diags
+}
+
+// importStageDefinition fetches the source of a pipeline's stage definition
+// and sets its Stage to the stage of the same name from it, or to the only
+// stage of the source. The definition's variables override the variables
+// of the source.
+func (p *Parser) importStageDefinition(sd *StageDefinition, baseDir string) hcl.Diagnostics {
+	imported, diags := p.loadStage(sd.Name, sd.Source, sd.SourceRange, baseDir, sd.Variables)
+	if imported == nil {
+		return diags
+	}
+
+	stage := *imported
+	stage.Name = sd.Name
+	stage.Source = sd.Source
+	stage.SourceRange = sd.SourceRange
+	stage.DeclRange = sd.DeclRange
+	sd.Stage = &stage
+	return diags
+}
+
+// loadStage fetches the given source and returns the stage named name from
+// it, or the only stage it declares. It returns nil if the source cannot be
+// loaded or does not declare such a stage.
+func (p *Parser) loadStage(name, addr string, rng hcl.Range, baseDir string, overrides map[string]cty.Value) (*Stage, hcl.Diagnostics) {
+	files, diags := p.loadSource(addr, rng, baseDir, overrides)
+	if diags.HasErrors() {
+		return nil, diags
+	}
+
+	var candidates []*Stage
+	var imported *Stage
+	for _, f := range files {
+		for _, s := range f.Stages {
+			candidates = append(candidates, s)
+			if s.Name == name {
+				imported = s
+			}
+		}
+	}
+	if imported == nil && len(candidates) == 1 {
+		imported = candidates[0]
+	}
+	if imported == nil {
+		return nil, append(diags, &hcl.Diagnostic{
+			Severity: hcl.DiagError,
+			Summary:  "Stage not found in source",
+			Detail:   fmt.Sprintf("The source %q does not declare a stage named %q.", addr, name),
+			Subject:  rng.Ptr(),
+		})
+	}
+
+	log.Printf("[DEBUG] imported stage %q from %s", name, addr)
+	return imported, diags
+}
+
+// unknownSourceDiagnostic is reported for a source that refers to run
+// context values, which are unknown while the configuration is loaded.
+func unknownSourceDiagnostic(rng hcl.Range) *hcl.Diagnostic {
+	return &hcl.Diagnostic{
+		Severity: hcl.DiagError,
+		Summary:  "Invalid source",
+		Detail:   "The source must be known when the configuration is loaded, so it cannot refer to run context values such as git.sha.",
+		Subject:  rng.Ptr(),
+	}
+}
+
+// loadSource fetches the given source address and loads every file in the
+// directory it resolves to, with overrides taking precedence over the
+// variables those files declare. Relative files run by the stages of the
+// source are resolved against that directory, see RunBlock.Dir.
+func (p *Parser) loadSource(addr string, rng hcl.Range, baseDir string, overrides map[string]cty.Value) ([]*File, hcl.Diagnostics) {
+	src, err := module.ParseSource(addr)
+	if err != nil {
+		return nil, hcl.Diagnostics{{
+			Severity: hcl.DiagError,
+			Summary:  "Invalid source address",
+			Detail:   err.Error(),
+			Subject:  rng.Ptr(),
+		}}
+	}
+
+	// Local sources are read through the parser's filesystem rather than
+	// fetched, so that they resolve the same way as the importing file.
+	path := src.URL
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(baseDir, path)
+	}
+	if src.Kind != module.KindLocal {
+		mod, err := p.modules.Fetch(context.Background(), src, baseDir)
+		if err != nil {
+			summary := "Failed to fetch source"
+			var mismatch *module.LockMismatchError
+			if errors.As(err, &mismatch) {
+				summary = "Source does not match lock file"
+			}
+			return nil, hcl.Diagnostics{{
+				Severity: hcl.DiagError,
+				Summary:  summary,
+				Detail:   err.Error(),
+				Subject:  rng.Ptr(),
+			}}
+		}
+		path = mod.Path
+	}
+
+	dir, err := filepath.Abs(path)
+	if err != nil {
+		dir = path
+	}
+	if p.importing[dir] {
+		return nil, hcl.Diagnostics{{
+			Severity: hcl.DiagError,
+			Summary:  "Import cycle",
+			Detail:   fmt.Sprintf("The source %q imports itself, directly or through other sources.", addr),
+			Subject:  rng.Ptr(),
+		}}
+	}
+	p.importing[dir] = true
+	defer delete(p.importing, dir)
+
+	paths, diags := p.DirFiles(path)
+	if diags.HasErrors() {
+		return nil, diags
+	}
+
+	inputs := make(InputValues, len(overrides))
+	for name, value := range overrides {
+		inputs[name] = &InputValue{Value: value}
+	}
+
+	log.Printf("[DEBUG] loading imported config files: %s", strings.Join(paths, ", "))
+	files, fileDiags := p.loadConfigFiles(paths, inputs)
+	diags = append(diags, fileDiags...)
+
+	vars := NewVariables()
+	for _, f := range files {
+		diags = append(diags, vars.merge(f.Variables)...)
+
+		// Files run by the stages of the source are part of it. Run
+		// blocks imported by the source itself already have their own.
+		for _, stage := range f.Stages {
+			for i := range stage.RunBlocks {
+				if stage.RunBlocks[i].Dir == "" {
+					stage.RunBlocks[i].Dir = dir
+				}
+			}
+		}
+	}
+	diags = append(diags, vars.prepareDeclared()...)
+
+	return files, diags
+}
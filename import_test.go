@@ -0,0 +1,366 @@
+package factory
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/factorycicd/factory/module"
+	"github.com/hashicorp/hcl/v2"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+)
+
+const importedPipelineSource = `
+pipeline "docker" {
+  filter {
+    include {
+      branches = ["main"]
+    }
+  }
+  stages = [
+    { name = "build" },
+    { name = "push", depends_on = ["build"] },
+  ]
+}
+variables {
+  image = "default"
+  tag   = "latest"
+}
+stage "build" {
+  run "build" {
+    command = "docker build -t ${var.image}:${var.tag} ."
+  }
+}
+stage "push" {
+  variables {
+    tag = "stage"
+  }
+  run "push" {
+    command = "docker push ${var.image}:${var.tag}"
+  }
+}
+`
+
+func TestImportLocalPipeline(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	afero.WriteFile(fs, "templates/docker/pipeline.hcl", []byte(importedPipelineSource), 0644)
+	afero.WriteFile(fs, "project/pipeline.hcl", []byte(`
+pipeline "docker" {
+  source = "../templates/docker"
+  variables {
+    image = "my-image"
+    tag   = "v1"
+  }
+}
+`), 0644)
+
+	file, diags := NewParser(fs).LoadConfigFile("project/pipeline.hcl")
+	if diags.HasErrors() {
+		t.Fatalf("Error loading file: %s", diags)
+	}
+
+	pipeline := file.Pipelines[0]
+	assert.Equal(t, "../templates/docker", pipeline.Source)
+	assert.Len(t, pipeline.Stages, 2)
+	assert.Equal(t, []string{"main"}, pipeline.Filter.Include.Branches)
+	// The imported stages belong to the pipeline, not to the file.
+	assert.Empty(t, file.Stages)
+	if assert.Len(t, pipeline.Stages, 2) && assert.NotNil(t, pipeline.Stages[1].Stage) {
+		// The caller's variables take precedence over both global and
+		// stage variables of the source.
+		assert.Equal(t, []string{"docker build -t my-image:v1 ."}, pipeline.Stages[0].Stage.RunBlocks[0].Commands)
+		assert.Equal(t, []string{"docker push my-image:v1"}, pipeline.Stages[1].Stage.RunBlocks[0].Commands)
+	}
+}
+
+func TestImportLocalStageOutputReferences(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	afero.WriteFile(fs, "templates/push/stage.hcl", []byte(`
+stage "push" {
+  run "push" {
+    command = "docker push app:${stage.build.outputs.tag}"
+  }
+}
+`), 0644)
+	afero.WriteFile(fs, "project/pipeline.hcl", []byte(`
+stage "build" {
+  run "build" {
+    command = "make"
+    outputs = ["tag"]
+  }
+}
+stage "push" {
+  source = "../templates/push"
+}
+pipeline "release" {
+  stages = [{ name = "build" }, { name = "push" }]
+}
+`), 0644)
+
+	files, diags := NewParser(fs).LoadFiles([]string{"project/pipeline.hcl"})
+	if diags.HasErrors() {
+		t.Fatalf("Error loading file: %s", diags)
+	}
+	config, diags := NewConfig(files)
+	if diags.HasErrors() {
+		t.Fatalf("Error merging files: %s", diags)
+	}
+
+	diags = config.Validate()
+	if assert.Len(t, diags, 1, "Got diagnostics %s", diags) {
+		assert.Equal(t, "Missing stage dependency", diags[0].Summary)
+		assert.Equal(t, "templates/push/stage.hcl", diags[0].Subject.Filename)
+	}
+}
+
+func TestImportLocalPipelineTwice(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	afero.WriteFile(fs, "templates/docker/pipeline.hcl", []byte(importedPipelineSource), 0644)
+	afero.WriteFile(fs, "project/pipeline.hcl", []byte(`
+pipeline "a" {
+  source = "../templates/docker"
+  variables {
+    image = "a"
+  }
+}
+pipeline "b" {
+  source = "../templates/docker"
+  variables {
+    image = "b"
+  }
+}
+stage "build" {
+  run "build" {
+    command = "make"
+  }
+}
+`), 0644)
+
+	files, diags := NewParser(fs).LoadFiles([]string{"project/pipeline.hcl"})
+	if diags.HasErrors() {
+		t.Fatalf("Error loading file: %s", diags)
+	}
+	config, diags := NewConfig(files)
+	if diags.HasErrors() {
+		t.Fatalf("Error merging files: %s", diags)
+	}
+	if diags := config.Validate(); diags.HasErrors() {
+		t.Fatalf("Error validating config: %s", diags)
+	}
+
+	for _, name := range []string{"a", "b"} {
+		stage, ok := config.StageFor(config.Pipelines[name].Stages[0])
+		if assert.True(t, ok) {
+			assert.Equal(t, []string{"docker build -t " + name + ":latest ."}, stage.RunBlocks[0].Commands)
+		}
+	}
+	assert.Equal(t, []string{"make"}, config.Stages["build"].RunBlocks[0].Commands)
+}
+
+func TestImportLocalStage(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	afero.WriteFile(fs, "templates/docker/pipeline.hcl", []byte(importedPipelineSource), 0644)
+	afero.WriteFile(fs, "project/stages.hcl", []byte(`
+stage "push" {
+  source = "../templates/docker"
+  variables {
+    image = "my-image"
+  }
+}
+`), 0644)
+
+	file, diags := NewParser(fs).LoadConfigFile("project/stages.hcl")
+	if diags.HasErrors() {
+		t.Fatalf("Error loading file: %s", diags)
+	}
+
+	assert.Len(t, file.Stages, 1)
+	assert.Equal(t, []string{"docker push my-image:stage"}, file.Stages[0].RunBlocks[0].Commands)
+}
+
+func TestImportLocalStageDefinition(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	afero.WriteFile(fs, "templates/docker/pipeline.hcl", []byte(importedPipelineSource), 0644)
+	afero.WriteFile(fs, "project/pipeline.hcl", []byte(`
+pipeline "release" {
+  stages {
+    build {}
+    push {
+      source     = "../templates/docker"
+      depends_on = ["build"]
+      variables {
+        image = "my-image"
+      }
+    }
+  }
+}
+stage "build" {
+  run "build" {
+    command = "make"
+  }
+}
+`), 0644)
+
+	file, diags := NewParser(fs).LoadConfigFile("project/pipeline.hcl")
+	if diags.HasErrors() {
+		t.Fatalf("Error loading file: %s", diags)
+	}
+
+	// The imported stage is only part of the pipeline.
+	assert.Len(t, file.Stages, 1)
+	push := file.Pipelines[0].Stages[1]
+	if assert.NotNil(t, push.Stage) {
+		assert.Equal(t, "push", push.Stage.Name)
+		assert.Equal(t, []string{"docker push my-image:stage"}, push.Stage.RunBlocks[0].Commands)
+	}
+
+	config, diags := NewConfig([]*File{file})
+	diags = append(diags, config.Validate()...)
+	if diags.HasErrors() {
+		t.Fatalf("Error validating config: %s", diags)
+	}
+	stage, ok := config.StageFor(push)
+	assert.True(t, ok)
+	assert.Equal(t, push.Stage, stage)
+}
+
+func TestImportReturnsErrors(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	afero.WriteFile(fs, "templates/docker/pipeline.hcl", []byte(importedPipelineSource), 0644)
+	afero.WriteFile(fs, "self/pipeline.hcl", []byte(`
+pipeline "self" {
+  source = "./"
+}
+`), 0644)
+
+	tests := []struct {
+		Src     string
+		Summary string
+	}{
+		{`stage "deploy" { source = "../templates/docker" }`, "Stage not found in source"},
+		{`stage "push" { source = "example.com/templates" }`, "Invalid source address"},
+		{`stage "push" { source = "../templates/missing" }`, "Failed to read module directory"},
+		{`pipeline "docker" { source = "../self" }`, "Import cycle"},
+		{`pipeline "docker" {
+			source = "../templates/docker"
+			stages = []
+		}`, "Conflicting source and stages"},
+		{`pipeline "docker" {
+			stages {
+				deploy { source = "../templates/docker" }
+			}
+		}`, "Stage not found in source"},
+		{`pipeline "docker" {}`, "Missing stages"},
+		{`stage "push" {
+			source = "../templates/docker"
+			run "build" { command = "make" }
+		}`, "Conflicting source and run blocks"},
+	}
+
+	for _, test := range tests {
+		afero.WriteFile(fs, "project/test.hcl", []byte(test.Src), 0644)
+		_, diags := NewParser(fs).LoadConfigFile("project/test.hcl")
+		if assert.True(t, diags.HasErrors(), "Expected errors for %s", test.Src) {
+			assert.Equal(t, test.Summary, diags.Errs()[0].(*hcl.Diagnostic).Summary)
+		}
+	}
+}
+
+// testTemplatesRepo creates a bare git repository with the imported
+// pipeline source in pipelines/docker, tagged v1.0.0.
+func testTemplatesRepo(t *testing.T) string {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+
+	work := t.TempDir()
+	os.MkdirAll(filepath.Join(work, "pipelines", "docker"), 0755)
+	os.WriteFile(filepath.Join(work, "pipelines", "docker", "pipeline.hcl"), []byte(importedPipelineSource), 0644)
+	bare := filepath.Join(t.TempDir(), "templates.git")
+	for _, args := range [][]string{
+		{"init", "-q", "-b", "main"},
+		{"add", "-A"},
+		{"commit", "-q", "-m", "initial"},
+		{"tag", "v1.0.0"},
+		{"clone", "-q", "--bare", work, bare},
+	} {
+		cmd := exec.Command("git", append([]string{"-c", "user.name=test", "-c", "user.email=test@example.com", "-c", "commit.gpgsign=false"}, args...)...)
+		cmd.Dir = work
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %s: %s", args, err, out)
+		}
+	}
+	return bare
+}
+
+// testGitProject writes a project importing the pipeline of the given
+// repository and returns the path of its file and its module cache.
+func testGitProject(t *testing.T, bare string) (string, string) {
+	project := t.TempDir()
+	path := filepath.Join(project, "pipeline.hcl")
+	os.WriteFile(path, []byte(`
+pipeline "docker" {
+  source = "git::file://`+bare+`//pipelines/docker?ref=v1.0.0"
+}
+`), 0644)
+	return path, filepath.Join(project, ".factory", "modules")
+}
+
+func TestImportGitPipeline(t *testing.T) {
+	path, cache := testGitProject(t, testTemplatesRepo(t))
+
+	parser := NewParser(nil)
+	parser.modules = module.NewFetcher(cache)
+	file, diags := parser.LoadConfigFile(path)
+	if diags.HasErrors() {
+		t.Fatalf("Error loading file: %s", diags)
+	}
+
+	if assert.Len(t, file.Pipelines[0].Stages, 2) && assert.NotNil(t, file.Pipelines[0].Stages[0].Stage) {
+		assert.Equal(t, []string{"docker build -t default:latest ."}, file.Pipelines[0].Stages[0].Stage.RunBlocks[0].Commands)
+	}
+}
+
+func TestImportVerifiesLock(t *testing.T) {
+	path, cache := testGitProject(t, testTemplatesRepo(t))
+	lockPath := filepath.Join(filepath.Dir(path), module.DefaultLockFile)
+
+	// Initialise the lock file.
+	parser := NewParser(nil)
+	parser.modules = module.NewFetcher(cache)
+	parser.modules.Lock = module.NewLock()
+	parser.modules.UpdateLock = true
+	if _, diags := parser.LoadConfigFile(path); diags.HasErrors() {
+		t.Fatalf("Error loading file: %s", diags)
+	}
+	if err := parser.modules.Lock.Save(lockPath); err != nil {
+		t.Fatalf("Error saving lock file: %s", err)
+	}
+
+	load := func() hcl.Diagnostics {
+		parser := NewParser(nil)
+		parser.modules = module.NewFetcher(cache)
+		diags := parser.LoadLockFile(lockPath)
+		_, loadDiags := parser.LoadConfigFile(path)
+		return append(diags, loadDiags...)
+	}
+
+	diags := load()
+	assert.False(t, diags.HasErrors(), "Expected no errors got %s", diags)
+
+	// Modify the cached source.
+	matches, _ := filepath.Glob(filepath.Join(cache, "*", "pipelines", "docker", "pipeline.hcl"))
+	if len(matches) != 1 {
+		t.Fatalf("Expected one cached source got %v", matches)
+	}
+	os.WriteFile(matches[0], []byte(importedPipelineSource+"\n# changed\n"), 0644)
+
+	diags = load()
+	if assert.True(t, diags.HasErrors()) {
+		assert.Equal(t, "Source does not match lock file", diags[0].Summary)
+		assert.Equal(t, path, diags[0].Subject.Filename)
+	}
+}
@@ -0,0 +1,66 @@
+package factory
+
+import (
+	"github.com/hashicorp/hcl/v2"
+	"github.com/zclconf/go-cty/cty"
+)
+
+// decodeLocalsBlocks returns the local values declared in the given locals
+// blocks. A name may only be declared once across all of them.
+//
+//	locals {
+//	  image = "${var.registry}/${var.name}"
+//	  tag   = "${local.image}:${git.short_sha}"
+//	}
+//
+// Locals declared at the top level of a file are available as local.<name>
+// anywhere in that file. Locals declared inside a stage are only available
+// inside the stage, where they take precedence over the file's locals.
+func decodeLocalsBlocks(blocks []*hcl.Block) ([]*namedExpr, hcl.Diagnostics) {
+	var diags hcl.Diagnostics
+	var locals []*namedExpr
+	declared := make(map[string]*namedExpr)
+
+	for _, block := range blocks {
+		assignments, d := decodeAssignments(block.Body)
+		diags = append(diags, d...)
+
+		for _, local := range assignments {
+			if existing, ok := declared[local.Name]; ok {
+				diags = append(diags, duplicateDiagnostic("local value", local.Name, existing.NameRange, local.NameRange))
+				continue
+			}
+			declared[local.Name] = local
+			locals = append(locals, local)
+		}
+	}
+
+	return locals, diags
+}
+
+// evaluateLocals evaluates the given locals in dependency order, storing
+// each value in dest as soon as it is known so that the locals evaluated
+// after it can refer to it through the context returned by evalCtx, which
+// must read dest. Locals that are part of a cycle, or fail to evaluate, are
+// set to an unknown value.
+func evaluateLocals(locals []*namedExpr, evalCtx func() *hcl.EvalContext, dest map[string]cty.Value) hcl.Diagnostics {
+	order, diags := sortByReferences(locals, "local", "local values", false)
+
+	for _, local := range order {
+		val, d := local.Expr.Value(evalCtx())
+		diags = append(diags, d...)
+		if d.HasErrors() {
+			val = cty.DynamicVal
+		}
+		dest[local.Name] = val
+	}
+
+	// Locals left out of the order are part of a cycle, or refer to one.
+	for _, local := range locals {
+		if _, ok := dest[local.Name]; !ok {
+			dest[local.Name] = cty.DynamicVal
+		}
+	}
+
+	return diags
+}
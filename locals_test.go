@@ -0,0 +1,85 @@
+package factory
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/zclconf/go-cty/cty"
+)
+
+func TestLocals(t *testing.T) {
+	config, diags := loadTestFiles(t, map[string]string{"test.hcl": `
+pipeline "release" {
+  stages = local.stages
+}
+stage "push" {
+  locals {
+    tag = "${local.image}:${stage.name}"
+  }
+  run "push" {
+    command = "docker push ${local.tag} ${local.registry}"
+  }
+}
+locals {
+  stages = [for name in local.stage_names : { name = name }]
+  image  = "${local.registry}/${var.name}"
+}
+locals {
+  registry    = upper(var.registry)
+  stage_names = ["push"]
+}
+variables {
+  name     = "app"
+  registry = "registry.example.com"
+}
+`})
+	if diags.HasErrors() {
+		t.Fatalf("Error loading config: %s", diags)
+	}
+
+	assert.Equal(t, []string{"docker push REGISTRY.EXAMPLE.COM/app:push REGISTRY.EXAMPLE.COM"}, config.Stages["push"].RunBlocks[0].Commands)
+	assert.Equal(t, "push", config.Pipelines["release"].Stages[0].Name)
+
+	file := config.Files[0]
+	assert.Equal(t, cty.StringVal("REGISTRY.EXAMPLE.COM/app"), file.Locals["image"])
+	assert.Equal(t, cty.StringVal("REGISTRY.EXAMPLE.COM/app:push"), file.StageLocals["push"]["tag"])
+	assert.NotContains(t, file.Locals, "tag")
+}
+
+func TestLocalsReturnErrors(t *testing.T) {
+	tests := []struct {
+		Src     string
+		Summary string
+		Detail  string
+		Line    int
+	}{
+		{`locals {
+			a = local.b
+			b = local.c
+			c = local.a
+		}`, "Cycle in local values", "local.a -> local.b -> local.c -> local.a", 2},
+		{`locals {
+			a = "${local.a}-suffix"
+		}`, "Cycle in local values", "local.a -> local.a", 2},
+		{`locals {
+			a = "a"
+		}
+		locals {
+			a = "b"
+		}`, "Duplicate local value", "", 5},
+		{`stage "s" {
+			locals {
+				a = local.missing
+			}
+		}`, "Unsupported attribute", "", 3},
+	}
+
+	for _, test := range tests {
+		_, diags := loadTestFiles(t, map[string]string{"test.hcl": test.Src})
+		if assert.True(t, diags.HasErrors(), "Expected errors for %s", test.Src) {
+			assert.Equal(t, test.Summary, diags[0].Summary, "Got %s", diags)
+			assert.Contains(t, diags[0].Detail, test.Detail)
+			assert.Equal(t, test.Line, diags[0].Subject.Start.Line)
+		}
+	}
+}
@@ -0,0 +1,392 @@
+package module
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// Module is a source that has been made available on the local filesystem.
+type Module struct {
+	Source *Source
+
+	// Dir is the root of the downloaded repository or archive, or the
+	// local directory for local sources.
+	Dir string
+
+	// Path is the directory containing the imported configuration, which
+	// is Dir joined with the source's subdirectory.
+	Path string
+
+	// Commit is the checked out commit of a git source.
+	Commit string
+
+	// Hash is the hash of the contents of Path, as returned by HashDir.
+	Hash string
+}
+
+// Fetcher downloads sources into a cache directory. A source that is
+// already in the cache is not downloaded again.
+type Fetcher struct {
+	// CacheDir is the directory downloaded sources are stored in.
+	CacheDir string
+
+	// Client is used to download tarballs. Defaults to http.DefaultClient.
+	Client *http.Client
+
+	// Lock, when set, pins every remote source. Git sources are checked
+	// out at their locked commit, and a source whose content does not match
+	// its locked hash, or that is not locked at all, fails to fetch.
+	Lock *Lock
+
+	// UpdateLock records every fetched remote source in Lock instead of
+	// failing when it is missing. Cached sources that no longer match their
+	// locked commit or hash are downloaded again.
+	UpdateLock bool
+
+	// fetched holds the address of every source fetched so far.
+	fetched map[string]bool
+}
+
+// NewFetcher creates and returns a new Fetcher that stores sources in
+// cacheDir.
+func NewFetcher(cacheDir string) *Fetcher {
+	return &Fetcher{
+		CacheDir: cacheDir,
+		Client:   http.DefaultClient,
+	}
+}
+
+// Fetch makes the source available locally and returns where it is stored.
+// Local sources are resolved relative to baseDir and are never copied.
+func (f *Fetcher) Fetch(ctx context.Context, src *Source, baseDir string) (*Module, error) {
+	mod := &Module{Source: src}
+
+	switch src.Kind {
+	case KindLocal:
+		mod.Dir = src.URL
+		if !filepath.IsAbs(mod.Dir) {
+			mod.Dir = filepath.Join(baseDir, mod.Dir)
+		}
+	case KindGit, KindTarball:
+		mod.Dir = filepath.Join(f.CacheDir, cacheKey(src))
+		if err := f.fetchRemote(ctx, mod); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unsupported source type %q", src.Kind)
+	}
+
+	mod.Path = filepath.Join(mod.Dir, filepath.FromSlash(src.Subdir))
+	info, err := os.Stat(mod.Path)
+	if err != nil {
+		return nil, fmt.Errorf("source %s does not contain the directory %q", src, src.Subdir)
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("source %s resolves to %s, which is not a directory", src, mod.Path)
+	}
+
+	if src.Kind != KindLocal {
+		if err := f.checkLock(mod); err != nil {
+			return nil, err
+		}
+	}
+
+	return mod, nil
+}
+
+// fetchRemote makes a git or tarball source available in the cache,
+// downloading it unless a usable copy is already cached.
+func (f *Fetcher) fetchRemote(ctx context.Context, mod *Module) error {
+	src := mod.Source
+	locked := f.locked(src)
+
+	if _, err := os.Stat(mod.Dir); err == nil {
+		log.Printf("[DEBUG] using cached source %s from %s", src, mod.Dir)
+		if err := mod.readCommit(ctx); err != nil {
+			return err
+		}
+
+		// The lock file may have moved on since the source was cached, or
+		// the cache may have been modified; when updating the lock,
+		// download the locked version again.
+		if !f.UpdateLock || locked == nil || mod.matches(locked) {
+			return nil
+		}
+		log.Printf("[INFO] cached source %s does not match the lock file, downloading it again", src)
+		if err := os.RemoveAll(mod.Dir); err != nil {
+			return fmt.Errorf("cannot remove outdated source %s from module cache: %w", src, err)
+		}
+	}
+
+	ref := src.Ref
+	if locked != nil && locked.Commit != "" {
+		ref = locked.Commit
+	}
+	if err := f.download(ctx, src, ref, mod.Dir); err != nil {
+		return err
+	}
+	return mod.readCommit(ctx)
+}
+
+// locked returns the lock entry of src, if any.
+func (f *Fetcher) locked(src *Source) *LockedSource {
+	if f.Lock == nil {
+		return nil
+	}
+	return f.Lock.Sources[src.Addr]
+}
+
+// checkLock hashes a fetched module and either records it in the lock or
+// verifies it against the lock.
+func (f *Fetcher) checkLock(mod *Module) error {
+	hash, err := HashDir(mod.Path)
+	if err != nil {
+		return fmt.Errorf("cannot hash source %s: %w", mod.Source, err)
+	}
+	mod.Hash = hash
+
+	if f.fetched == nil {
+		f.fetched = make(map[string]bool)
+	}
+	f.fetched[mod.Source.Addr] = true
+
+	if f.Lock == nil {
+		return nil
+	}
+
+	if f.UpdateLock {
+		f.Lock.Sources[mod.Source.Addr] = &LockedSource{
+			Addr:   mod.Source.Addr,
+			Commit: mod.Commit,
+			Hash:   mod.Hash,
+		}
+		return nil
+	}
+
+	locked := f.locked(mod.Source)
+	switch {
+	case locked == nil:
+		return &LockMismatchError{Addr: mod.Source.Addr, Reason: "is not recorded in the lock file"}
+	case locked.Commit != "" && locked.Commit != mod.Commit:
+		return &LockMismatchError{Addr: mod.Source.Addr, Reason: fmt.Sprintf("is at commit %s, but the lock file requires %s", mod.Commit, locked.Commit)}
+	case locked.Hash != mod.Hash:
+		return &LockMismatchError{Addr: mod.Source.Addr, Reason: fmt.Sprintf("has hash %s, but the lock file requires %s", mod.Hash, locked.Hash)}
+	}
+	return nil
+}
+
+// PruneLock removes every source from the lock that has not been fetched by
+// this Fetcher, so that the lock only lists sources that are still in use.
+func (f *Fetcher) PruneLock() {
+	if f.Lock == nil {
+		return
+	}
+	for addr := range f.Lock.Sources {
+		if !f.fetched[addr] {
+			delete(f.Lock.Sources, addr)
+		}
+	}
+}
+
+// matches reports whether a cached module is at the locked commit and has
+// the locked content.
+func (mod *Module) matches(locked *LockedSource) bool {
+	if locked.Commit != "" && locked.Commit != mod.Commit {
+		return false
+	}
+
+	hash, err := HashDir(filepath.Join(mod.Dir, filepath.FromSlash(mod.Source.Subdir)))
+	return err == nil && hash == locked.Hash
+}
+
+// readCommit sets the commit of a cached git source.
+func (mod *Module) readCommit(ctx context.Context) error {
+	if mod.Source.Kind != KindGit {
+		return nil
+	}
+
+	commit, err := gitOutput(ctx, mod.Dir, "rev-parse", "--verify", "HEAD")
+	if err != nil {
+		return fmt.Errorf("cannot read commit of source %s: %w", mod.Source, err)
+	}
+	mod.Commit = strings.TrimSpace(commit)
+	return nil
+}
+
+// download fetches src, checked out at ref if it is a git source, into a
+// temporary directory and moves it to dir once complete, so that an
+// interrupted download never leaves a partial cache entry behind.
+func (f *Fetcher) download(ctx context.Context, src *Source, ref string, dir string) error {
+	log.Printf("[INFO] downloading source %s", src)
+
+	if err := os.MkdirAll(f.CacheDir, 0755); err != nil {
+		return fmt.Errorf("cannot create module cache %s: %w", f.CacheDir, err)
+	}
+
+	tmp, err := os.MkdirTemp(f.CacheDir, ".download-")
+	if err != nil {
+		return fmt.Errorf("cannot create module cache %s: %w", f.CacheDir, err)
+	}
+	defer os.RemoveAll(tmp)
+
+	target := filepath.Join(tmp, "src")
+	switch src.Kind {
+	case KindGit:
+		err = fetchGit(ctx, src, ref, target)
+	case KindTarball:
+		err = f.fetchTarball(ctx, src, target)
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := os.Rename(target, dir); err != nil {
+		return fmt.Errorf("cannot store source %s in module cache: %w", src, err)
+	}
+	return nil
+}
+
+// fetchGit clones a git source into dir and checks out ref. The URL and ref
+// come from configuration that may itself have been imported, so neither may
+// be read as an option of git.
+func fetchGit(ctx context.Context, src *Source, ref string, dir string) error {
+	if strings.HasPrefix(src.URL, "-") {
+		return fmt.Errorf("invalid URL %q of source %s", src.URL, src)
+	}
+	if strings.HasPrefix(ref, "-") {
+		return fmt.Errorf("invalid ref %q of source %s", ref, src)
+	}
+
+	if _, err := gitOutput(ctx, "", "clone", "--quiet", "--", src.URL, dir); err != nil {
+		return fmt.Errorf("cannot clone source %s: %w", src, err)
+	}
+
+	if ref != "" {
+		commit, err := gitOutput(ctx, dir, "rev-parse", "--verify", "--quiet", ref+"^{commit}")
+		if err != nil {
+			return fmt.Errorf("cannot find ref %q of source %s: %w", ref, src, err)
+		}
+		if _, err := gitOutput(ctx, dir, "checkout", "--quiet", "--detach", strings.TrimSpace(commit)); err != nil {
+			return fmt.Errorf("cannot check out ref %q of source %s: %w", ref, src, err)
+		}
+	}
+	return nil
+}
+
+func gitOutput(ctx context.Context, dir string, args ...string) (string, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Dir = dir
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	// Never prompt for credentials; fail instead.
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", fmt.Errorf("%s", msg)
+		}
+		return "", err
+	}
+	return stdout.String(), nil
+}
+
+func (f *Fetcher) fetchTarball(ctx context.Context, src *Source, dir string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, src.URL, nil)
+	if err != nil {
+		return fmt.Errorf("invalid tarball URL %s: %w", src.URL, err)
+	}
+
+	client := f.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("cannot download source %s: %w", src, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("cannot download source %s: server responded %s", src, resp.Status)
+	}
+
+	if err := extractTarball(resp.Body, dir); err != nil {
+		return fmt.Errorf("cannot extract source %s: %w", src, err)
+	}
+	return nil
+}
+
+// extractTarball extracts the regular files and directories of a gzipped
+// tar archive into dir. Entries that would be written outside of dir are
+// rejected.
+func extractTarball(r io.Reader, dir string) error {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return err
+	}
+	defer gz.Close()
+
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		path := filepath.Join(dir, filepath.FromSlash(header.Name))
+		if path != dir && !strings.HasPrefix(path, dir+string(filepath.Separator)) {
+			return fmt.Errorf("archive entry %q is outside of the archive root", header.Name)
+		}
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(path, 0755); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+				return err
+			}
+			if err := writeFile(path, tr, os.FileMode(header.Mode).Perm()); err != nil {
+				return err
+			}
+		default:
+			log.Printf("[DEBUG] skipping archive entry %q of type %c", header.Name, header.Typeflag)
+		}
+	}
+}
+
+func writeFile(path string, r io.Reader, mode os.FileMode) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// cacheKey returns the name of the cache directory of a source. Sources
+// that only differ in their subdirectory share a cache entry.
+func cacheKey(src *Source) string {
+	sum := sha256.Sum256([]byte(string(src.Kind) + "|" + src.URL + "|" + src.Ref))
+	return hex.EncodeToString(sum[:8])
+}
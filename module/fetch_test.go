@@ -0,0 +1,182 @@
+package module
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// testGitRepo creates a bare repository with a "v1" tag containing
+// pipelines/docker/pipeline.hcl and a later commit on main that changes it.
+func testGitRepo(t *testing.T) string {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+
+	work := t.TempDir()
+	git := func(args ...string) {
+		t.Helper()
+		args = append([]string{"-c", "user.name=test", "-c", "user.email=test@example.com", "-c", "commit.gpgsign=false"}, args...)
+		cmd := exec.Command("git", args...)
+		cmd.Dir = work
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %s: %s", args, err, out)
+		}
+	}
+	write := func(content string) {
+		t.Helper()
+		path := filepath.Join(work, "pipelines", "docker", "pipeline.hcl")
+		os.MkdirAll(filepath.Dir(path), 0755)
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	git("init", "-q", "-b", "main")
+	write("v1")
+	git("add", "-A")
+	git("commit", "-q", "-m", "v1")
+	git("tag", "v1")
+	write("v2")
+	git("commit", "-q", "-am", "v2")
+
+	bare := filepath.Join(t.TempDir(), "templates.git")
+	git("clone", "-q", "--bare", work, bare)
+	return bare
+}
+
+func TestFetchGit(t *testing.T) {
+	bare := testGitRepo(t)
+	fetcher := NewFetcher(filepath.Join(t.TempDir(), "modules"))
+
+	for ref, expected := range map[string]string{"v1": "v1", "main": "v2"} {
+		src, err := ParseSource("git::file://" + bare + "//pipelines/docker?ref=" + ref)
+		if err != nil {
+			t.Fatalf("Error parsing source: %s", err)
+		}
+
+		mod, err := fetcher.Fetch(context.Background(), src, "")
+		if err != nil {
+			t.Fatalf("Error fetching source: %s", err)
+		}
+
+		assert.Equal(t, filepath.Join(mod.Dir, "pipelines", "docker"), mod.Path)
+		content, err := os.ReadFile(filepath.Join(mod.Path, "pipeline.hcl"))
+		assert.NoError(t, err)
+		assert.Equal(t, expected, string(content))
+	}
+}
+
+func TestFetchGitUsesCache(t *testing.T) {
+	bare := testGitRepo(t)
+	fetcher := NewFetcher(filepath.Join(t.TempDir(), "modules"))
+	src, _ := ParseSource("git::file://" + bare + "?ref=v1")
+
+	first, err := fetcher.Fetch(context.Background(), src, "")
+	if err != nil {
+		t.Fatalf("Error fetching source: %s", err)
+	}
+
+	// The second fetch must not need the repository any more.
+	os.RemoveAll(bare)
+	second, err := fetcher.Fetch(context.Background(), src, "")
+	if err != nil {
+		t.Fatalf("Error fetching cached source: %s", err)
+	}
+	assert.Equal(t, first.Dir, second.Dir)
+}
+
+func TestFetchGitMissingSubdir(t *testing.T) {
+	bare := testGitRepo(t)
+	fetcher := NewFetcher(filepath.Join(t.TempDir(), "modules"))
+	src, _ := ParseSource("git::file://" + bare + "//stages")
+
+	_, err := fetcher.Fetch(context.Background(), src, "")
+	assert.Error(t, err)
+}
+
+func TestFetchGitRejectsOptions(t *testing.T) {
+	bare := testGitRepo(t)
+	dir := filepath.Join(t.TempDir(), "src")
+
+	err := fetchGit(context.Background(), &Source{Kind: KindGit, URL: "--upload-pack=touch " + dir}, "", dir)
+	assert.ErrorContains(t, err, "invalid URL")
+
+	err = fetchGit(context.Background(), &Source{Kind: KindGit, URL: "file://" + bare}, "--orphan=x", dir)
+	assert.ErrorContains(t, err, "invalid ref")
+
+	err = fetchGit(context.Background(), &Source{Kind: KindGit, URL: "file://" + bare}, "missing", filepath.Join(t.TempDir(), "src"))
+	assert.ErrorContains(t, err, `cannot find ref "missing"`)
+}
+
+func TestFetchTarball(t *testing.T) {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	content := []byte(`stage "build" {}`)
+	tw.WriteHeader(&tar.Header{Name: "templates/", Typeflag: tar.TypeDir, Mode: 0755})
+	tw.WriteHeader(&tar.Header{Name: "templates/stages/build.hcl", Typeflag: tar.TypeReg, Mode: 0644, Size: int64(len(content))})
+	tw.Write(content)
+	tw.Close()
+	gz.Close()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/templates.tar.gz" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(buf.Bytes())
+	}))
+	defer server.Close()
+
+	fetcher := NewFetcher(filepath.Join(t.TempDir(), "modules"))
+	src, _ := ParseSource(server.URL + "/templates.tar.gz//templates/stages")
+
+	mod, err := fetcher.Fetch(context.Background(), src, "")
+	if err != nil {
+		t.Fatalf("Error fetching source: %s", err)
+	}
+
+	got, err := os.ReadFile(filepath.Join(mod.Path, "build.hcl"))
+	assert.NoError(t, err)
+	assert.Equal(t, content, got)
+
+	src, _ = ParseSource(server.URL + "/missing.tar.gz")
+	_, err = fetcher.Fetch(context.Background(), src, "")
+	assert.Error(t, err)
+}
+
+func TestExtractTarballRejectsEscapingEntries(t *testing.T) {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	tw.WriteHeader(&tar.Header{Name: "../escape.hcl", Typeflag: tar.TypeReg, Mode: 0644})
+	tw.Close()
+	gz.Close()
+
+	err := extractTarball(&buf, t.TempDir())
+	assert.Error(t, err)
+}
+
+func TestFetchLocal(t *testing.T) {
+	base := t.TempDir()
+	os.MkdirAll(filepath.Join(base, "stages", "docker"), 0755)
+	fetcher := NewFetcher(filepath.Join(t.TempDir(), "modules"))
+	src, _ := ParseSource("./stages/docker")
+
+	mod, err := fetcher.Fetch(context.Background(), src, base)
+	if err != nil {
+		t.Fatalf("Error fetching source: %s", err)
+	}
+	assert.Equal(t, filepath.Join(base, "stages", "docker"), mod.Path)
+}
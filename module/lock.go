@@ -0,0 +1,208 @@
+package module
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclparse"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/hashicorp/hcl/v2/hclwrite"
+	"github.com/zclconf/go-cty/cty"
+)
+
+// DefaultLockFile is the name of the lock file, relative to the working
+// directory.
+const DefaultLockFile = ".factory.lock.hcl"
+
+// LockedSource is the pinned state of a single source address.
+type LockedSource struct {
+	// Addr is the source address as written in the configuration.
+	Addr string
+
+	// Commit is the resolved git commit hash. Empty for tarballs.
+	Commit string
+
+	// Hash is a hash of every file of the imported directory, as returned
+	// by HashDir.
+	Hash string
+}
+
+// Lock records the pinned state of every remote source a configuration
+// imports, so that every run uses exactly the same content.
+type Lock struct {
+	Sources map[string]*LockedSource
+}
+
+// NewLock creates and returns an empty Lock.
+func NewLock() *Lock {
+	return &Lock{
+		Sources: make(map[string]*LockedSource),
+	}
+}
+
+// LockMismatchError is returned when a fetched source does not match the
+// state recorded in the lock file.
+type LockMismatchError struct {
+	Addr   string
+	Reason string
+}
+
+func (e *LockMismatchError) Error() string {
+	return fmt.Sprintf("source %s %s; run \"factory init\" to fetch the locked version, or \"factory init -upgrade\" to update the lock file", e.Addr, e.Reason)
+}
+
+var lockFileSchema = &hcl.BodySchema{
+	Blocks: []hcl.BlockHeaderSchema{
+		{Type: "source", LabelNames: []string{"address"}},
+	},
+}
+
+var lockedSourceSchema = &hcl.BodySchema{
+	Attributes: []hcl.AttributeSchema{
+		{Name: "commit"},
+		{Name: "hash", Required: true},
+	},
+}
+
+// LoadLockFile reads the lock file at path. If no file exists, it returns
+// nil and no diagnostics.
+func LoadLockFile(path string) (*Lock, hcl.Diagnostics) {
+	src, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, hcl.Diagnostics{{
+			Severity: hcl.DiagError,
+			Summary:  "Failed to read lock file",
+			Detail:   fmt.Sprintf("The lock file %q could not be read: %s.", path, err),
+		}}
+	}
+
+	file, diags := hclparse.NewParser().ParseHCL(src, path)
+	if diags.HasErrors() {
+		return nil, diags
+	}
+
+	content, contentDiags := file.Body.Content(lockFileSchema)
+	diags = append(diags, contentDiags...)
+
+	lock := NewLock()
+	for _, block := range content.Blocks {
+		attrs, attrDiags := block.Body.Content(lockedSourceSchema)
+		diags = append(diags, attrDiags...)
+		if attrDiags.HasErrors() {
+			continue
+		}
+
+		locked := &LockedSource{Addr: block.Labels[0]}
+		for name, attr := range attrs.Attributes {
+			val, d := attr.Expr.Value(nil)
+			diags = append(diags, d...)
+			if d.HasErrors() {
+				continue
+			}
+			if val.Type() != cty.String || val.IsNull() {
+				diags = append(diags, &hcl.Diagnostic{
+					Severity: hcl.DiagError,
+					Summary:  "Invalid lock file",
+					Detail:   fmt.Sprintf("The %q attribute must be a string.", name),
+					Subject:  attr.Expr.Range().Ptr(),
+				})
+				continue
+			}
+
+			switch name {
+			case "commit":
+				locked.Commit = val.AsString()
+			case "hash":
+				locked.Hash = val.AsString()
+			}
+		}
+		lock.Sources[locked.Addr] = locked
+	}
+
+	return lock, diags
+}
+
+// Save writes the lock file to path.
+func (l *Lock) Save(path string) error {
+	f := hclwrite.NewEmptyFile()
+	body := f.Body()
+	body.AppendUnstructuredTokens(hclwrite.Tokens{
+		{Type: hclsyntax.TokenComment, Bytes: []byte("# This file is maintained automatically by \"factory init\".\n# Manual edits may be lost in future updates.\n")},
+	})
+
+	addrs := make([]string, 0, len(l.Sources))
+	for addr := range l.Sources {
+		addrs = append(addrs, addr)
+	}
+	sort.Strings(addrs)
+
+	for _, addr := range addrs {
+		locked := l.Sources[addr]
+		body.AppendNewline()
+		block := body.AppendNewBlock("source", []string{addr}).Body()
+		if locked.Commit != "" {
+			block.SetAttributeValue("commit", cty.StringVal(locked.Commit))
+		}
+		block.SetAttributeValue("hash", cty.StringVal(locked.Hash))
+	}
+
+	return os.WriteFile(path, f.Bytes(), 0644)
+}
+
+// HashDir returns a hash of the names and contents of every regular file
+// in dir, ignoring git metadata. The result is prefixed with "h1:" so that
+// the algorithm can be changed in the future.
+func HashDir(dir string) (string, error) {
+	var files []string
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() && info.Name() == ".git" {
+			return filepath.SkipDir
+		}
+		if info.Mode().IsRegular() {
+			rel, err := filepath.Rel(dir, path)
+			if err != nil {
+				return err
+			}
+			files = append(files, filepath.ToSlash(rel))
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	sort.Strings(files)
+
+	summary := sha256.New()
+	for _, file := range files {
+		if strings.Contains(file, "\n") {
+			return "", fmt.Errorf("file name %q contains a newline", file)
+		}
+
+		f, err := os.Open(filepath.Join(dir, filepath.FromSlash(file)))
+		if err != nil {
+			return "", err
+		}
+		h := sha256.New()
+		_, err = io.Copy(h, f)
+		f.Close()
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(summary, "%x  %s\n", h.Sum(nil), file)
+	}
+
+	return "h1:" + base64.StdEncoding.EncodeToString(summary.Sum(nil)), nil
+}
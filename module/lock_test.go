@@ -0,0 +1,142 @@
+package module
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLockSaveAndLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), DefaultLockFile)
+	lock := NewLock()
+	lock.Sources["github.com/owner/repo//stages?ref=v1"] = &LockedSource{
+		Addr:   "github.com/owner/repo//stages?ref=v1",
+		Commit: "0123456789abcdef0123456789abcdef01234567",
+		Hash:   "h1:abc=",
+	}
+	lock.Sources["https://example.com/templates.tar.gz"] = &LockedSource{
+		Addr: "https://example.com/templates.tar.gz",
+		Hash: "h1:def=",
+	}
+
+	if err := lock.Save(path); err != nil {
+		t.Fatalf("Error saving lock file: %s", err)
+	}
+
+	loaded, diags := LoadLockFile(path)
+	if diags.HasErrors() {
+		t.Fatalf("Error loading lock file: %s", diags)
+	}
+	assert.Equal(t, lock, loaded)
+
+	missing, diags := LoadLockFile(filepath.Join(t.TempDir(), DefaultLockFile))
+	assert.Nil(t, missing)
+	assert.Empty(t, diags)
+}
+
+func TestFetchRecordsAndVerifiesLock(t *testing.T) {
+	bare := testGitRepo(t)
+	cache := filepath.Join(t.TempDir(), "modules")
+	src, _ := ParseSource("git::file://" + bare + "//pipelines/docker?ref=main")
+
+	// Record the source in a new lock.
+	lock := NewLock()
+	recorder := NewFetcher(cache)
+	recorder.Lock = lock
+	recorder.UpdateLock = true
+	mod, err := recorder.Fetch(context.Background(), src, "")
+	if err != nil {
+		t.Fatalf("Error fetching source: %s", err)
+	}
+
+	locked := lock.Sources[src.Addr]
+	if assert.NotNil(t, locked) {
+		assert.Equal(t, mod.Commit, locked.Commit)
+		assert.Len(t, locked.Commit, 40)
+		assert.Equal(t, mod.Hash, locked.Hash)
+	}
+
+	// A verifying fetcher accepts the cached source as is.
+	verifier := NewFetcher(cache)
+	verifier.Lock = lock
+	_, err = verifier.Fetch(context.Background(), src, "")
+	assert.NoError(t, err)
+
+	// Tampering with the cache is detected.
+	os.WriteFile(filepath.Join(mod.Path, "pipeline.hcl"), []byte("tampered"), 0644)
+	_, err = verifier.Fetch(context.Background(), src, "")
+	var mismatch *LockMismatchError
+	assert.True(t, errors.As(err, &mismatch), "Expected a LockMismatchError got %v", err)
+
+	// Updating the lock restores the tampered cache instead of recording it.
+	_, err = recorder.Fetch(context.Background(), src, "")
+	assert.NoError(t, err)
+	assert.Equal(t, mod.Hash, lock.Sources[src.Addr].Hash)
+	_, err = verifier.Fetch(context.Background(), src, "")
+	assert.NoError(t, err)
+
+	// A source missing from the lock is rejected.
+	other, _ := ParseSource("git::file://" + bare + "//pipelines/docker?ref=v1")
+	_, err = verifier.Fetch(context.Background(), other, "")
+	assert.True(t, errors.As(err, &mismatch), "Expected a LockMismatchError got %v", err)
+}
+
+func TestFetchChecksOutLockedCommit(t *testing.T) {
+	bare := testGitRepo(t)
+	v1, _ := ParseSource("git::file://" + bare + "?ref=v1")
+	main, _ := ParseSource("git::file://" + bare + "//pipelines/docker?ref=main")
+
+	// Lock "main" at the commit tagged v1.
+	lock := NewLock()
+	recorder := NewFetcher(filepath.Join(t.TempDir(), "modules"))
+	recorder.Lock = lock
+	recorder.UpdateLock = true
+	mod, err := recorder.Fetch(context.Background(), v1, "")
+	if err != nil {
+		t.Fatalf("Error fetching source: %s", err)
+	}
+	lock.Sources[main.Addr] = &LockedSource{Addr: main.Addr, Commit: mod.Commit}
+
+	fetcher := NewFetcher(filepath.Join(t.TempDir(), "modules"))
+	fetcher.Lock = lock
+	fetcher.UpdateLock = true
+	pinned, err := fetcher.Fetch(context.Background(), main, "")
+	if err != nil {
+		t.Fatalf("Error fetching source: %s", err)
+	}
+
+	content, _ := os.ReadFile(filepath.Join(pinned.Path, "pipeline.hcl"))
+	assert.Equal(t, "v1", string(content))
+	assert.Equal(t, mod.Commit, pinned.Commit)
+
+	// Sources that were not fetched are pruned from the lock.
+	fetcher.PruneLock()
+	assert.Len(t, lock.Sources, 1)
+	assert.Contains(t, lock.Sources, main.Addr)
+}
+
+func TestHashDir(t *testing.T) {
+	dir := t.TempDir()
+	os.MkdirAll(filepath.Join(dir, ".git"), 0755)
+	os.MkdirAll(filepath.Join(dir, "stages"), 0755)
+	os.WriteFile(filepath.Join(dir, "stages", "build.hcl"), []byte("build"), 0644)
+
+	first, err := HashDir(dir)
+	if err != nil {
+		t.Fatalf("Error hashing directory: %s", err)
+	}
+	assert.Regexp(t, `^h1:`, first)
+
+	// Git metadata is ignored.
+	os.WriteFile(filepath.Join(dir, ".git", "HEAD"), []byte("ref"), 0644)
+	second, _ := HashDir(dir)
+	assert.Equal(t, first, second)
+
+	os.WriteFile(filepath.Join(dir, "stages", "build.hcl"), []byte("changed"), 0644)
+	third, _ := HashDir(dir)
+	assert.NotEqual(t, first, third)
+}
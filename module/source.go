@@ -0,0 +1,162 @@
+// Package module resolves the "source" addresses of imported pipelines and
+// stages and makes their contents available on the local filesystem.
+package module
+
+import (
+	"fmt"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// Kind is the way a source is fetched.
+type Kind string
+
+const (
+	// KindLocal is a path on the local filesystem, relative to the file that
+	// declares the import.
+	KindLocal Kind = "local"
+
+	// KindGit is a git repository, cloned with the git command.
+	KindGit Kind = "git"
+
+	// KindTarball is a gzipped tar archive downloaded over HTTP.
+	KindTarball Kind = "tarball"
+)
+
+// Source is a parsed source address.
+//
+// Addresses take the form URL//subdir?ref=revision, where both the subdir
+// and the ref are optional. Other query arguments are kept as part of
+// tarball URLs. Supported URLs are:
+//
+//   - local paths starting with "./", "../" or "/"
+//   - "github.com/owner/repo", cloned over HTTPS
+//   - git URLs ending in ".git", or any URL prefixed with "git::"
+//   - HTTP URLs ending in ".tar.gz" or ".tgz", or any URL prefixed with
+//     "tar::"
+type Source struct {
+	// Addr is the address as written in the configuration.
+	Addr string
+
+	Kind Kind
+
+	// URL is the repository or archive URL, or the local path.
+	URL string
+
+	// Ref is the git revision to check out. Only valid for git sources.
+	Ref string
+
+	// Subdir is the directory within the repository or archive that
+	// contains the imported configuration.
+	Subdir string
+}
+
+// String returns the address the source was parsed from.
+func (s *Source) String() string {
+	return s.Addr
+}
+
+// ParseSource parses a source address.
+func ParseSource(addr string) (*Source, error) {
+	src := &Source{Addr: addr}
+	if addr == "" {
+		return nil, fmt.Errorf("source address must not be empty")
+	}
+
+	if isLocalPath(addr) {
+		src.Kind = KindLocal
+		src.URL = addr
+		return src, nil
+	}
+
+	rest := addr
+	forced := ""
+	if i := strings.Index(rest, "::"); i > 0 && !strings.Contains(rest[:i], "/") {
+		forced, rest = rest[:i], rest[i+2:]
+	}
+
+	rest, query, _ := strings.Cut(rest, "?")
+	values, err := url.ParseQuery(query)
+	if err != nil {
+		return nil, fmt.Errorf("invalid query in source address %q: %w", addr, err)
+	}
+	src.Ref = values.Get("ref")
+	values.Del("ref")
+
+	rest, src.Subdir = splitSubdir(rest)
+	if src.Subdir != "" {
+		src.Subdir = path.Clean(src.Subdir)
+		if src.Subdir == ".." || strings.HasPrefix(src.Subdir, "../") || path.IsAbs(src.Subdir) {
+			return nil, fmt.Errorf("subdirectory of source address %q must not leave the source", addr)
+		}
+		if src.Subdir == "." {
+			src.Subdir = ""
+		}
+	}
+
+	switch {
+	case forced == "git":
+		src.Kind = KindGit
+	case forced == "tar":
+		src.Kind = KindTarball
+	case forced != "":
+		return nil, fmt.Errorf("unsupported source type %q in source address %q", forced, addr)
+	case strings.HasPrefix(rest, "github.com/"):
+		src.Kind = KindGit
+		rest = "https://" + strings.TrimSuffix(rest, ".git") + ".git"
+	case strings.HasSuffix(rest, ".tar.gz") || strings.HasSuffix(rest, ".tgz"):
+		src.Kind = KindTarball
+	case strings.HasSuffix(rest, ".git") || strings.HasPrefix(rest, "git@"):
+		src.Kind = KindGit
+	default:
+		return nil, fmt.Errorf("unsupported source address %q; expected a local path, a git repository or a tarball URL", addr)
+	}
+	src.URL = rest
+
+	switch src.Kind {
+	case KindGit:
+		// The URL and ref are passed to git, which would read them as
+		// options.
+		if strings.HasPrefix(src.URL, "-") {
+			return nil, fmt.Errorf("invalid URL %q in source address %q", src.URL, addr)
+		}
+		if strings.HasPrefix(src.Ref, "-") {
+			return nil, fmt.Errorf("invalid ref %q in source address %q", src.Ref, addr)
+		}
+		for key := range values {
+			return nil, fmt.Errorf("unsupported argument %q in source address %q; only \"ref\" is supported", key, addr)
+		}
+	case KindTarball:
+		if src.Ref != "" {
+			return nil, fmt.Errorf("source address %q sets a ref, which is only supported for git sources", addr)
+		}
+		// Any other query arguments are part of the download URL.
+		if len(values) > 0 {
+			src.URL += "?" + values.Encode()
+		}
+	}
+
+	return src, nil
+}
+
+func isLocalPath(addr string) bool {
+	return strings.HasPrefix(addr, "./") || strings.HasPrefix(addr, "../") || strings.HasPrefix(addr, "/")
+}
+
+// splitSubdir splits an address of the form URL//subdir, ignoring the "//"
+// that follows a URL scheme.
+func splitSubdir(addr string) (string, string) {
+	offset := 0
+	if i := strings.Index(addr, "://"); i >= 0 {
+		offset = i + 3
+	}
+
+	i := strings.Index(addr[offset:], "//")
+	if i < 0 {
+		return addr, ""
+	}
+	i += offset
+
+	return addr[:i], strings.Trim(addr[i+2:], "/")
+}
@@ -0,0 +1,77 @@
+package module
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseSource(t *testing.T) {
+	tests := []struct {
+		Addr     string
+		Expected Source
+	}{
+		{
+			"./stages/docker",
+			Source{Kind: KindLocal, URL: "./stages/docker"},
+		},
+		{
+			"github.com/Cwagne17/factory-templates//pipelines/docker?ref=v1.0.0",
+			Source{Kind: KindGit, URL: "https://github.com/Cwagne17/factory-templates.git", Ref: "v1.0.0", Subdir: "pipelines/docker"},
+		},
+		{
+			"git::file:///tmp/repo.git//stages?ref=main",
+			Source{Kind: KindGit, URL: "file:///tmp/repo.git", Ref: "main", Subdir: "stages"},
+		},
+		{
+			"https://example.com/templates.git",
+			Source{Kind: KindGit, URL: "https://example.com/templates.git"},
+		},
+		{
+			"git@github.com:owner/repo.git//stages",
+			Source{Kind: KindGit, URL: "git@github.com:owner/repo.git", Subdir: "stages"},
+		},
+		{
+			"https://example.com/templates.tar.gz//pipelines/docker",
+			Source{Kind: KindTarball, URL: "https://example.com/templates.tar.gz", Subdir: "pipelines/docker"},
+		},
+		{
+			"git::https://example.com/r.git//a/./b/../stages/?ref=v1",
+			Source{Kind: KindGit, URL: "https://example.com/r.git", Ref: "v1", Subdir: "a/stages"},
+		},
+		{
+			"tar::https://example.com/download?id=1",
+			Source{Kind: KindTarball, URL: "https://example.com/download?id=1"},
+		},
+	}
+
+	for _, test := range tests {
+		src, err := ParseSource(test.Addr)
+		if err != nil {
+			t.Fatalf("Error parsing %q: %s", test.Addr, err)
+		}
+		test.Expected.Addr = test.Addr
+		assert.Equal(t, &test.Expected, src)
+	}
+}
+
+func TestParseSourceReturnsErrors(t *testing.T) {
+	tests := []string{
+		"",
+		"example.com/templates",
+		"s3::https://bucket/templates",
+		"github.com/owner/repo?version=1",
+		"https://example.com/templates.tar.gz?ref=v1",
+		"github.com/owner/repo//../escape",
+		"github.com/owner/repo//..",
+		"git::https://example.com/r.git//a/../../../etc?ref=v1",
+		"git::https://example.com/r.git//a/..//../etc",
+		"git::--upload-pack=touch /tmp/pwned",
+		"git::https://example.com/r.git?ref=--upload-pack=touch",
+	}
+
+	for _, addr := range tests {
+		_, err := ParseSource(addr)
+		assert.Error(t, err, "Expected %q to be rejected", addr)
+	}
+}
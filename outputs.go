@@ -0,0 +1,88 @@
+package factory
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/zclconf/go-cty/cty"
+)
+
+// OutputEnvVar is the environment variable that names the file a run block
+// writes its outputs to, see ParseOutputs.
+const OutputEnvVar = "FACTORY_OUTPUT"
+
+// Outputs are the values written by the run blocks of a stage, keyed by
+// name. The stages that depend on it read them as stage.<name>.outputs.<key>.
+type Outputs map[string]string
+
+// Value returns the outputs as an object of strings.
+func (o Outputs) Value() cty.Value {
+	attrs := make(map[string]cty.Value, len(o))
+	for name, value := range o {
+		attrs[name] = cty.StringVal(value)
+	}
+	return cty.ObjectVal(attrs)
+}
+
+// ParseOutputs reads outputs in the format of GitHub Actions' $GITHUB_OUTPUT
+// file: a name=value line for each output, or for values that span lines,
+//
+//	name<<DELIMITER
+//	first line
+//	second line
+//	DELIMITER
+//
+// Blank lines are ignored, and an output written more than once takes the
+// last value.
+func ParseOutputs(r io.Reader) (Outputs, error) {
+	outputs := make(Outputs)
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := scanner.Text()
+		if strings.TrimSpace(text) == "" {
+			continue
+		}
+
+		var name, value string
+		if n, delimiter, ok := strings.Cut(text, "<<"); ok && !strings.Contains(n, "=") {
+			name = n
+			var lines []string
+			closed := false
+			for scanner.Scan() {
+				line++
+				if scanner.Text() == delimiter {
+					closed = true
+					break
+				}
+				lines = append(lines, scanner.Text())
+			}
+			if !closed {
+				return nil, fmt.Errorf("output %q is not terminated by %q", name, delimiter)
+			}
+			value = strings.Join(lines, "\n")
+		} else {
+			n, v, ok := strings.Cut(text, "=")
+			if !ok {
+				return nil, fmt.Errorf("line %d: expected name=value or name<<DELIMITER", line)
+			}
+			name, value = n, v
+		}
+
+		if !hclsyntax.ValidIdentifier(name) {
+			return nil, fmt.Errorf("line %d: invalid output name %q", line, name)
+		}
+		outputs[name] = value
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return outputs, nil
+}
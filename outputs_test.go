@@ -0,0 +1,39 @@
+package factory
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseOutputs(t *testing.T) {
+	outputs, err := ParseOutputs(strings.NewReader("tag=v1\n\nnotes<<EOF\nfirst line\nsecond=line\nEOF\ntag=v2\nempty=\n"))
+	if err != nil {
+		t.Fatalf("Error parsing outputs: %s", err)
+	}
+
+	assert.Equal(t, Outputs{
+		"tag":   "v2",
+		"notes": "first line\nsecond=line",
+		"empty": "",
+	}, outputs)
+}
+
+func TestParseOutputsInvalid(t *testing.T) {
+	tests := []struct {
+		Src   string
+		Error string
+	}{
+		{"tag", "line 1: expected name=value"},
+		{"tag=v1\n1tag=v2", `line 2: invalid output name "1tag"`},
+		{"notes<<EOF\nfirst line", `output "notes" is not terminated by "EOF"`},
+	}
+
+	for _, test := range tests {
+		_, err := ParseOutputs(strings.NewReader(test.Src))
+		if assert.Error(t, err, "Expected an error for %q", test.Src) {
+			assert.Contains(t, err.Error(), test.Error)
+		}
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"os"
 	"path/filepath"
 
+	"github.com/factorycicd/factory/module"
 	"github.com/hashicorp/hcl/v2"
 	"github.com/hashicorp/hcl/v2/hclparse"
 	"github.com/spf13/afero"
@@ -21,8 +22,27 @@ import (
 type Parser struct {
 	fs afero.Afero
 	p  *hclparse.Parser
+
+	// modules downloads the sources of imported pipelines and stages.
+	modules *module.Fetcher
+
+	// importing holds the directories of the sources currently being
+	// imported, to detect sources that import themselves.
+	importing map[string]bool
+
+	// inputs are the variable values supplied from outside the
+	// configuration, see SetInputValues.
+	inputs InputValues
+
+	// runContext is the run context of every loaded file, see
+	// SetRunContext.
+	runContext *RunContext
 }
 
+// DefaultModulesDir is the directory, relative to the working directory,
+// that imported sources are downloaded into.
+const DefaultModulesDir = ".factory/modules"
+
 // NewParser creates and returns a new Parser that reads files from the given
 // filesystem. If a nil filesystem is passed then the system's "real" filesystem
 // will be used, via afero.OsFs.
@@ -32,11 +52,19 @@ func NewParser(fs afero.Fs) *Parser {
 	}
 
 	return &Parser{
-		fs: afero.Afero{Fs: fs},
-		p:  hclparse.NewParser(),
+		fs:        afero.Afero{Fs: fs},
+		p:         hclparse.NewParser(),
+		modules:   module.NewFetcher(DefaultModulesDir),
+		importing: make(map[string]bool),
 	}
 }
 
+// Files returns the configuration files the parser has loaded so far, keyed
+// by filename, for use in rendering diagnostics.
+func (p *Parser) Files() map[string]*hcl.File {
+	return p.p.Files()
+}
+
 // LoadHCLFile is a low-level method that reads the file at the given path,
 // parses it, and returns the hcl.Body representing its root. In many cases
 // it is better to use one of the other Load*File methods on this type,
@@ -89,6 +117,7 @@ func ParseFactoryDirectory() ([]*File, hcl.Diagnostics) {
 // ParseDirectory parses the directory at the given path and returns a slice of File pointers and any diagnostics encountered.
 //
 // If the recursive flag is set to true, it will recursively process subdirectories as well.
+// Imported sources are verified against the lock file in the working directory, if one exists.
 //
 // Parameters:
 //   - path: The directory path to process.
@@ -98,19 +127,49 @@ func ParseFactoryDirectory() ([]*File, hcl.Diagnostics) {
 //   - []*File: A slice of pointers to the parsed files.
 //   - hcl.Diagnostics: Any diagnostics encountered during parsing.
 func ParseDirectory(path string, recursive bool) ([]*File, hcl.Diagnostics) {
+	fs := afero.NewOsFs()
+	parser := NewParser(fs)
+
+	diags := parser.LoadLockFile(module.DefaultLockFile)
+	if diags.HasErrors() {
+		return nil, diags
+	}
+
+	files, parseDiags := parser.ParseDirectory(path, recursive)
+	diags = diags.Extend(parseDiags)
+
+	return files, diags
+}
+
+// ParseDirectory parses the directory at the given path using this parser.
+// See the package-level ParseDirectory for details.
+func (p *Parser) ParseDirectory(path string, recursive bool) ([]*File, hcl.Diagnostics) {
 	var diags hcl.Diagnostics
 
 	paths, dirDiags := processDir(path, recursive)
 	diags = diags.Extend(dirDiags)
-	fs := afero.NewOsFs()
-	parser := NewParser(fs)
 
-	files, fileDiags := parser.LoadFiles(paths)
+	files, fileDiags := p.LoadFiles(paths)
 	diags = diags.Extend(fileDiags)
 
 	return files, diags
 }
 
+// LoadLockFile reads the lock file at path and pins every imported source
+// to it. Without a lock file, imported sources are used as fetched.
+func (p *Parser) LoadLockFile(path string) hcl.Diagnostics {
+	lock, diags := module.LoadLockFile(path)
+	if lock != nil {
+		p.modules.Lock = lock
+	}
+	return diags
+}
+
+// Modules returns the fetcher the parser downloads imported sources with.
+func (p *Parser) Modules() *module.Fetcher {
+	return p.modules
+}
+
 // processDir processes the given directory path and returns a list of file paths and any diagnostics encountered.
 // If the path is a directory, it can be processed recursively if the `Recursive` flag is set.
 // If the path is a file, it will be treated as a single-element slice with the file info.
@@ -162,12 +221,19 @@ func processDir(path string, recursive bool) ([]string, hcl.Diagnostics) {
 		// If the path is a file, create a single-element slice with the file info
 		// so that the rest of the code can treat it as paths from a directory.
 		entries = []fs.FileInfo{info}
+		path = filepath.Dir(path)
 	}
 
 	for _, info := range entries {
 		name := info.Name()
 		subPath := filepath.Join(path, name)
 		if info.IsDir() {
+			// Imported sources are loaded through the pipelines and
+			// stages that import them, never on their own.
+			if isModulesDir(subPath) {
+				continue
+			}
+
 			// If the path is a directory, process
 			// it recursively if the flag is set.
 			if recursive {
@@ -182,6 +248,10 @@ func processDir(path string, recursive bool) ([]string, hcl.Diagnostics) {
 		}
 
 		// The rest of this loop only applies to files
+		if name == module.DefaultLockFile {
+			continue
+		}
+
 		ext := filepath.Ext(name)
 		switch ext {
 		case ".hcl":
@@ -191,3 +261,17 @@ func processDir(path string, recursive bool) ([]string, hcl.Diagnostics) {
 
 	return paths, diags
 }
+
+// ConfigFilePaths returns the paths of the configuration files that
+// ParseDirectory would load from the directory at path, or path itself if
+// it is a configuration file.
+func ConfigFilePaths(path string, recursive bool) ([]string, hcl.Diagnostics) {
+	return processDir(path, recursive)
+}
+
+// isModulesDir reports whether path is a cache directory of imported
+// sources, as created at DefaultModulesDir.
+func isModulesDir(path string) bool {
+	return filepath.Base(path) == filepath.Base(DefaultModulesDir) &&
+		filepath.Base(filepath.Dir(path)) == filepath.Base(filepath.Dir(DefaultModulesDir))
+}
@@ -2,6 +2,7 @@ package factory
 
 import (
 	"log"
+	"path/filepath"
 
 	"github.com/hashicorp/hcl/v2"
 )
@@ -9,21 +10,126 @@ import (
 // LoadConfigFile loads a configuration file from the specified path and returns
 // the parsed file along with any diagnostics encountered during parsing.
 // It first loads the HCL file from the given path and then parses its content
-// according to the defined schema. The function supports parsing pipeline blocks
-// and filter blocks within them. It logs debug messages for each block type found.
-// Currently, decoding of variables and stage blocks is not implemented.
+// according to the defined schema. It logs debug messages for each block type found.
 // The function returns the parsed file and any encountered diagnostics.
 // check out terraoform\internal\config\parser_config.go line 51
 func (p *Parser) LoadConfigFile(path string) (*File, hcl.Diagnostics) {
-	body, diags := p.LoadHCLFile(path)
-	if body == nil {
+	files, diags := p.loadConfigFiles([]string{path}, p.inputs)
+	if len(files) == 0 {
 		return nil, diags
 	}
+	return files[0], diags
+}
+
+// loadConfigFiles loads the configuration files at the given paths together,
+// so that the expressions of each file can refer to the global variables of
+// all of them, regardless of the order the files and blocks are in.
+//
+// Decoding happens in two phases. First every file is parsed, and its
+// variable declarations and global variable assignments are collected. Then
+// the global variables are evaluated in the order their references to each
+// other require, followed by the locals, pipelines and stages of each file.
+//
+// The given overrides take precedence over every variable declared in the
+// files, which is how input values, and the variables of an importing
+// pipeline or stage, reach them.
+func (p *Parser) loadConfigFiles(paths []string, overrides InputValues) ([]*File, hcl.Diagnostics) {
+	var diags hcl.Diagnostics
+	var files []*File
+	var filePaths []string
+	var contents []*hcl.BodyContent
+
+	scope := NewVariables()
+	for name, input := range overrides {
+		scope.Overrides[name] = input.Value
+		if input.Raw {
+			scope.rawOverrides[name] = true
+		}
+	}
+
+	// First phase: parse every file and collect what its expressions can
+	// refer to.
+	var globals []*globalAssignment
+	for _, path := range paths {
+		log.Printf("[DEBUG] loading config file: %s", path)
+		body, bodyDiags := p.LoadHCLFile(path)
+		diags = append(diags, bodyDiags...)
+		if body == nil {
+			continue
+		}
+
+		file := NewFile()
+		file.functions = Functions(p.fs, filepath.Dir(path))
+		file.runContext = p.runContext
+		file.scope = scope
+		for name, input := range overrides {
+			file.Variables.Overrides[name] = input.Value
+			if input.Raw {
+				file.Variables.rawOverrides[name] = true
+			}
+		}
+
+		content, contentDiags := body.Content(configFileSchema)
+		diags = append(diags, contentDiags...)
+
+		for _, block := range content.Blocks {
+			switch block.Type {
+			case "variable":
+				log.Printf("[DEBUG] Variable block found, decoding in progress")
+				diags = append(diags, decodeVariableDeclBlock(block, file)...)
+			// Check out line 493 of internal/configs/named_values.go in terraform
+			case "variables":
+				log.Printf("[DEBUG] Variables block found, decoding in progress")
+				assignments, d := decodeAssignments(block.Body)
+				diags = append(diags, d...)
+				for _, assignment := range assignments {
+					globals = append(globals, &globalAssignment{namedExpr: assignment, File: file})
+				}
+			}
+		}
+
+		// Duplicate declarations are reported once all files are merged,
+		// the first one is in scope until then.
+		for name, decl := range file.Variables.Declarations {
+			if _, ok := scope.Declarations[name]; !ok {
+				scope.Declarations[name] = decl
+			}
+		}
+
+		files = append(files, file)
+		filePaths = append(filePaths, path)
+		contents = append(contents, content)
+	}
+
+	// Second phase: evaluate the variables, then everything that refers
+	// to them.
+	scope.prepareOverrides()
+	diags = append(diags, evaluateGlobalVariables(globals)...)
+
+	for i, file := range files {
+		diags = append(diags, p.decodeConfigFile(filePaths[i], file, contents[i])...)
+	}
+
+	return files, diags
+}
 
-	file := NewFile()
+// decodeConfigFile decodes the locals, pipelines and stages of a file whose
+// variables are already evaluated, and imports the sources they refer to.
+func (p *Parser) decodeConfigFile(path string, file *File, content *hcl.BodyContent) hcl.Diagnostics {
+	var diags hcl.Diagnostics
 
-	content, contentDiags := body.Content(configFileSchema)
-	diags = append(diags, contentDiags...)
+	// Locals are decoded first, so that every pipeline and stage can refer
+	// to them.
+	var localsBlocks []*hcl.Block
+	for _, block := range content.Blocks {
+		if block.Type == "locals" {
+			log.Printf("[DEBUG] Locals block found, decoding in progress")
+			localsBlocks = append(localsBlocks, block)
+		}
+	}
+	locals, localsDiags := decodeLocalsBlocks(localsBlocks)
+	diags = append(diags, localsDiags...)
+	diags = append(diags, evaluateLocals(locals, func() *hcl.EvalContext { return file.GetEvalContext(nil) }, file.Locals)...)
 
 	for _, block := range content.Blocks {
 		switch block.Type {
@@ -32,16 +138,14 @@ func (p *Parser) LoadConfigFile(path string) (*File, hcl.Diagnostics) {
 			pipeline, pDiags := decodePipelineBlock(block, file)
 			diags = append(diags, pDiags...)
 			file.Pipelines = append(file.Pipelines, pipeline)
-		// Check out line 493 of internal/configs/named_values.go in terraform
-		case "variables":
-			log.Printf("[DEBUG] Variables block found, decoding in progress")
-			varDiag := decodeGlobalVariableBlock(block, file)
-			diags = append(diags, varDiag...)
 		case "stage":
 			log.Printf("[DEBUG] Stage block found, decoding in progress")
 			stage, stageDiags := decodeStageBlock(block, file)
 			diags = append(diags, stageDiags...)
 			file.Stages = append(file.Stages, stage)
+		case "variable", "variables", "locals":
+			// Already decoded above
+			continue
 		default:
 			// Should never happen beacause the above cases should be exhaustive
 			// for all block type names in our schema.
@@ -49,7 +153,25 @@ func (p *Parser) LoadConfigFile(path string) (*File, hcl.Diagnostics) {
 		}
 	}
 
-	return file, diags
+	baseDir := filepath.Dir(path)
+	for _, pipeline := range file.Pipelines {
+		if pipeline.Source != "" {
+			diags = append(diags, p.importPipeline(pipeline, baseDir)...)
+			continue
+		}
+		for _, sd := range pipeline.Stages {
+			if sd.Source != "" {
+				diags = append(diags, p.importStageDefinition(sd, baseDir)...)
+			}
+		}
+	}
+	for _, stage := range file.Stages {
+		if stage.Source != "" {
+			diags = append(diags, p.importStage(file, stage, baseDir)...)
+		}
+	}
+
+	return diags
 }
 
 // configurationFileSchema is the schema for the top-level of a config file. We use
@@ -64,9 +186,16 @@ var configFileSchema = &hcl.BodySchema{
 		{
 			Type: "variables",
 		},
+		{
+			Type:       "variable",
+			LabelNames: []string{"name"},
+		},
 		{
 			Type:       "stage",
 			LabelNames: []string{"name"},
 		},
+		{
+			Type: "locals",
+		},
 	},
 }
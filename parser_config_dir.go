@@ -2,7 +2,6 @@ package factory
 
 import (
 	"fmt"
-	"log"
 	"path/filepath"
 	"strings"
 
@@ -12,21 +11,7 @@ import (
 // LoadFiles loads multiple configuration files from the specified paths
 // and returns a slice of File objects along with any diagnostics encountered.
 func (p *Parser) LoadFiles(paths []string) ([]*File, hcl.Diagnostics) {
-	var files []*File
-	var diags hcl.Diagnostics
-
-	for _, path := range paths {
-		var f *File
-		var fDiags hcl.Diagnostics
-		log.Printf("[DEBUG] loading config file: %s", path)
-		f, fDiags = p.LoadConfigFile(path)
-		diags = append(diags, fDiags...)
-		if f != nil {
-			files = append(files, f)
-		}
-	}
-
-	return files, diags
+	return p.loadConfigFiles(paths, p.inputs)
 }
 
 // This is used to find all the files in a module directory.
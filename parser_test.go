@@ -0,0 +1,43 @@
+package factory
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConfigFilePaths(t *testing.T) {
+	dir := t.TempDir()
+	for _, path := range []string{
+		"main.hcl",
+		"notes.txt",
+		"nested/stages.hcl",
+		".factory/modules/imported/main.hcl",
+	} {
+		path = filepath.Join(dir, path)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Error creating directory: %s", err)
+		}
+		if err := os.WriteFile(path, nil, 0644); err != nil {
+			t.Fatalf("Error writing file: %s", err)
+		}
+	}
+
+	paths, diags := ConfigFilePaths(dir, false)
+	assert.False(t, diags.HasErrors())
+	assert.Equal(t, []string{filepath.Join(dir, "main.hcl")}, paths)
+
+	paths, diags = ConfigFilePaths(dir, true)
+	assert.False(t, diags.HasErrors())
+	assert.Equal(t, []string{filepath.Join(dir, "main.hcl"), filepath.Join(dir, "nested/stages.hcl")}, paths)
+
+	// A file is returned as is
+	paths, diags = ConfigFilePaths(filepath.Join(dir, "nested/stages.hcl"), false)
+	assert.False(t, diags.HasErrors())
+	assert.Equal(t, []string{filepath.Join(dir, "nested/stages.hcl")}, paths)
+
+	_, diags = ConfigFilePaths(filepath.Join(dir, "missing"), false)
+	assert.True(t, diags.HasErrors())
+}
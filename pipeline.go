@@ -1,39 +1,112 @@
 package factory
 
 import (
+	"fmt"
 	"log"
 
 	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/zclconf/go-cty/cty"
+	"github.com/zclconf/go-cty/cty/convert"
 )
 
 // pipelineBlockSchema is the schema for a top-level "pipeline" block in
 // a configuration file.
 var pipelineBlockSchema = &hcl.BodySchema{
 	Attributes: []hcl.AttributeSchema{
-		{Name: "stages", Required: true},
+		{Name: "stages"},
+		{Name: "source"},
+		{Name: "description"},
 	},
 	Blocks: []hcl.BlockHeaderSchema{
 		{
 			Type: "filter",
 		},
+		{
+			Type: "variables",
+		},
+		{
+			Type: "stages",
+		},
+	},
+}
+
+// stageDefinitionBlockSchema is the schema of a stage definition in the
+// block form of a pipeline's stages, where each block is named after the
+// stage it defines.
+var stageDefinitionBlockSchema = &hcl.BodySchema{
+	Attributes: []hcl.AttributeSchema{
+		{Name: "source"},
+		{Name: "depends_on"},
+		{Name: "namespaces"},
+		{Name: "description"},
+	},
+	Blocks: []hcl.BlockHeaderSchema{
+		{Type: "variables"},
 	},
 }
 
 type StageDefinition struct {
-	Name       string
-	DependsOn  []string
+	Name      string
+	DependsOn []string
+
+	// Namespaces lists the secrets namespaces the stage reads from, such as
+	// Vault mounts and paths, see SecretsProvider.
 	Namespaces []string
+
+	// DeclRange is the range of the stage definition within the pipeline's
+	// stages list, NameRange the range of its name and DependsOnRanges the
+	// range of each entry of DependsOn.
+	DeclRange       hcl.Range
+	NameRange       hcl.Range
+	DependsOnRanges []hcl.Range
+
+	// The fields below can only be set with the block form of stages.
+
+	// Description describes the stage within the pipeline.
+	Description string
+
+	// Source is the address the stage is imported from, if any, and
+	// SourceRange the range of the source attribute. An imported stage is
+	// only part of this pipeline, and is not declared in the configuration.
+	Source      string
+	SourceRange hcl.Range
+
+	// Stage is the stage imported from Source, once it has been loaded.
+	Stage *Stage
+
+	// Variables holds the values of the definition's variables block. They
+	// take precedence over the variables of the stage and of the pipeline.
+	Variables map[string]cty.Value
 }
 
 type Pipeline struct {
 	Name   string
 	Filter *Filter
 	Stages []*StageDefinition
+
+	// Description describes the pipeline.
+	Description string
+
+	// Source is the address the pipeline is imported from, if any, and
+	// SourceRange the range of the source attribute.
+	Source      string
+	SourceRange hcl.Range
+
+	// Variables holds the values of the pipeline's variables block. They
+	// take precedence over global variables in the stages of the pipeline,
+	// and over the variables of an imported pipeline, see
+	// Stage.EvalContext.
+	Variables map[string]cty.Value
+
+	// DeclRange is the range of the pipeline block header.
+	DeclRange hcl.Range
 }
 
 func NewPipeline() *Pipeline {
 	return &Pipeline{
-		Stages: make([]*StageDefinition, 0),
+		Stages:    make([]*StageDefinition, 0),
+		Variables: make(map[string]cty.Value),
 	}
 }
 
@@ -41,44 +114,509 @@ func decodePipelineBlock(block *hcl.Block, file *File) (*Pipeline, hcl.Diagnosti
 	content, diags := block.Body.Content(pipelineBlockSchema)
 	pipeline := NewPipeline()
 	pipeline.Name = block.Labels[0]
+	pipeline.DeclRange = block.DefRange
 
+	if attr, ok := content.Attributes["description"]; ok {
+		description, d := decodeStringAttribute(attr, file.GetEvalContext(nil), "pipeline")
+		diags = append(diags, d...)
+		pipeline.Description = description
+	}
+
+	var stagesBlock *hcl.Block
+	var variables []*namedExpr
 	for _, innerBlock := range content.Blocks {
 		switch innerBlock.Type {
 		case "filter":
 			log.Printf("[DEBUG] Filter block found, decoding in progress")
 
-			filterCfg, filterDiags := decodeFilterBlock(innerBlock)
+			filterCfg, filterDiags := decodeFilterBlock(innerBlock, file.functionContext())
 			diags = append(diags, filterDiags...)
 			pipeline.Filter = filterCfg
+		case "variables":
+			assignments, varDiags := decodeAssignments(innerBlock.Body)
+			diags = append(diags, varDiags...)
+			variables = append(variables, assignments...)
+		case "stages":
+			if stagesBlock != nil {
+				diags = append(diags, &hcl.Diagnostic{
+					Severity: hcl.DiagError,
+					Summary:  "Duplicate stages block",
+					Detail:   fmt.Sprintf("Pipeline %q already has a stages block at %s. All stages must be defined in the same block.", pipeline.Name, stagesBlock.DefRange),
+					Subject:  innerBlock.DefRange.Ptr(),
+				})
+				continue
+			}
+			stagesBlock = innerBlock
 		default:
 			// Should never happen beacause the above cases should be exhaustive
 			// for all block type names in our schema.
 			continue
 		}
 	}
+
+	// The variables are evaluated before the stage definitions, which
+	// override them, and before an imported pipeline, which they override.
+	diags = append(diags, evaluateScopedVariables(variables, file.scopeVariables().Declarations, func() *hcl.EvalContext {
+		return file.evalContext(evalScope{pipelineVariables: pipeline.Variables})
+	}, func(name string, value cty.Value) {
+		pipeline.Variables[name] = value
+	})...)
+
+	stages, hasStagesAttr := content.Attributes["stages"]
+	source, hasSource := content.Attributes["source"]
+	hasStages := hasStagesAttr || stagesBlock != nil
+	switch {
+	case hasSource && hasStages:
+		var subject hcl.Range
+		if hasStagesAttr {
+			subject = stages.NameRange
+		} else {
+			subject = stagesBlock.DefRange
+		}
+		diags = append(diags, &hcl.Diagnostic{
+			Severity: hcl.DiagError,
+			Summary:  "Conflicting source and stages",
+			Detail:   fmt.Sprintf("Pipeline %q sets both \"source\" and \"stages\". An imported pipeline takes its stages from the source.", pipeline.Name),
+			Subject:  subject.Ptr(),
+		})
+		return pipeline, diags
+	case hasStagesAttr && stagesBlock != nil:
+		diags = append(diags, &hcl.Diagnostic{
+			Severity: hcl.DiagError,
+			Summary:  "Conflicting stages",
+			Detail:   fmt.Sprintf("Pipeline %q sets \"stages\" both as an argument and as a block. Use only one of them.", pipeline.Name),
+			Subject:  stagesBlock.DefRange.Ptr(),
+		})
+		return pipeline, diags
+	case hasSource:
+		val, d := source.Expr.Value(file.GetEvalContext(nil))
+		diags = append(diags, d...)
+		val, _ = val.Unmark()
+		if d.HasErrors() {
+			return pipeline, diags
+		}
+		if !val.IsKnown() {
+			diags = append(diags, unknownSourceDiagnostic(source.Expr.Range()))
+			return pipeline, diags
+		}
+		if val.Type() != cty.String || val.IsNull() {
+			diags = append(diags, &hcl.Diagnostic{
+				Severity: hcl.DiagError,
+				Summary:  "Invalid source",
+				Detail:   "The source of a pipeline must be a string.",
+				Subject:  source.Expr.Range().Ptr(),
+			})
+			return pipeline, diags
+		}
+		pipeline.Source = val.AsString()
+		pipeline.SourceRange = source.Expr.Range()
+		return pipeline, diags
+	case !hasStages:
+		diags = append(diags, &hcl.Diagnostic{
+			Severity: hcl.DiagError,
+			Summary:  "Missing stages",
+			Detail:   fmt.Sprintf("Pipeline %q must either set \"stages\" or import a pipeline with \"source\".", pipeline.Name),
+			Subject:  block.DefRange.Ptr(),
+		})
+		return pipeline, diags
+	}
+
 	// Add the stages
-	stages := content.Attributes["stages"]
+	var stageDefs []*StageDefinition
+	var stagesDiags hcl.Diagnostics
+	if stagesBlock != nil {
+		stageDefs, stagesDiags = decodeStagesBlock(stagesBlock, file, pipeline.Variables)
+	} else {
+		stageDefs, stagesDiags = decodeStageDefinitions(stages.Expr, file.GetEvalContext(nil))
+	}
+	diags = append(diags, stagesDiags...)
+	pipeline.Stages = stageDefs
 
-	stagesVal, d := stages.Expr.Value(file.GetEvalContext(nil))
-	diags = append(diags, d...)
+	return pipeline, diags
+}
+
+// stageDefinitionType is the type every element of a pipeline's stages list
+// is converted to.
+var stageDefinitionType = cty.ObjectWithOptionalAttrs(map[string]cty.Type{
+	"name":       cty.String,
+	"depends_on": cty.List(cty.String),
+	"namespaces": cty.List(cty.String),
+}, []string{"depends_on", "namespaces"})
+
+// decodeStageDefinitions decodes the stages attribute of a pipeline, which
+// must be a list of objects of stageDefinitionType. Elements that are not
+// valid are reported and left out.
+func decodeStageDefinitions(expr hcl.Expression, ctx *hcl.EvalContext) ([]*StageDefinition, hcl.Diagnostics) {
 	stageDefs := make([]*StageDefinition, 0)
-	for _, el := range stagesVal.AsValueSlice() {
-		elMap := el.AsValueMap()
-		sd := &StageDefinition{}
-		sd.Name = elMap["name"].AsString()
-		if dependsOn, ok := elMap["depends_on"]; ok {
-			for _, dep := range dependsOn.AsValueSlice() {
-				sd.DependsOn = append(sd.DependsOn, dep.AsString())
-			}
+
+	val, diags := expr.Value(ctx)
+	if diags.HasErrors() {
+		return stageDefs, diags
+	}
+	val, _ = val.UnmarkDeep()
+
+	ty := val.Type()
+	switch {
+	case !val.IsKnown():
+		return stageDefs, append(diags, &hcl.Diagnostic{
+			Severity: hcl.DiagError,
+			Summary:  "Invalid stages",
+			Detail:   "The stages of a pipeline cannot refer to values that are only known when the pipeline runs.",
+			Subject:  expr.Range().Ptr(),
+		})
+	case val.IsNull() || !(ty.IsListType() || ty.IsTupleType() || ty.IsSetType()):
+		return stageDefs, append(diags, &hcl.Diagnostic{
+			Severity: hcl.DiagError,
+			Summary:  "Invalid stages",
+			Detail:   `The stages of a pipeline must be a list of stage definitions, such as [{ name = "build" }].`,
+			Subject:  expr.Range().Ptr(),
+		})
+	}
+
+	// The expression of each element gives more precise ranges when the
+	// list is written out literally.
+	elemExprs, d := hcl.ExprList(expr)
+	if d.HasErrors() || len(elemExprs) != val.LengthInt() {
+		elemExprs = nil
+	}
+
+	index := 0
+	for it := val.ElementIterator(); it.Next(); index++ {
+		_, el := it.Element()
+		elExpr := expr
+		if elemExprs != nil {
+			elExpr = elemExprs[index]
+		}
+
+		sd, d := decodeStageDefinition(el, elExpr, index)
+		diags = append(diags, d...)
+		if sd != nil {
+			stageDefs = append(stageDefs, sd)
 		}
-		if namespaces, ok := elMap["namespaces"]; ok {
-			for _, ns := range namespaces.AsValueSlice() {
-				sd.Namespaces = append(sd.Namespaces, ns.AsString())
+	}
+
+	return stageDefs, diags
+}
+
+// decodeStageDefinition decodes the element at the given index of a
+// pipeline's stages list. expr is the expression of the element, or of the
+// whole list if it is not written out literally. It returns nil if the
+// element is not valid.
+func decodeStageDefinition(val cty.Value, expr hcl.Expression, index int) (*StageDefinition, hcl.Diagnostics) {
+	var diags hcl.Diagnostics
+	attrExprs := objectAttrExprs(expr)
+	subject := func(name string) *hcl.Range {
+		if attrExpr, ok := attrExprs[name]; ok {
+			return attrExpr.Range().Ptr()
+		}
+		return expr.Range().Ptr()
+	}
+
+	ty := val.Type()
+	if val.IsNull() || !(ty.IsObjectType() || ty.IsMapType()) {
+		return nil, append(diags, &hcl.Diagnostic{
+			Severity: hcl.DiagError,
+			Summary:  "Invalid stage definition",
+			Detail:   fmt.Sprintf(`The stage definition at index %d must be an object, such as { name = "build" }.`, index),
+			Subject:  expr.Range().Ptr(),
+		})
+	}
+
+	// Attributes are checked one at a time, so that diagnostics point at
+	// the attribute that is wrong.
+	if ty.IsObjectType() {
+		for _, name := range sortedKeys(ty.AttributeTypes()) {
+			if !stageDefinitionType.HasAttribute(name) {
+				diags = append(diags, &hcl.Diagnostic{
+					Severity: hcl.DiagError,
+					Summary:  "Unsupported argument",
+					Detail:   fmt.Sprintf("An argument named %q is not expected in a stage definition. Stage definitions accept \"name\", \"depends_on\" and \"namespaces\".", name),
+					Subject:  subject(name),
+				})
+				continue
+			}
+			if _, err := convert.Convert(val.GetAttr(name), stageDefinitionType.AttributeType(name)); err != nil {
+				diags = append(diags, &hcl.Diagnostic{
+					Severity: hcl.DiagError,
+					Summary:  "Invalid stage definition",
+					Detail:   fmt.Sprintf("The stage definition at index %d is invalid: attribute %q: %s.", index, name, err),
+					Subject:  subject(name),
+				})
 			}
 		}
+		if diags.HasErrors() {
+			return nil, diags
+		}
+	}
+
+	converted, err := convert.Convert(val, stageDefinitionType)
+	if err != nil {
+		return nil, append(diags, &hcl.Diagnostic{
+			Severity: hcl.DiagError,
+			Summary:  "Invalid stage definition",
+			Detail:   fmt.Sprintf("The stage definition at index %d is invalid: %s.", index, err),
+			Subject:  expr.Range().Ptr(),
+		})
+	}
+
+	if !converted.IsWhollyKnown() {
+		return nil, append(diags, &hcl.Diagnostic{
+			Severity: hcl.DiagError,
+			Summary:  "Invalid stage definition",
+			Detail:   fmt.Sprintf("The stage definition at index %d cannot refer to values that are only known when the pipeline runs.", index),
+			Subject:  expr.Range().Ptr(),
+		})
+	}
+
+	name := converted.GetAttr("name")
+	if name.IsNull() {
+		return nil, append(diags, &hcl.Diagnostic{
+			Severity: hcl.DiagError,
+			Summary:  "Missing stage name",
+			Detail:   fmt.Sprintf("The stage definition at index %d must set \"name\" to the name of a stage.", index),
+			Subject:  subject("name"),
+		})
+	}
+
+	sd := &StageDefinition{
+		Name:      name.AsString(),
+		DeclRange: expr.Range(),
+		NameRange: *subject("name"),
+	}
+
+	var d hcl.Diagnostics
+	sd.DependsOn, sd.DependsOnRanges, d = decodeStringList(converted.GetAttr("depends_on"), "depends_on", attrExprs["depends_on"], *subject("depends_on"))
+	diags = append(diags, d...)
+	sd.Namespaces, _, d = decodeStringList(converted.GetAttr("namespaces"), "namespaces", attrExprs["namespaces"], *subject("namespaces"))
+	diags = append(diags, d...)
+
+	return sd, diags
+}
+
+// decodeStagesBlock decodes the block form of a pipeline's stages, which
+// holds a block for every stage, named after it:
+//
+//	stages {
+//	  build {
+//	    depends_on = ["lint"]
+//	  }
+//	}
+func decodeStagesBlock(block *hcl.Block, file *File, pipelineVariables map[string]cty.Value) ([]*StageDefinition, hcl.Diagnostics) {
+	stageDefs := make([]*StageDefinition, 0)
+
+	// The stage names are block types, which cannot be listed in a schema
+	// up front, so the body is read through the native syntax.
+	body, ok := block.Body.(*hclsyntax.Body)
+	if !ok {
+		return stageDefs, hcl.Diagnostics{{
+			Severity: hcl.DiagError,
+			Summary:  "Unsupported stages block",
+			Detail:   "The block form of stages is only supported in native syntax configuration files. Use the stages argument instead.",
+			Subject:  block.DefRange.Ptr(),
+		}}
+	}
+
+	var diags hcl.Diagnostics
+	for _, name := range sortedKeys(body.Attributes) {
+		attr := body.Attributes[name]
+		diags = append(diags, &hcl.Diagnostic{
+			Severity: hcl.DiagError,
+			Summary:  "Unsupported argument",
+			Detail:   fmt.Sprintf("An argument named %q is not expected in a stages block. Each stage is defined by a block named after it, such as %s { }.", name, name),
+			Subject:  attr.NameRange.Ptr(),
+		})
+	}
+
+	for _, inner := range body.Blocks {
+		if len(inner.Labels) > 0 {
+			diags = append(diags, &hcl.Diagnostic{
+				Severity: hcl.DiagError,
+				Summary:  "Unexpected stage label",
+				Detail:   fmt.Sprintf("The definition of stage %q cannot have labels, as the block type is the name of the stage.", inner.Type),
+				Subject:  inner.LabelRanges[0].Ptr(),
+			})
+			continue
+		}
+
+		sd, d := decodeStageDefinitionBlock(inner.AsHCLBlock(), file, pipelineVariables)
+		diags = append(diags, d...)
 		stageDefs = append(stageDefs, sd)
 	}
-	pipeline.Stages = stageDefs
 
-	return pipeline, diags
+	return stageDefs, diags
+}
+
+// decodeStageDefinitionBlock decodes a block of a pipeline's stages block,
+// which defines the stage it is named after. Its variables are evaluated
+// over the variables of the pipeline, pipelineVariables.
+func decodeStageDefinitionBlock(block *hcl.Block, file *File, pipelineVariables map[string]cty.Value) (*StageDefinition, hcl.Diagnostics) {
+	content, diags := block.Body.Content(stageDefinitionBlockSchema)
+	sd := &StageDefinition{
+		Name:      block.Type,
+		DeclRange: block.DefRange,
+		NameRange: block.TypeRange,
+		Variables: make(map[string]cty.Value),
+	}
+	ctx := file.GetEvalContext(nil)
+
+	if attr, ok := content.Attributes["depends_on"]; ok {
+		var d hcl.Diagnostics
+		sd.DependsOn, sd.DependsOnRanges, d = decodeStringListAttribute(attr, ctx)
+		diags = append(diags, d...)
+	}
+	if attr, ok := content.Attributes["namespaces"]; ok {
+		var d hcl.Diagnostics
+		sd.Namespaces, _, d = decodeStringListAttribute(attr, ctx)
+		diags = append(diags, d...)
+	}
+	if attr, ok := content.Attributes["description"]; ok {
+		var d hcl.Diagnostics
+		sd.Description, d = decodeStringAttribute(attr, ctx, "stage definition")
+		diags = append(diags, d...)
+	}
+	if attr, ok := content.Attributes["source"]; ok {
+		var d hcl.Diagnostics
+		sd.Source, d = decodeStringAttribute(attr, ctx, "stage definition")
+		diags = append(diags, d...)
+		sd.SourceRange = attr.Expr.Range()
+	}
+
+	var variables []*namedExpr
+	for _, inner := range content.Blocks {
+		assignments, d := decodeAssignments(inner.Body)
+		diags = append(diags, d...)
+		variables = append(variables, assignments...)
+	}
+	diags = append(diags, evaluateScopedVariables(variables, file.scopeVariables().Declarations, func() *hcl.EvalContext {
+		return file.evalContext(evalScope{pipelineVariables: pipelineVariables, definitionVariables: sd.Variables})
+	}, func(name string, value cty.Value) {
+		sd.Variables[name] = value
+	})...)
+
+	return sd, diags
+}
+
+// decodeStringAttribute evaluates an attribute that must be a string known
+// when the configuration is loaded, such as a source or description. owner
+// names the block the attribute belongs to in diagnostics.
+func decodeStringAttribute(attr *hcl.Attribute, ctx *hcl.EvalContext, owner string) (string, hcl.Diagnostics) {
+	val, diags := attr.Expr.Value(ctx)
+	if diags.HasErrors() {
+		return "", diags
+	}
+	val, _ = val.Unmark()
+
+	if !val.IsKnown() {
+		return "", append(diags, &hcl.Diagnostic{
+			Severity: hcl.DiagError,
+			Summary:  fmt.Sprintf("Invalid %s", attr.Name),
+			Detail:   fmt.Sprintf("The %s must be known when the configuration is loaded, so it cannot refer to run context values such as git.sha.", attr.Name),
+			Subject:  attr.Expr.Range().Ptr(),
+		})
+	}
+
+	val, err := convert.Convert(val, cty.String)
+	if err != nil || val.IsNull() {
+		return "", append(diags, &hcl.Diagnostic{
+			Severity: hcl.DiagError,
+			Summary:  fmt.Sprintf("Invalid %s", attr.Name),
+			Detail:   fmt.Sprintf("The %s of a %s must be a string.", attr.Name, owner),
+			Subject:  attr.Expr.Range().Ptr(),
+		})
+	}
+	return val.AsString(), diags
+}
+
+// decodeStringListAttribute evaluates an attribute that must be a list of
+// strings known when the configuration is loaded, such as depends_on or the
+// outputs of a run block, and returns its elements along with the range of
+// each of them.
+func decodeStringListAttribute(attr *hcl.Attribute, ctx *hcl.EvalContext) ([]string, []hcl.Range, hcl.Diagnostics) {
+	val, diags := attr.Expr.Value(ctx)
+	if diags.HasErrors() {
+		return nil, nil, diags
+	}
+	val, _ = val.UnmarkDeep()
+
+	converted, err := convert.Convert(val, cty.List(cty.String))
+	switch {
+	case err != nil:
+		return nil, nil, append(diags, &hcl.Diagnostic{
+			Severity: hcl.DiagError,
+			Summary:  fmt.Sprintf("Invalid %s", attr.Name),
+			Detail:   fmt.Sprintf("The %s argument must be a list of strings: %s.", attr.Name, err),
+			Subject:  attr.Expr.Range().Ptr(),
+		})
+	case !converted.IsWhollyKnown():
+		return nil, nil, append(diags, &hcl.Diagnostic{
+			Severity: hcl.DiagError,
+			Summary:  fmt.Sprintf("Invalid %s", attr.Name),
+			Detail:   fmt.Sprintf("The %s argument cannot refer to values that are only known when the pipeline runs.", attr.Name),
+			Subject:  attr.Expr.Range().Ptr(),
+		})
+	}
+
+	result, ranges, d := decodeStringList(converted, attr.Name, attr.Expr, attr.Expr.Range())
+	return result, ranges, append(diags, d...)
+}
+
+// decodeStringList returns the elements of a known list of strings, along
+// with the range of each element. Ranges fall back to rng when expr is nil,
+// or is not a literal list. Null elements are reported and left out.
+func decodeStringList(val cty.Value, name string, expr hcl.Expression, rng hcl.Range) ([]string, []hcl.Range, hcl.Diagnostics) {
+	if val.IsNull() {
+		return nil, nil, nil
+	}
+
+	var elemExprs []hcl.Expression
+	if expr != nil {
+		exprs, d := hcl.ExprList(expr)
+		if !d.HasErrors() && len(exprs) == val.LengthInt() {
+			elemExprs = exprs
+		}
+	}
+
+	var diags hcl.Diagnostics
+	var result []string
+	var ranges []hcl.Range
+	for i, el := range val.AsValueSlice() {
+		elRange := rng
+		if elemExprs != nil {
+			elRange = elemExprs[i].Range()
+		}
+		if el.IsNull() {
+			diags = append(diags, &hcl.Diagnostic{
+				Severity: hcl.DiagError,
+				Summary:  fmt.Sprintf("Invalid %s", name),
+				Detail:   fmt.Sprintf("The elements of %s cannot be null.", name),
+				Subject:  elRange.Ptr(),
+			})
+			continue
+		}
+		result = append(result, el.AsString())
+		ranges = append(ranges, elRange)
+	}
+	return result, ranges, diags
+}
+
+// objectAttrExprs returns the expression of every attribute of a literal
+// object expression, keyed by name, or nil if expr is not one.
+func objectAttrExprs(expr hcl.Expression) map[string]hcl.Expression {
+	pairs, diags := hcl.ExprMap(expr)
+	if diags.HasErrors() {
+		return nil
+	}
+
+	exprs := make(map[string]hcl.Expression, len(pairs))
+	for _, pair := range pairs {
+		if name := hcl.ExprAsKeyword(pair.Key); name != "" {
+			exprs[name] = pair.Value
+			continue
+		}
+		// Quoted keys, such as { "name" = "build" }
+		if key, diags := pair.Key.Value(nil); !diags.HasErrors() && key.Type() == cty.String && key.IsKnown() && !key.IsNull() {
+			exprs[key.AsString()] = pair.Value
+		}
+	}
+	return exprs
 }
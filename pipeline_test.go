@@ -5,6 +5,7 @@ import (
 
 	"github.com/hashicorp/hcl/v2/hclparse"
 	"github.com/stretchr/testify/assert"
+	"github.com/zclconf/go-cty/cty"
 )
 
 func TestDecodePipelineBlock(t *testing.T) {
@@ -53,3 +54,172 @@ func TestDecodePipelineBlock(t *testing.T) {
 	assert.Equal(t, []string{"stage1"}, pipeline.Stages[1].DependsOn, "Expected 2nd stage depends_on to be []string{'stage1'} got %+v", pipeline.Stages[1].DependsOn)
 	assert.Equal(t, []string{"nm1"}, pipeline.Stages[1].Namespaces, "Expected 2nd stage namespaces to be []string{'nm1'} got %+v", pipeline.Stages[1].DependsOn)
 }
+
+func TestDecodePipelineBlockInvalidStages(t *testing.T) {
+	tests := []struct {
+		Stages  string
+		Summary string
+		Detail  string
+		Line    int
+	}{
+		{`"x"`, "Invalid stages", "must be a list of stage definitions", 3},
+		{`null`, "Invalid stages", "must be a list of stage definitions", 3},
+		{`[{ name = "a" }, "b"]`, "Invalid stage definition", "at index 1 must be an object", 3},
+		{"[\n{ depends_on = [] }\n]", "Invalid stage definition", `at index 0 is invalid: attribute "name" is required`, 4},
+		{"[\n{ name = null }\n]", "Missing stage name", "at index 0", 4},
+		{"[\n{ name = \"a\",\n depends_on = 1 }\n]", "Invalid stage definition", `at index 0 is invalid: attribute "depends_on": list of string required`, 5},
+		{"[\n{ name = \"a\",\n depends_on = [[]] }\n]", "Invalid stage definition", `at index 0 is invalid: attribute "depends_on": element 0: string required`, 5},
+		{"[\n{ name = \"a\",\n depends_on = [null] }\n]", "Invalid depends_on", "cannot be null", 5},
+		{"[\n{ name = \"a\",\n needs = [\"b\"] }\n]", "Unsupported argument", `named "needs"`, 5},
+		{`[{ name = git.branch }]`, "Invalid stage definition", "only known when the pipeline runs", 3},
+		{`git.branch`, "Invalid stages", "only known when the pipeline runs", 3},
+	}
+
+	for _, test := range tests {
+		parser := hclparse.NewParser()
+		file, diags := parser.ParseHCL([]byte(`
+		pipeline "test" {
+			stages = `+test.Stages+`
+		}
+`), "test")
+		if diags.HasErrors() {
+			t.Fatalf("Error parsing %s: %s", test.Stages, diags)
+		}
+
+		configFile, _ := file.Body.Content(configFileSchema)
+		_, d := decodePipelineBlock(configFile.Blocks[0], NewFile())
+		if assert.True(t, d.HasErrors(), "Expected errors for %s", test.Stages) {
+			assert.Equal(t, test.Summary, d[0].Summary, "Got %s for %s", d, test.Stages)
+			assert.Contains(t, d[0].Detail, test.Detail, "Got %s for %s", d, test.Stages)
+			assert.Equal(t, test.Line, d[0].Subject.Start.Line, "Got %s for %s", d, test.Stages)
+		}
+	}
+}
+
+func TestDecodePipelineBlockStagesBlock(t *testing.T) {
+	parser := hclparse.NewParser()
+	file, _ := parser.ParseHCL([]byte(`
+		pipeline "test" {
+			description = "Build and push"
+			stages {
+				build {
+					description = "Build the image"
+				}
+				push {
+					source     = "../templates/docker"
+					depends_on = ["build"]
+					namespaces = ["secret/docker"]
+					variables {
+						tag = "v1"
+					}
+				}
+			}
+		}
+`), "test")
+
+	configFile, diags := file.Body.Content(configFileSchema)
+	if diags.HasErrors() {
+		t.Fatalf("Error decoding pipeline block: %s", diags)
+	}
+	pipeline, d := decodePipelineBlock(configFile.Blocks[0], NewFile())
+	if d.HasErrors() {
+		t.Fatalf("Error decoding pipeline block: %s", d)
+	}
+
+	assert.Equal(t, "Build and push", pipeline.Description)
+	if assert.Len(t, pipeline.Stages, 2) {
+		build, push := pipeline.Stages[0], pipeline.Stages[1]
+		assert.Equal(t, "build", build.Name)
+		assert.Equal(t, "Build the image", build.Description)
+		assert.Equal(t, "", build.Source)
+		assert.Equal(t, 5, build.NameRange.Start.Line)
+
+		assert.Equal(t, "push", push.Name)
+		assert.Equal(t, "../templates/docker", push.Source)
+		assert.Equal(t, []string{"build"}, push.DependsOn)
+		assert.Equal(t, 10, push.DependsOnRanges[0].Start.Line)
+		assert.Equal(t, []string{"secret/docker"}, push.Namespaces)
+		assert.Equal(t, cty.StringVal("v1"), push.Variables["tag"])
+	}
+}
+
+func TestDecodePipelineBlockInvalidStagesBlock(t *testing.T) {
+	tests := []struct {
+		Body    string
+		Summary string
+		Detail  string
+		Line    int
+	}{
+		{"stages {\nbuild = {}\n}", "Unsupported argument", "is not expected in a stages block", 4},
+		{"stages {\nbuild \"x\" {}\n}", "Unexpected stage label", `stage "build"`, 4},
+		{"stages {\nbuild {\nname = \"x\"\n}\n}", "Unsupported argument", `named "name"`, 5},
+		{"stages {\nbuild {\ndepends_on = \"a\"\n}\n}", "Invalid depends_on", "must be a list of strings", 5},
+		{"stages {\nbuild {\ndepends_on = [null]\n}\n}", "Invalid depends_on", "cannot be null", 5},
+		{"stages {\nbuild {\nnamespaces = [git.branch]\n}\n}", "Invalid namespaces", "only known when the pipeline runs", 5},
+		{"stages {\nbuild {\nsource = git.branch\n}\n}", "Invalid source", "must be known when the configuration is loaded", 5},
+		{"stages {\nbuild {\ndescription = []\n}\n}", "Invalid description", "must be a string", 5},
+		{"stages {}\nstages {}", "Duplicate stages block", "already has a stages block", 4},
+		{"stages = []\nstages {}", "Conflicting stages", "both as an argument and as a block", 4},
+		{"source = \"../a\"\nstages {}", "Conflicting source and stages", `sets both "source" and "stages"`, 4},
+	}
+
+	for _, test := range tests {
+		parser := hclparse.NewParser()
+		file, diags := parser.ParseHCL([]byte(`
+		pipeline "test" {
+`+test.Body+`
+		}
+`), "test")
+		if diags.HasErrors() {
+			t.Fatalf("Error parsing %s: %s", test.Body, diags)
+		}
+
+		configFile, _ := file.Body.Content(configFileSchema)
+		_, d := decodePipelineBlock(configFile.Blocks[0], NewFile())
+		if assert.True(t, d.HasErrors(), "Expected errors for %s", test.Body) {
+			assert.Equal(t, test.Summary, d[0].Summary, "Got %s for %s", d, test.Body)
+			assert.Contains(t, d[0].Detail, test.Detail, "Got %s for %s", d, test.Body)
+			assert.Equal(t, test.Line, d[0].Subject.Start.Line, "Got %s for %s", d, test.Body)
+		}
+	}
+}
+
+func FuzzDecodePipelineBlock(f *testing.F) {
+	f.Add(`stages = [{ name = "a" }, { name = "b", depends_on = ["a"], namespaces = ["ns"] }]`)
+	f.Add(`stages = "x"`)
+	f.Add(`stages = [{ depends_on = [1] }]`)
+	f.Add(`stages = [{ name = null, depends_on = null }]`)
+	f.Add(`stages = [null, 1, true, {}]`)
+	f.Add(`stages = [{ name = ["a"], depends_on = { a = 1 } }]`)
+	f.Add(`stages = toset([{ name = "a" }])`)
+	f.Add(`source = 1`)
+	f.Add(`stages {
+  build {
+    depends_on = [null, 1]
+    description = {}
+    variables {
+      a = var.b
+    }
+  }
+}`)
+	f.Add(`stages = [{ name = var.a }]
+filter {
+  include {
+    paths = "x"
+  }
+}`)
+
+	f.Fuzz(func(t *testing.T, body string) {
+		parser := hclparse.NewParser()
+		file, diags := parser.ParseHCL([]byte("pipeline \"test\" {\n"+body+"\n}\n"), "test")
+		if diags.HasErrors() {
+			return
+		}
+		configFile, diags := file.Body.Content(configFileSchema)
+		if diags.HasErrors() || len(configFile.Blocks) != 1 {
+			return
+		}
+
+		decodePipelineBlock(configFile.Blocks[0], NewFile())
+	})
+}
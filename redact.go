@@ -0,0 +1,273 @@
+package factory
+
+import (
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/zclconf/go-cty/cty"
+)
+
+// Redacted replaces sensitive values wherever they would be displayed.
+const Redacted = "***"
+
+// MinRedactLength is the length of the shortest value a Redactor redacts.
+// Shorter values, such as a sensitive port number or "yes", would mask
+// unrelated text wherever they occur, so they are shown as they are.
+const MinRedactLength = 4
+
+// Redactor replaces sensitive values in text with Redacted. Sensitive values
+// are the values of sensitive variables, secrets, and every value computed
+// from them, which carry the SensitiveMark.
+//
+// A nil Redactor redacts nothing and ignores the values added to it. A
+// Redactor is safe for concurrent use.
+type Redactor struct {
+	mu     sync.RWMutex
+	values []string
+}
+
+// NewRedactor creates and returns a new Redactor for the given values.
+func NewRedactor(values ...string) *Redactor {
+	r := &Redactor{}
+	r.Add(values...)
+	return r
+}
+
+// Add adds values to redact. Values shorter than MinRedactLength are
+// ignored.
+func (r *Redactor) Add(values ...string) {
+	if r == nil {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	seen := make(map[string]bool, len(r.values))
+	for _, value := range r.values {
+		seen[value] = true
+	}
+	for _, value := range values {
+		if len(value) >= MinRedactLength && !seen[value] {
+			seen[value] = true
+			r.values = append(r.values, value)
+		}
+	}
+
+	// Longer values first, so that a value containing another one is
+	// redacted as a whole.
+	sort.SliceStable(r.values, func(i, j int) bool {
+		return len(r.values[i]) > len(r.values[j])
+	})
+}
+
+// AddValue adds every sensitive string or number in val.
+func (r *Redactor) AddValue(val cty.Value) {
+	r.Add(sensitiveStrings(val, false)...)
+}
+
+// Redact returns s with every sensitive value replaced by Redacted.
+func (r *Redactor) Redact(s string) string {
+	if r == nil {
+		return s
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.redact(s)
+}
+
+// RedactPartial redacts text that more text will follow, such as the output
+// of a process that is still running. It returns the redacted text that can
+// be shown, and the end of s that must be held back until more text follows
+// because it may be the start of a sensitive value.
+func (r *Redactor) RedactPartial(s string) (redacted, rest string) {
+	if r == nil {
+		return s, ""
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if len(r.values) == 0 {
+		return s, ""
+	}
+
+	// Hold back enough to cover the longest value, but never cut a value
+	// that starts before the cut in two.
+	cut := len(s) - (len(r.values[0]) - 1)
+	if cut < 0 {
+		cut = 0
+	}
+	for moved := true; moved; {
+		moved = false
+		for _, value := range r.values {
+			start := cut - len(value) + 1
+			if start < 0 {
+				start = 0
+			}
+			if i := strings.Index(s[start:], value); i >= 0 && start+i < cut && start+i+len(value) > cut {
+				cut = start + i + len(value)
+				moved = true
+			}
+		}
+	}
+
+	return r.redact(s[:cut]), s[cut:]
+}
+
+// redact replaces every value in s. The caller must hold r.mu.
+func (r *Redactor) redact(s string) string {
+	for _, value := range r.values {
+		s = strings.ReplaceAll(s, value, Redacted)
+	}
+	return s
+}
+
+// RedactDiagnostics returns copies of the given diagnostics whose summary
+// and detail are redacted, and whose evaluation context shows sensitive
+// values as Redacted.
+func (r *Redactor) RedactDiagnostics(diags hcl.Diagnostics) hcl.Diagnostics {
+	redacted := make(hcl.Diagnostics, 0, len(diags))
+	for _, diag := range diags {
+		d := *diag
+		d.Summary = r.Redact(d.Summary)
+		d.Detail = r.Redact(d.Detail)
+		if d.EvalContext != nil {
+			d.EvalContext = redactEvalContext(d.EvalContext)
+		}
+		redacted = append(redacted, &d)
+	}
+	return redacted
+}
+
+// redactEvalContext returns a copy of ctx whose variables have their
+// sensitive values replaced by Redacted.
+func redactEvalContext(ctx *hcl.EvalContext) *hcl.EvalContext {
+	redacted := &hcl.EvalContext{}
+	if parent := ctx.Parent(); parent != nil {
+		redacted = redactEvalContext(parent).NewChild()
+	}
+
+	redacted.Functions = ctx.Functions
+	redacted.Variables = make(map[string]cty.Value, len(ctx.Variables))
+	for name, val := range ctx.Variables {
+		redacted.Variables[name] = redactValue(val)
+	}
+	return redacted
+}
+
+// redactValue returns val with every value carrying the SensitiveMark
+// replaced by the string Redacted. As that changes the types of values,
+// collections are returned as objects and tuples, so the result is only
+// fit for display.
+func redactValue(val cty.Value) cty.Value {
+	if val.HasMark(SensitiveMark) {
+		return cty.StringVal(Redacted)
+	}
+	if !val.ContainsMarked() {
+		return val
+	}
+
+	val, _ = val.Unmark()
+	ty := val.Type()
+	switch {
+	case !val.IsKnown() || val.IsNull():
+		return val
+	case ty.IsObjectType() || ty.IsMapType():
+		attrs := make(map[string]cty.Value)
+		for it := val.ElementIterator(); it.Next(); {
+			key, v := it.Element()
+			attrs[key.AsString()] = redactValue(v)
+		}
+		return cty.ObjectVal(attrs)
+	case ty.IsListType() || ty.IsSetType() || ty.IsTupleType():
+		var elems []cty.Value
+		for it := val.ElementIterator(); it.Next(); {
+			_, v := it.Element()
+			elems = append(elems, redactValue(v))
+		}
+		return cty.TupleVal(elems)
+	default:
+		return val
+	}
+}
+
+// sensitiveStrings returns the text of every known string or number in val
+// that carries the SensitiveMark, or is inside a value that does.
+func sensitiveStrings(val cty.Value, sensitive bool) []string {
+	if val.HasMark(SensitiveMark) {
+		sensitive = true
+	}
+	if !sensitive && !val.ContainsMarked() {
+		return nil
+	}
+
+	val, _ = val.Unmark()
+	if !val.IsKnown() || val.IsNull() {
+		return nil
+	}
+
+	ty := val.Type()
+	switch {
+	case ty == cty.String && sensitive:
+		return []string{val.AsString()}
+	case ty == cty.Number && sensitive:
+		return []string{val.AsBigFloat().Text('f', -1)}
+	case ty.IsCollectionType() || ty.IsObjectType() || ty.IsTupleType():
+		var values []string
+		for it := val.ElementIterator(); it.Next(); {
+			_, v := it.Element()
+			values = append(values, sensitiveStrings(v, sensitive)...)
+		}
+		return values
+	default:
+		return nil
+	}
+}
+
+// Redactor returns a Redactor for the sensitive values of the file: the
+// values of its sensitive variables and of every variable and local
+// computed from them, in the file and in each of its stages.
+func (f *File) Redactor() *Redactor {
+	r := NewRedactor()
+	f.addSensitiveValues(r)
+	return r
+}
+
+func (f *File) addSensitiveValues(r *Redactor) {
+	scopes := []*string{nil}
+	for _, stage := range f.Stages {
+		name := stage.Name
+		scopes = append(scopes, &name)
+	}
+	for _, scopeID := range scopes {
+		ctx := f.GetEvalContext(scopeID)
+		r.AddValue(ctx.Variables["var"])
+		r.AddValue(ctx.Variables["local"])
+	}
+}
+
+// Redactor returns a Redactor for the sensitive values of every file of
+// the configuration, see File.Redactor, and of every stage as run by each
+// pipeline, see Stage.EvalContext.
+func (c *Config) Redactor() *Redactor {
+	r := NewRedactor()
+	for _, file := range c.Files {
+		file.addSensitiveValues(r)
+	}
+	for _, name := range sortedKeys(c.Pipelines) {
+		pipeline := c.Pipelines[name]
+		for _, sd := range pipeline.Stages {
+			stage, ok := c.StageFor(sd)
+			if !ok {
+				continue
+			}
+			ctx, _ := stage.EvalContext(pipeline, sd, nil, nil)
+			r.AddValue(ctx.Variables["var"])
+			r.AddValue(ctx.Variables["local"])
+		}
+	}
+	return r
+}
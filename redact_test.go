@@ -0,0 +1,118 @@
+package factory
+
+import (
+	"testing"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/zclconf/go-cty/cty"
+)
+
+func TestSensitiveValuesAreRedacted(t *testing.T) {
+	config, diags := loadTestFiles(t, map[string]string{"test.hcl": `
+variable "token" {
+  type      = string
+  sensitive = true
+}
+variable "pin" {
+  type      = number
+  default   = 1234
+  sensitive = true
+}
+variables {
+  token = "s3cr3t"
+  url   = "https://${var.token}@example.com"
+}
+locals {
+  header = "Authorization: ${upper(var.token)}"
+}
+stage "push" {
+  run "login" {
+    command = "login --url ${var.url} --header '${local.header}' --pin ${var.pin}"
+  }
+}
+pipeline "release" {
+  stages = [{ name = "push" }]
+}
+`})
+	if diags.HasErrors() {
+		t.Fatalf("Error loading config: %s", diags)
+	}
+
+	// Marks are tracked through templates, functions and other variables
+	file := config.Files[0]
+	assert.True(t, file.Variables.GlobalVariables["token"].HasMark(SensitiveMark))
+	assert.True(t, file.Variables.GlobalVariables["url"].HasMark(SensitiveMark))
+	assert.True(t, file.Locals["header"].HasMark(SensitiveMark))
+
+	command := config.Stages["push"].RunBlocks[0].Commands[0]
+	assert.Equal(t, "login --url https://s3cr3t@example.com --header 'Authorization: S3CR3T' --pin 1234", command)
+
+	r := config.Redactor()
+	assert.Equal(t, "login --url *** --header '***' --pin ***", r.Redact(command))
+
+	str := file.String()
+	assert.NotContains(t, str, "s3cr3t")
+	assert.NotContains(t, str, "S3CR3T")
+	assert.Contains(t, str, "{token: ***}")
+	// A pipeline without a filter block has no Filter
+	assert.Contains(t, str, "release: {")
+	assert.NotContains(t, str, "Filter")
+	assert.Contains(t, str, "command: login --url *** --header '***' --pin ***")
+}
+
+func TestRedactDiagnostics(t *testing.T) {
+	r := NewRedactor("s3cr3t")
+	ctx := &hcl.EvalContext{
+		Variables: map[string]cty.Value{
+			"var": cty.ObjectVal(map[string]cty.Value{
+				"token": cty.StringVal("s3cr3t").Mark(SensitiveMark),
+				"tags":  cty.ListVal([]cty.Value{cty.StringVal("a"), cty.StringVal("b").Mark(SensitiveMark)}),
+				"name":  cty.StringVal("app"),
+			}),
+		},
+	}
+	diags := r.RedactDiagnostics(hcl.Diagnostics{{
+		Severity:    hcl.DiagError,
+		Summary:     "Invalid token",
+		Detail:      `The token "s3cr3t" has expired.`,
+		EvalContext: ctx,
+	}})
+
+	assert.Equal(t, `The token "***" has expired.`, diags[0].Detail)
+	assert.Equal(t, cty.ObjectVal(map[string]cty.Value{
+		"token": cty.StringVal(Redacted),
+		"tags":  cty.TupleVal([]cty.Value{cty.StringVal("a"), cty.StringVal(Redacted)}),
+		"name":  cty.StringVal("app"),
+	}), diags[0].EvalContext.Variables["var"])
+
+	// The original diagnostics are left untouched
+	assert.True(t, ctx.Variables["var"].GetAttr("token").HasMark(SensitiveMark))
+}
+
+func TestRedactor(t *testing.T) {
+	r := NewRedactor("abcd", "", "abcdef")
+	r.AddValue(cty.ObjectVal(map[string]cty.Value{
+		"public": cty.StringVal("visible"),
+		"secret": cty.ListVal([]cty.Value{cty.StringVal("wxyz")}).Mark(SensitiveMark),
+		"short":  cty.TupleVal([]cty.Value{cty.StringVal("yes"), cty.NumberIntVal(1)}).Mark(SensitiveMark),
+	}))
+
+	// Values shorter than MinRedactLength are not redacted
+	assert.Equal(t, "*** *** visible *** yes 1", r.Redact("abcdef abcd visible wxyz yes 1"))
+
+	// The end that may start the longest value, abcdef, is held back
+	redacted, rest := r.RedactPartial("abcd wxy")
+	assert.Equal(t, "***", redacted)
+	assert.Equal(t, " wxy", rest)
+	// A value is never cut in two
+	redacted, rest = r.RedactPartial("ab abcdef")
+	assert.Equal(t, "ab ***", redacted)
+	assert.Equal(t, "", rest)
+
+	var nilRedactor *Redactor
+	nilRedactor.Add("abcd")
+	nilRedactor.AddValue(cty.StringVal("abcd").Mark(SensitiveMark))
+	assert.Equal(t, "abc", nilRedactor.Redact("abc"))
+	assert.Equal(t, "abc", nilRedactor.RedactDiagnostics(hcl.Diagnostics{{Detail: "abc"}})[0].Detail)
+}
@@ -0,0 +1,148 @@
+package factory
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/zclconf/go-cty/cty"
+)
+
+// namedExpr is an expression assigned to a name, such as a local value or
+// a variable in a variables block.
+type namedExpr struct {
+	Name string
+	Expr hcl.Expression
+
+	// NameRange is the range of the name the expression is assigned to.
+	NameRange hcl.Range
+}
+
+// decodeAssignments returns the attributes of a block that holds nothing but
+// assignments, such as variables or locals, in the order they are written.
+func decodeAssignments(body hcl.Body) ([]*namedExpr, hcl.Diagnostics) {
+	attrs, diags := body.JustAttributes()
+
+	assignments := make([]*namedExpr, 0, len(attrs))
+	for _, attr := range attrs {
+		assignments = append(assignments, &namedExpr{
+			Name:      attr.Name,
+			Expr:      attr.Expr,
+			NameRange: attr.NameRange,
+		})
+	}
+	sort.Slice(assignments, func(i, j int) bool {
+		return assignments[i].NameRange.Start.Byte < assignments[j].NameRange.Start.Byte
+	})
+
+	return assignments, diags
+}
+
+// sortByReferences orders exprs so that every expression comes after the
+// expressions it refers to as <root>.<name>, so that evaluating them in
+// order never refers to a value that is not known yet. References to names
+// that are not among exprs are ignored, as they refer to an enclosing scope.
+//
+// If shadow is set, an expression that refers to its own name refers to the
+// enclosing scope, as a stage variable may refine the global variable of the
+// same name. Otherwise, such references are cycles.
+//
+// Every cycle is reported using kind to describe the expressions, and the
+// expressions in it, or that refer to it, are left out of the order.
+func sortByReferences(exprs []*namedExpr, root, kind string, shadow bool) ([]*namedExpr, hcl.Diagnostics) {
+	var diags hcl.Diagnostics
+
+	byName := make(map[string]*namedExpr, len(exprs))
+	for _, expr := range exprs {
+		if _, ok := byName[expr.Name]; !ok {
+			byName[expr.Name] = expr
+		}
+	}
+
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := make(map[*namedExpr]int, len(exprs))
+	ordered := make(map[*namedExpr]bool, len(exprs))
+	var order []*namedExpr
+	var path []*namedExpr
+
+	var visit func(expr *namedExpr) bool
+	visit = func(expr *namedExpr) bool {
+		switch state[expr] {
+		case visited:
+			return ordered[expr]
+		case visiting:
+			// Report the cycle from the occurrence of this expression on
+			// the current path.
+			var start int
+			for start = range path {
+				if path[start] == expr {
+					break
+				}
+			}
+			var cycle []string
+			for _, e := range append(path[start:], expr) {
+				cycle = append(cycle, root+"."+e.Name)
+			}
+			diags = append(diags, &hcl.Diagnostic{
+				Severity: hcl.DiagError,
+				Summary:  fmt.Sprintf("Cycle in %s", kind),
+				Detail:   fmt.Sprintf("The %s cannot refer to themselves, directly or through each other: %s.", kind, strings.Join(cycle, " -> ")),
+				Subject:  expr.NameRange.Ptr(),
+			})
+			return false
+		}
+
+		state[expr] = visiting
+		path = append(path, expr)
+		ok := true
+		for _, name := range references(expr.Expr, root) {
+			if shadow && name == expr.Name {
+				continue
+			}
+			if dep, found := byName[name]; found && !visit(dep) {
+				ok = false
+			}
+		}
+		path = path[:len(path)-1]
+		state[expr] = visited
+
+		if ok {
+			order = append(order, expr)
+			ordered[expr] = true
+		}
+		return ok
+	}
+
+	for _, expr := range exprs {
+		if state[expr] == unvisited {
+			visit(expr)
+		}
+	}
+
+	return order, diags
+}
+
+// references returns the names the given expression refers to as
+// <root>.<name>.
+func references(expr hcl.Expression, root string) []string {
+	var names []string
+	for _, traversal := range expr.Variables() {
+		if traversal.RootName() != root || len(traversal) < 2 {
+			continue
+		}
+		switch step := traversal[1].(type) {
+		case hcl.TraverseAttr:
+			names = append(names, step.Name)
+		case hcl.TraverseIndex:
+			if key := step.Key; key.IsKnown() && key.Type().Equals(cty.String) {
+				names = append(names, key.AsString())
+			}
+		}
+	}
+	return names
+}
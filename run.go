@@ -1,13 +1,19 @@
 package factory
 
 import (
+	"fmt"
+
 	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/zclconf/go-cty/cty"
+	"github.com/zclconf/go-cty/cty/convert"
 )
 
 var runBlockSchema = &hcl.BodySchema{
 	Attributes: []hcl.AttributeSchema{
 		{Name: "command"},
 		{Name: "file"},
+		{Name: "outputs"},
 	},
 }
 
@@ -15,6 +21,29 @@ type RunBlock struct {
 	Name     string
 	Commands []string
 	File     string
+
+	// CommandExpr and FileExpr are the expressions of the command and file
+	// attributes, or nil if they are not set. When the run block is
+	// decoded, a command or file that refers to values only known when it
+	// runs, such as the run context or secrets, is not added to Commands or
+	// File. Render evaluates them just before the run block runs.
+	CommandExpr hcl.Expression
+	FileExpr    hcl.Expression
+
+	// Outputs names the outputs the run block writes to the file named by
+	// the OutputEnvVar environment variable, see ParseOutputs, and
+	// OutputRanges holds the range of each name.
+	Outputs      []string
+	OutputRanges []hcl.Range
+
+	// Dir is the directory a relative File is resolved against. It is set
+	// for the run blocks of imported stages to the directory of their
+	// source, and is empty otherwise, in which case File is resolved
+	// against the directory the pipeline runs in.
+	Dir string
+
+	// DeclRange is the range of the run block header.
+	DeclRange hcl.Range
 }
 
 func decodeRunBlock(block *hcl.Block, file *File, stageName string) (RunBlock, hcl.Diagnostics) {
@@ -22,20 +51,136 @@ func decodeRunBlock(block *hcl.Block, file *File, stageName string) (RunBlock, h
 	run, diags := block.Body.Content(runBlockSchema)
 
 	runBlock := RunBlock{
-		Name: block.Labels[0],
+		Name:      block.Labels[0],
+		DeclRange: block.DefRange,
 	}
 
 	if command, ok := run.Attributes["command"]; ok {
-		val, d := command.Expr.Value(file.GetEvalContext(&stageName))
-		diags = append(diags, d...)
-		runBlock.Commands = append(runBlock.Commands, val.AsString())
+		runBlock.CommandExpr = command.Expr
 	}
-
 	if f, ok := run.Attributes["file"]; ok {
-		val, d := f.Expr.Value(file.GetEvalContext(&stageName))
+		runBlock.FileExpr = f.Expr
+	}
+	if outputs, ok := run.Attributes["outputs"]; ok {
+		names, ranges, d := decodeStringListAttribute(outputs, file.GetEvalContext(&stageName))
 		diags = append(diags, d...)
-		runBlock.File = val.AsString()
+		for i, name := range names {
+			if !hclsyntax.ValidIdentifier(name) {
+				diags = append(diags, &hcl.Diagnostic{
+					Severity: hcl.DiagError,
+					Summary:  "Invalid output name",
+					Detail:   fmt.Sprintf("The output name %q is not valid. Output names must be identifiers, such as image_tag.", name),
+					Subject:  ranges[i].Ptr(),
+				})
+				continue
+			}
+			runBlock.Outputs = append(runBlock.Outputs, name)
+			runBlock.OutputRanges = append(runBlock.OutputRanges, ranges[i])
+		}
 	}
 
+	diags = append(diags, runBlock.check(file.GetEvalContext(&stageName))...)
+
 	return runBlock, diags
 }
+
+// Render sets Commands and File to the values of the command and file
+// expressions in ctx, which is typically the context a pipeline runs the
+// stage in, see Stage.EvalContext. Unlike when the run block is decoded, it
+// is an error for them to be unknown.
+func (rb *RunBlock) Render(ctx *hcl.EvalContext) hcl.Diagnostics {
+	var diags hcl.Diagnostics
+
+	if rb.CommandExpr != nil {
+		rb.Commands = nil
+		val, d := renderRunAttribute("command", rb.CommandExpr, ctx)
+		diags = append(diags, d...)
+		if !d.HasErrors() {
+			rb.Commands = append(rb.Commands, val)
+		}
+	}
+
+	if rb.FileExpr != nil {
+		rb.File = ""
+		val, d := renderRunAttribute("file", rb.FileExpr, ctx)
+		diags = append(diags, d...)
+		if !d.HasErrors() {
+			rb.File = val
+		}
+	}
+
+	return diags
+}
+
+// renderRunAttribute evaluates the command or file attribute of a run block
+// as a known string.
+func renderRunAttribute(name string, expr hcl.Expression, ctx *hcl.EvalContext) (string, hcl.Diagnostics) {
+	var diags hcl.Diagnostics
+	val, ok := evaluateRunAttribute(name, expr, ctx, &diags)
+	if !ok && !diags.HasErrors() {
+		diags = append(diags, &hcl.Diagnostic{
+			Severity:    hcl.DiagError,
+			Summary:     fmt.Sprintf("Unknown %s", name),
+			Detail:      fmt.Sprintf("The %s of a run block refers to values that are not known when it runs.", name),
+			Subject:     expr.Range().Ptr(),
+			Expression:  expr,
+			EvalContext: ctx,
+		})
+	}
+	return val, diags
+}
+
+// check evaluates the command and file of the run block in ctx, which may
+// hold unknown values, and reports any problem with them. Values that are
+// known are stored in Commands and File.
+func (rb *RunBlock) check(ctx *hcl.EvalContext) hcl.Diagnostics {
+	var diags hcl.Diagnostics
+
+	if rb.CommandExpr != nil {
+		rb.Commands = nil
+		if str, ok := evaluateRunAttribute("command", rb.CommandExpr, ctx, &diags); ok {
+			rb.Commands = append(rb.Commands, str)
+		}
+	}
+
+	if rb.FileExpr != nil {
+		rb.File = ""
+		if str, ok := evaluateRunAttribute("file", rb.FileExpr, ctx, &diags); ok {
+			rb.File = str
+		}
+	}
+
+	return diags
+}
+
+// evaluateRunAttribute evaluates the command or file attribute of a run
+// block as a string. ok is false if the value is unknown, which is not an
+// error, or not a string.
+func evaluateRunAttribute(name string, expr hcl.Expression, ctx *hcl.EvalContext, diags *hcl.Diagnostics) (string, bool) {
+	val, d := expr.Value(ctx)
+	*diags = append(*diags, d...)
+	if d.HasErrors() {
+		return "", false
+	}
+
+	// Sensitive values are redacted when the command is displayed, see
+	// Redactor.
+	val, _ = val.UnmarkDeep()
+	if !val.IsKnown() {
+		return "", false
+	}
+
+	val, err := convert.Convert(val, cty.String)
+	if err != nil || val.IsNull() {
+		*diags = append(*diags, &hcl.Diagnostic{
+			Severity:    hcl.DiagError,
+			Summary:     fmt.Sprintf("Invalid %s", name),
+			Detail:      fmt.Sprintf("The %s of a run block must be a string.", name),
+			Subject:     expr.Range().Ptr(),
+			Expression:  expr,
+			EvalContext: ctx,
+		})
+		return "", false
+	}
+	return val.AsString(), true
+}
@@ -53,3 +53,106 @@ func TestDecodeRunBlock(t *testing.T) {
 		assert.Equal(t, expected[i].File, runBlock.File, "Expected runBlock.File to be %s, got %s", expected[i].File, runBlock.File)
 	}
 }
+
+func TestDecodeRunBlockInvalidCommand(t *testing.T) {
+	for _, src := range []string{`command = null`, `command = ["a"]`, `file = {}`} {
+		parser := hclparse.NewParser()
+		file, _ := parser.ParseHCL([]byte(`run "test" {`+"\n"+src+"\n}"), "test")
+		stage, diags := file.Body.Content(stageBlockSchema)
+		if diags.HasErrors() {
+			t.Fatalf("Error decoding stage block: %s", diags)
+		}
+
+		_, d := decodeRunBlock(stage.Blocks[0], NewFile(), "stage")
+		if assert.True(t, d.HasErrors(), "Expected errors for %s", src) {
+			assert.Contains(t, d[0].Detail, "must be a string", "Got %s for %s", d, src)
+		}
+	}
+}
+
+func TestDecodeRunBlockOutputs(t *testing.T) {
+	parser := hclparse.NewParser()
+	file, _ := parser.ParseHCL([]byte(`
+		run "build" {
+			command = "echo tag=v1 >> $FACTORY_OUTPUT"
+			outputs = ["tag", "digest"]
+		}
+		run "invalid" {
+			command = "make"
+			outputs = ["tag", "1digest"]
+		}
+	`), "test")
+	stage, diags := file.Body.Content(stageBlockSchema)
+	if diags.HasErrors() {
+		t.Fatalf("Error decoding stage block: %s", diags)
+	}
+
+	runBlock, d := decodeRunBlock(stage.Blocks[0], NewFile(), "stage")
+	if d.HasErrors() {
+		t.Fatalf("Error decoding run block: %s", d)
+	}
+	assert.Equal(t, []string{"tag", "digest"}, runBlock.Outputs)
+	assert.Equal(t, 4, runBlock.OutputRanges[1].Start.Line)
+
+	_, d = decodeRunBlock(stage.Blocks[1], NewFile(), "stage")
+	if assert.True(t, d.HasErrors(), "Expected errors for an invalid output name") {
+		assert.Equal(t, "Invalid output name", d[0].Summary)
+		assert.Equal(t, 8, d[0].Subject.Start.Line)
+	}
+}
+
+func TestRunBlockRender(t *testing.T) {
+	parser := hclparse.NewParser()
+	file, _ := parser.ParseHCL([]byte(`
+		run "push" {
+			command = "docker push app:${git.short_sha}"
+		}
+	`), "test")
+	stage, diags := file.Body.Content(stageBlockSchema)
+	if diags.HasErrors() {
+		t.Fatalf("Error decoding stage block: %s", diags)
+	}
+
+	// The run context is unknown when the configuration is loaded
+	runBlock, d := decodeRunBlock(stage.Blocks[0], NewFile(), "stage")
+	if d.HasErrors() {
+		t.Fatalf("Error decoding run block: %s", d)
+	}
+	assert.Nil(t, runBlock.Commands)
+
+	f := NewFile()
+	d = runBlock.Render(f.GetEvalContext(nil))
+	if assert.True(t, d.HasErrors(), "Expected errors rendering with an unknown run context") {
+		assert.Equal(t, "Unknown command", d[0].Summary)
+	}
+
+	f.runContext = &RunContext{GitCommit: "0123456789"}
+	d = runBlock.Render(f.GetEvalContext(nil))
+	if d.HasErrors() {
+		t.Fatalf("Error rendering run block: %s", d)
+	}
+	assert.Equal(t, []string{"docker push app:0123456"}, runBlock.Commands)
+}
+
+func FuzzDecodeRunBlock(f *testing.F) {
+	f.Add(`command = "echo ${var.a}"`)
+	f.Add(`command = null`)
+	f.Add(`command = 1`)
+	f.Add(`file = ["a", "b"]`)
+	f.Add(`file = { a = null }`)
+	f.Add(`command = git.sha`)
+
+	f.Fuzz(func(t *testing.T, body string) {
+		parser := hclparse.NewParser()
+		file, diags := parser.ParseHCL([]byte("run \"test\" {\n"+body+"\n}\n"), "test")
+		if diags.HasErrors() {
+			return
+		}
+		stage, diags := file.Body.Content(stageBlockSchema)
+		if diags.HasErrors() || len(stage.Blocks) != 1 {
+			return
+		}
+
+		decodeRunBlock(stage.Blocks[0], NewFile(), "stage")
+	})
+}
@@ -0,0 +1,133 @@
+package factory
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/zclconf/go-cty/cty"
+)
+
+// RunContext holds the values of the read-only namespaces that expressions
+// can refer to besides var:
+//
+//	git.branch, git.sha, git.short_sha
+//	pipeline.name
+//	stage.name
+//	run.id, run.started_at
+//	env.<NAME>
+//
+// These values are only known when a pipeline runs. When a configuration is
+// loaded without a RunContext, as it is by validate, every namespace but
+// stage is unknown, so expressions that refer to them are type-checked
+// without being evaluated.
+type RunContext struct {
+	// Pipeline is the name of the pipeline being run.
+	Pipeline string
+
+	// GitBranch and GitCommit are the branch and commit that are checked
+	// out. Both are empty outside a git repository, and GitBranch is empty
+	// when HEAD is detached.
+	GitBranch string
+	GitCommit string
+
+	// ID identifies the run.
+	ID string
+
+	// StartedAt is the time the run started.
+	StartedAt time.Time
+
+	// Env holds the environment variables, keyed by name.
+	Env map[string]string
+}
+
+// NewRunContext returns a RunContext for a run of the named pipeline that
+// starts now, with a random ID and the environment of the current process.
+func NewRunContext(pipeline string) *RunContext {
+	id := make([]byte, 8)
+	rand.Read(id)
+
+	env := make(map[string]string)
+	for _, kv := range os.Environ() {
+		if name, value, ok := strings.Cut(kv, "="); ok {
+			env[name] = value
+		}
+	}
+
+	return &RunContext{
+		Pipeline:  pipeline,
+		ID:        hex.EncodeToString(id),
+		StartedAt: time.Now().UTC(),
+		Env:       env,
+	}
+}
+
+var (
+	gitNamespaceType = cty.Object(map[string]cty.Type{
+		"branch":    cty.String,
+		"sha":       cty.String,
+		"short_sha": cty.String,
+	})
+	pipelineNamespaceType = cty.Object(map[string]cty.Type{
+		"name": cty.String,
+	})
+	runNamespaceType = cty.Object(map[string]cty.Type{
+		"id":         cty.String,
+		"started_at": cty.String,
+	})
+	envNamespaceType = cty.Map(cty.String)
+)
+
+// variables returns the namespaces of the run context. The secret namespace
+// is only set inside a stage, and is unknown until the stage runs, see
+// Stage.EvalContext. The stage namespace is set by File.evalContext.
+func (rc *RunContext) variables(stage *string) map[string]cty.Value {
+	vars := map[string]cty.Value{
+		"git":      cty.UnknownVal(gitNamespaceType),
+		"pipeline": cty.UnknownVal(pipelineNamespaceType),
+		"run":      cty.UnknownVal(runNamespaceType),
+		"env":      cty.UnknownVal(envNamespaceType),
+	}
+	if stage != nil {
+		vars["secret"] = cty.DynamicVal
+	}
+	if rc == nil {
+		return vars
+	}
+
+	shortSHA := rc.GitCommit
+	if len(shortSHA) > 7 {
+		shortSHA = shortSHA[:7]
+	}
+	vars["git"] = cty.ObjectVal(map[string]cty.Value{
+		"branch":    cty.StringVal(rc.GitBranch),
+		"sha":       cty.StringVal(rc.GitCommit),
+		"short_sha": cty.StringVal(shortSHA),
+	})
+	vars["pipeline"] = cty.ObjectVal(map[string]cty.Value{
+		"name": cty.StringVal(rc.Pipeline),
+	})
+	vars["run"] = cty.ObjectVal(map[string]cty.Value{
+		"id":         cty.StringVal(rc.ID),
+		"started_at": cty.StringVal(rc.StartedAt.Format(time.RFC3339)),
+	})
+
+	env := make(map[string]cty.Value, len(rc.Env))
+	for name, value := range rc.Env {
+		env[name] = cty.StringVal(value)
+	}
+	vars["env"] = cty.MapValEmpty(cty.String)
+	if len(env) > 0 {
+		vars["env"] = cty.MapVal(env)
+	}
+
+	return vars
+}
+
+// SetRunContext sets the run context of every configuration file the
+// parser loads. Without one, the run context namespaces are unknown.
+func (p *Parser) SetRunContext(rc *RunContext) {
+	p.runContext = rc
+}
@@ -0,0 +1,88 @@
+package factory
+
+import (
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+)
+
+const runContextSource = `
+stage "push" {
+  run "push" {
+    command = "docker push my-image:${git.short_sha} # ${git.branch} ${pipeline.name} ${stage.name} ${run.id} ${env.HOME}"
+  }
+}
+pipeline "release" {
+  stages = [{ name = "push" }]
+}
+`
+
+func TestRunContextUnknownWhenValidating(t *testing.T) {
+	config := loadTestConfig(t, runContextSource)
+
+	rb := config.Stages["push"].RunBlocks[0]
+	assert.NotNil(t, rb.CommandExpr)
+	assert.Empty(t, rb.Commands)
+
+	diags := config.Validate()
+	assert.False(t, diags.HasErrors(), "Unexpected errors: %s", diags)
+}
+
+func TestRunContextValues(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	afero.WriteFile(fs, "test.hcl", []byte(runContextSource), 0644)
+
+	parser := NewParser(fs)
+	parser.SetRunContext(&RunContext{
+		Pipeline:  "release",
+		GitBranch: "main",
+		GitCommit: "0123456789abcdef",
+		ID:        "42",
+		StartedAt: time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC),
+		Env:       map[string]string{"HOME": "/home/factory"},
+	})
+	config, diags := loadTestConfigWithParser(t, parser, "test.hcl")
+	if diags.HasErrors() {
+		t.Fatalf("Error loading config: %s", diags)
+	}
+
+	assert.Equal(t, []string{"docker push my-image:0123456 # main release push 42 /home/factory"}, config.Stages["push"].RunBlocks[0].Commands)
+}
+
+func TestRunContextReturnsErrors(t *testing.T) {
+	tests := []struct {
+		Src     string
+		Summary string
+	}{
+		{`stage "s" {
+			run "r" {
+				command = "echo ${git.tag}"
+			}
+		}`, "Unsupported attribute"},
+		{`variables {
+			name = stage.name
+		}`, "Unknown variable"},
+		{`stage "s" {
+			source = "./stages/${git.branch}"
+		}`, "Invalid source"},
+	}
+
+	for _, test := range tests {
+		_, diags := loadTestFiles(t, map[string]string{"test.hcl": test.Src})
+		if assert.True(t, diags.HasErrors(), "Expected errors for %s", test.Src) {
+			assert.Equal(t, test.Summary, diags[0].Summary, "Got %s", diags)
+		}
+	}
+}
+
+func TestNewRunContext(t *testing.T) {
+	t.Setenv("FACTORY_TEST_ENV", "value")
+
+	rc := NewRunContext("release")
+	assert.Equal(t, "release", rc.Pipeline)
+	assert.Len(t, rc.ID, 16)
+	assert.Equal(t, "value", rc.Env["FACTORY_TEST_ENV"])
+	assert.NotEqual(t, rc.ID, NewRunContext("release").ID)
+}
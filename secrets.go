@@ -0,0 +1,98 @@
+package factory
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/zclconf/go-cty/cty"
+)
+
+// SensitiveMark is the cty mark carried by values that must not be
+// displayed, such as secrets and the values of sensitive variables.
+const SensitiveMark = "sensitive"
+
+// SecretsProvider looks up the secrets of the namespaces listed by the
+// namespaces attribute of a pipeline's stage definitions. What a namespace
+// means is up to the provider, for example a Vault mount and path.
+type SecretsProvider interface {
+	// Secrets returns the secrets stored in the given namespace, keyed by
+	// name.
+	Secrets(ctx context.Context, namespace string) (map[string]string, error)
+}
+
+// Secrets holds the secrets resolved for a stage.
+type Secrets map[string]string
+
+// ResolveSecrets looks up the secrets of every given namespace. When a
+// secret is stored in more than one namespace, the value of the last one
+// wins.
+func ResolveSecrets(ctx context.Context, provider SecretsProvider, namespaces []string) (Secrets, error) {
+	secrets := make(Secrets)
+	if len(namespaces) == 0 {
+		return secrets, nil
+	}
+	if provider == nil {
+		return nil, fmt.Errorf("no secrets provider is configured to read namespace %q", namespaces[0])
+	}
+
+	for _, namespace := range namespaces {
+		values, err := provider.Secrets(ctx, namespace)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read secrets of namespace %q: %w", namespace, err)
+		}
+		for name, value := range values {
+			secrets[name] = value
+		}
+	}
+
+	return secrets, nil
+}
+
+// Value returns the secrets as the object of the secret namespace, which
+// expressions refer to as secret.<name>. Every attribute is marked
+// sensitive.
+func (s Secrets) Value() cty.Value {
+	attrs := make(map[string]cty.Value, len(s))
+	for name, value := range s {
+		attrs[name] = cty.StringVal(value).Mark(SensitiveMark)
+	}
+	return cty.ObjectVal(attrs)
+}
+
+// Environ returns the secrets as environment variables in the form
+// "name=value", sorted by name. It fails if a name is not a valid
+// environment variable name.
+func (s Secrets) Environ() ([]string, error) {
+	names := make([]string, 0, len(s))
+	for name := range s {
+		if !validEnvName(name) {
+			return nil, fmt.Errorf("secret %q cannot be used as an environment variable name", name)
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	env := make([]string, 0, len(names))
+	for _, name := range names {
+		env = append(env, name+"="+s[name])
+	}
+	return env, nil
+}
+
+// validEnvName reports whether name is made of letters, digits and
+// underscores, and does not start with a digit.
+func validEnvName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i, r := range name {
+		switch {
+		case r == '_', r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z':
+		case r >= '0' && r <= '9' && i > 0:
+		default:
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,49 @@
+// Package secrets implements the providers a pipeline's stages read their
+// secrets from, see factory.SecretsProvider.
+package secrets
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// FileProvider reads secrets from a JSON file that holds an object of
+// secrets for every namespace:
+//
+//	{
+//	  "ci/docker": {"DOCKER_USER": "ci", "DOCKER_PASSWORD": "..."}
+//	}
+//
+// The file is read every time secrets are looked up, so it is never kept
+// in memory longer than a stage needs it.
+type FileProvider struct {
+	// Path is the path of the secrets file.
+	Path string
+}
+
+// NewFileProvider creates and returns a new FileProvider that reads the
+// file at path.
+func NewFileProvider(path string) *FileProvider {
+	return &FileProvider{Path: path}
+}
+
+// Secrets implements factory.SecretsProvider.
+func (p *FileProvider) Secrets(_ context.Context, namespace string) (map[string]string, error) {
+	data, err := os.ReadFile(p.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	var namespaces map[string]map[string]string
+	if err := json.Unmarshal(data, &namespaces); err != nil {
+		return nil, fmt.Errorf("invalid secrets file %s: %w", p.Path, err)
+	}
+
+	secrets, ok := namespaces[namespace]
+	if !ok {
+		return nil, fmt.Errorf("namespace is not defined in %s", p.Path)
+	}
+	return secrets, nil
+}
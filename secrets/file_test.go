@@ -0,0 +1,34 @@
+package secrets
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFileProvider(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "secrets.json")
+	err := os.WriteFile(path, []byte(`{"ci/docker": {"DOCKER_USER": "ci", "DOCKER_PASSWORD": "hunter2"}}`), 0600)
+	if err != nil {
+		t.Fatalf("Error writing secrets file: %s", err)
+	}
+
+	p := NewFileProvider(path)
+	secrets, err := p.Secrets(context.Background(), "ci/docker")
+	if err != nil {
+		t.Fatalf("Error reading secrets: %s", err)
+	}
+	assert.Equal(t, map[string]string{"DOCKER_USER": "ci", "DOCKER_PASSWORD": "hunter2"}, secrets)
+
+	_, err = p.Secrets(context.Background(), "ci/missing")
+	assert.ErrorContains(t, err, "namespace is not defined")
+
+	if err := os.WriteFile(path, []byte(`not json`), 0600); err != nil {
+		t.Fatalf("Error writing secrets file: %s", err)
+	}
+	_, err = p.Secrets(context.Background(), "ci/docker")
+	assert.ErrorContains(t, err, "invalid secrets file")
+}
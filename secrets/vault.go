@@ -0,0 +1,123 @@
+package secrets
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// VaultProvider reads secrets from a Vault KV version 2 secrets engine over
+// its HTTP API. A namespace is the mount of the engine followed by the path
+// of a secret, such as "secret/ci/docker", and its secrets are the key/value
+// pairs of the latest version of that secret.
+type VaultProvider struct {
+	// Address is the URL of the Vault server, such as
+	// "https://vault.example.com:8200".
+	Address string
+
+	// Token authenticates requests.
+	Token string
+
+	// Namespace is the Vault Enterprise namespace requests are made in, if
+	// any. It is unrelated to the namespaces secrets are looked up in.
+	Namespace string
+
+	// Client is used to make requests. Defaults to http.DefaultClient.
+	Client *http.Client
+}
+
+// NewVaultProvider creates and returns a new VaultProvider for the server
+// at address, authenticated with token.
+func NewVaultProvider(address, token string) *VaultProvider {
+	return &VaultProvider{
+		Address: address,
+		Token:   token,
+		Client:  http.DefaultClient,
+	}
+}
+
+// VaultProviderFromEnv returns a VaultProvider configured by the VAULT_ADDR,
+// VAULT_TOKEN and VAULT_NAMESPACE environment variables, the same ones the
+// vault CLI reads. It returns nil when VAULT_ADDR is not set.
+func VaultProviderFromEnv() *VaultProvider {
+	address := os.Getenv("VAULT_ADDR")
+	if address == "" {
+		return nil
+	}
+
+	p := NewVaultProvider(address, os.Getenv("VAULT_TOKEN"))
+	p.Namespace = os.Getenv("VAULT_NAMESPACE")
+	return p
+}
+
+// Secrets implements factory.SecretsProvider. Values that are not strings
+// are returned as JSON.
+func (p *VaultProvider) Secrets(ctx context.Context, namespace string) (map[string]string, error) {
+	mount, path, ok := strings.Cut(strings.Trim(namespace, "/"), "/")
+	if !ok || mount == "" || path == "" {
+		return nil, errors.New(`a Vault namespace must be in the form "<mount>/<path>"`)
+	}
+
+	addr := strings.TrimSuffix(p.Address, "/") + "/v1/" + url.PathEscape(mount) + "/data/" + escapePath(path)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, addr, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Vault-Token", p.Token)
+	if p.Namespace != "" {
+		req.Header.Set("X-Vault-Namespace", p.Namespace)
+	}
+
+	client := p.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var body struct {
+		Errors []string `json:"errors"`
+		Data   struct {
+			Data map[string]json.RawMessage `json:"data"`
+		} `json:"data"`
+	}
+	decodeErr := json.NewDecoder(resp.Body).Decode(&body)
+
+	if resp.StatusCode != http.StatusOK {
+		if len(body.Errors) > 0 {
+			return nil, fmt.Errorf("Vault returned %s: %s", resp.Status, strings.Join(body.Errors, "; "))
+		}
+		return nil, fmt.Errorf("Vault returned %s", resp.Status)
+	}
+	if decodeErr != nil {
+		return nil, fmt.Errorf("invalid response from Vault: %w", decodeErr)
+	}
+
+	secrets := make(map[string]string, len(body.Data.Data))
+	for name, raw := range body.Data.Data {
+		var s string
+		if err := json.Unmarshal(raw, &s); err == nil {
+			secrets[name] = s
+			continue
+		}
+		secrets[name] = string(raw)
+	}
+	return secrets, nil
+}
+
+// escapePath escapes every segment of a slash separated path.
+func escapePath(path string) string {
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	return strings.Join(segments, "/")
+}
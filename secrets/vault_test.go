@@ -0,0 +1,50 @@
+package secrets
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVaultProvider(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "token" {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(`{"errors": ["permission denied"]}`))
+			return
+		}
+		assert.Equal(t, "team", r.Header.Get("X-Vault-Namespace"))
+
+		switch r.URL.Path {
+		case "/v1/secret/data/ci/docker":
+			w.Write([]byte(`{"data": {"data": {"DOCKER_USER": "ci", "PORT": 5000}, "metadata": {"version": 3}}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"errors": []}`))
+		}
+	}))
+	defer server.Close()
+
+	p := NewVaultProvider(server.URL, "token")
+	p.Namespace = "team"
+	p.Client = server.Client()
+
+	secrets, err := p.Secrets(context.Background(), "secret/ci/docker")
+	if err != nil {
+		t.Fatalf("Error reading secrets: %s", err)
+	}
+	assert.Equal(t, map[string]string{"DOCKER_USER": "ci", "PORT": "5000"}, secrets)
+
+	_, err = p.Secrets(context.Background(), "secret/ci/missing")
+	assert.EqualError(t, err, "Vault returned 404 Not Found")
+
+	_, err = p.Secrets(context.Background(), "secret")
+	assert.ErrorContains(t, err, "<mount>/<path>")
+
+	p.Token = "wrong"
+	_, err = p.Secrets(context.Background(), "secret/ci/docker")
+	assert.EqualError(t, err, "Vault returned 403 Forbidden: permission denied")
+}
@@ -0,0 +1,58 @@
+package factory
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/zclconf/go-cty/cty"
+)
+
+type testSecretsProvider map[string]map[string]string
+
+func (p testSecretsProvider) Secrets(_ context.Context, namespace string) (map[string]string, error) {
+	secrets, ok := p[namespace]
+	if !ok {
+		return nil, errors.New("not found")
+	}
+	return secrets, nil
+}
+
+func TestResolveSecrets(t *testing.T) {
+	provider := testSecretsProvider{
+		"ci/docker": {"USER": "ci", "PASSWORD": "hunter2"},
+		"ci/prod":   {"PASSWORD": "s3cr3t"},
+	}
+
+	secrets, err := ResolveSecrets(context.Background(), provider, []string{"ci/docker", "ci/prod"})
+	if err != nil {
+		t.Fatalf("Error resolving secrets: %s", err)
+	}
+	assert.Equal(t, Secrets{"USER": "ci", "PASSWORD": "s3cr3t"}, secrets)
+
+	env, err := secrets.Environ()
+	if err != nil {
+		t.Fatalf("Error building environment: %s", err)
+	}
+	assert.Equal(t, []string{"PASSWORD=s3cr3t", "USER=ci"}, env)
+
+	val := secrets.Value()
+	password := val.GetAttr("PASSWORD")
+	assert.True(t, password.HasMark(SensitiveMark))
+	unmarked, _ := password.Unmark()
+	assert.Equal(t, cty.StringVal("s3cr3t"), unmarked)
+
+	_, err = ResolveSecrets(context.Background(), provider, []string{"missing"})
+	assert.EqualError(t, err, `cannot read secrets of namespace "missing": not found`)
+
+	_, err = ResolveSecrets(context.Background(), nil, []string{"ci/docker"})
+	assert.Error(t, err)
+
+	secrets, err = ResolveSecrets(context.Background(), nil, nil)
+	assert.NoError(t, err)
+	assert.Empty(t, secrets)
+
+	_, err = Secrets{"not-valid": "x"}.Environ()
+	assert.Error(t, err)
+}
@@ -1,15 +1,45 @@
 package factory
 
-import "github.com/hashicorp/hcl/v2"
+import (
+	"fmt"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/zclconf/go-cty/cty"
+)
 
 type Stage struct {
 	Name      string
 	RunBlocks []RunBlock
+
+	// Source is the address the stage is imported from, if any, and
+	// SourceRange the range of the source attribute.
+	Source      string
+	SourceRange hcl.Range
+
+	// DeclRange is the range of the stage block header.
+	DeclRange hcl.Range
+
+	// file is the file the stage is declared in, and variables and locals
+	// the assignments of its variables and locals blocks. They are
+	// evaluated again for every pipeline that runs the stage, see
+	// EvalContext.
+	file      *File
+	variables []*namedExpr
+	locals    []*namedExpr
+
+	// outputReferences are the references of the stage to the outputs of
+	// other stages, see outputReferences.
+	outputReferences []hcl.Traversal
 }
 
 var stageBlockSchema = &hcl.BodySchema{
+	Attributes: []hcl.AttributeSchema{
+		{Name: "source"},
+	},
 	Blocks: []hcl.BlockHeaderSchema{
 		{Type: "variables"},
+		{Type: "locals"},
 		{Type: "run", LabelNames: []string{"name"}},
 	},
 }
@@ -17,14 +47,44 @@ var stageBlockSchema = &hcl.BodySchema{
 func decodeStageBlock(block *hcl.Block, file *File) (*Stage, hcl.Diagnostics) {
 	content, diags := block.Body.Content(stageBlockSchema)
 	stage := &Stage{
-		Name: block.Labels[0],
+		Name:      block.Labels[0],
+		DeclRange: block.DefRange,
+		file:      file,
+	}
+
+	// References to the outputs of other stages must be known before any
+	// expression of the stage is evaluated, see evalScope.stageNamespace.
+	stage.outputReferences = outputReferences(block.Body)
+	if file.outputReferences == nil {
+		file.outputReferences = make(map[string][]hcl.Traversal)
 	}
+	file.outputReferences[stage.Name] = stage.outputReferences
 
+	// Variables and locals are decoded before the run blocks that refer
+	// to them.
+	var localsBlocks []*hcl.Block
 	for _, inner := range content.Blocks {
 		switch inner.Type {
 		case "variables":
-			varDiags := decodeVariableBlock(inner, file, stage.Name)
+			assignments, varDiags := decodeVariableBlock(inner, file, stage.Name)
 			diags = append(diags, varDiags...)
+			stage.variables = append(stage.variables, assignments...)
+		case "locals":
+			localsBlocks = append(localsBlocks, inner)
+		}
+	}
+	locals, localsDiags := decodeLocalsBlocks(localsBlocks)
+	diags = append(diags, localsDiags...)
+	stage.locals = locals
+	if len(locals) > 0 {
+		file.StageLocals[stage.Name] = make(map[string]cty.Value)
+		diags = append(diags, evaluateLocals(locals, func() *hcl.EvalContext {
+			return file.GetEvalContext(&stage.Name)
+		}, file.StageLocals[stage.Name])...)
+	}
+
+	for _, inner := range content.Blocks {
+		switch inner.Type {
 		case "run":
 			runBlock, rbDiags := decodeRunBlock(inner, file, stage.Name)
 			diags = append(diags, rbDiags...)
@@ -32,5 +92,98 @@ func decodeStageBlock(block *hcl.Block, file *File) (*Stage, hcl.Diagnostics) {
 		}
 	}
 
+	if source, ok := content.Attributes["source"]; ok {
+		val, d := source.Expr.Value(file.GetEvalContext(&stage.Name))
+		diags = append(diags, d...)
+		val, _ = val.Unmark()
+		switch {
+		case d.HasErrors():
+		case !val.IsKnown():
+			diags = append(diags, unknownSourceDiagnostic(source.Expr.Range()))
+		case val.Type() != cty.String || val.IsNull():
+			diags = append(diags, &hcl.Diagnostic{
+				Severity: hcl.DiagError,
+				Summary:  "Invalid source",
+				Detail:   "The source of a stage must be a string.",
+				Subject:  source.Expr.Range().Ptr(),
+			})
+		case len(stage.RunBlocks) > 0:
+			diags = append(diags, &hcl.Diagnostic{
+				Severity: hcl.DiagError,
+				Summary:  "Conflicting source and run blocks",
+				Detail:   fmt.Sprintf("Stage %q sets \"source\" and declares run blocks. An imported stage takes its run blocks from the source.", stage.Name),
+				Subject:  stage.RunBlocks[0].DeclRange.Ptr(),
+			})
+		default:
+			stage.Source = val.AsString()
+			stage.SourceRange = source.Expr.Range()
+		}
+	}
+
 	return stage, diags
 }
+
+// outputReferences returns the references within body to the outputs of
+// other stages, such as stage.build.outputs.tag. References to stage.name
+// are left out.
+func outputReferences(body hcl.Body) []hcl.Traversal {
+	syntaxBody, ok := body.(*hclsyntax.Body)
+	if !ok {
+		return nil
+	}
+
+	var refs []hcl.Traversal
+	hclsyntax.VisitAll(syntaxBody, func(node hclsyntax.Node) hcl.Diagnostics {
+		expr, ok := node.(*hclsyntax.ScopeTraversalExpr)
+		if !ok || expr.Traversal.RootName() != "stage" || len(expr.Traversal) < 2 {
+			return nil
+		}
+		if attr, ok := expr.Traversal[1].(hcl.TraverseAttr); ok && attr.Name != "name" {
+			refs = append(refs, expr.Traversal)
+		}
+		return nil
+	})
+	return refs
+}
+
+// EvalContext returns the context the expressions of the stage are
+// evaluated in when pipeline runs it as defined by sd, with the given
+// secrets available as secret.<name> and the outputs of the stages it
+// depends on as stage.<name>.outputs.<key>. The variables and locals of the stage
+// are evaluated again for the pipeline, so the same stage can have
+// different values in different pipelines. Variables resolve to the first
+// value found in the variables of sd, of the stage, of the pipeline and the
+// global variables, in that order. pipeline and sd may be nil, secrets are
+// unknown if nil, and so are the outputs of stages missing from outputs.
+func (s *Stage) EvalContext(pipeline *Pipeline, sd *StageDefinition, secrets Secrets, outputs map[string]Outputs) (*hcl.EvalContext, hcl.Diagnostics) {
+	file := s.file
+	if file == nil {
+		file = NewFile()
+	}
+
+	scope := evalScope{
+		stage:          &s.Name,
+		stageVariables: make(map[string]cty.Value),
+		stageLocals:    make(map[string]cty.Value),
+		secrets:        secrets,
+
+		outputReferences: s.outputReferences,
+		outputs:          outputs,
+	}
+	if pipeline != nil {
+		scope.pipelineVariables = pipeline.Variables
+	}
+	if sd != nil {
+		scope.definitionVariables = sd.Variables
+	}
+	evalCtx := func() *hcl.EvalContext {
+		return file.evalContext(scope)
+	}
+
+	diags := evaluateScopedVariables(s.variables, file.scopeVariables().Declarations, evalCtx, func(name string, value cty.Value) {
+		scope.stageVariables[name] = value
+	})
+	diags = append(diags, evaluateLocals(s.locals, evalCtx, scope.stageLocals)...)
+
+	return evalCtx(), diags
+}
@@ -39,3 +39,77 @@ func TestDecodeStageBlock(t *testing.T) {
 	assert.Equal(t, cty.StringVal("bar"), f.Variables.StageVariables["stage1"]["foo"], "Expected variable foo to be bar. got %s", f.Variables.StageVariables["stage1"]["foo"])
 	assert.Len(t, stageBlock.RunBlocks, 2, "Expected run blocks to be len 2 got %d", len(stageBlock.RunBlocks))
 }
+
+func TestStageEvalContext(t *testing.T) {
+	config := loadTestConfig(t, `
+variables {
+  env    = "global"
+  region = "us"
+  image  = { name = "app", tag = "latest" }
+}
+
+stage "deploy" {
+  variables {
+    region = "eu"
+    target = "${var.env}-${var.region}"
+  }
+  locals {
+    tag = var.image.tag
+  }
+  run "deploy" {
+    command = "deploy ${var.target} ${local.tag} ${var.image.name}"
+  }
+}
+
+pipeline "staging" {
+  stages = [{ name = "deploy" }]
+  variables {
+    env    = "staging"
+    region = "ap"
+  }
+}
+
+pipeline "production" {
+  variables {
+    env   = "production"
+    image = { tag = "v1" }
+  }
+  stages {
+    deploy {
+      variables {
+        region = "us-east"
+      }
+    }
+  }
+}
+`)
+
+	stage := config.Stages["deploy"]
+	assert.Equal(t, []string{"deploy global-eu latest app"}, stage.RunBlocks[0].Commands)
+
+	tests := []struct {
+		Pipeline string
+		Command  string
+	}{
+		// The stage's variables take precedence over the pipeline's
+		{"staging", "deploy staging-eu latest app"},
+		// The stage definition's variables take precedence over the
+		// stage's, and pipeline objects are merged into global ones
+		{"production", "deploy production-us-east v1 app"},
+	}
+	for _, test := range tests {
+		pipeline := config.Pipelines[test.Pipeline]
+		ctx, diags := stage.EvalContext(pipeline, pipeline.Stages[0], nil, nil)
+		if diags.HasErrors() {
+			t.Fatalf("Error evaluating stage for %s: %s", test.Pipeline, diags)
+		}
+		rb := stage.RunBlocks[0]
+		if diags := rb.Render(ctx); diags.HasErrors() {
+			t.Fatalf("Error rendering run block for %s: %s", test.Pipeline, diags)
+		}
+		assert.Equal(t, []string{test.Command}, rb.Commands, "Wrong command for %s", test.Pipeline)
+	}
+
+	// The stage itself is left untouched
+	assert.Equal(t, []string{"deploy global-eu latest app"}, stage.RunBlocks[0].Commands)
+}
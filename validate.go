@@ -0,0 +1,359 @@
+package factory
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/zclconf/go-cty/cty"
+)
+
+// Validate runs the semantic checks that cannot be expressed by the HCL
+// schemas and returns a diagnostic for every problem found.
+//
+// It reports pipelines that reference undeclared stages, stages listed twice
+// in the same pipeline, depends_on entries naming a stage that is not part
+// of the pipeline, dependency cycles, references to the outputs of a stage
+// that the referring stage does not depend on or that are not declared,
+// duplicate run labels and outputs within a stage, run blocks that do not
+// declare exactly one of command or file, and run blocks that are only
+// invalid with the variables of a pipeline that runs them.
+// Duplicate pipeline, stage and variable names across files are reported by
+// NewConfig when the files are merged.
+func (c *Config) Validate() hcl.Diagnostics {
+	var diags hcl.Diagnostics
+
+	for _, name := range sortedKeys(c.Pipelines) {
+		diags = append(diags, c.validatePipeline(c.Pipelines[name])...)
+	}
+
+	for _, name := range sortedKeys(c.Stages) {
+		diags = append(diags, validateStage(c.Stages[name])...)
+	}
+	for _, name := range sortedKeys(c.Pipelines) {
+		for _, sd := range c.Pipelines[name].Stages {
+			if sd.Stage != nil {
+				diags = append(diags, validateStage(sd.Stage)...)
+			}
+		}
+	}
+
+	return diags
+}
+
+func (c *Config) validatePipeline(pipeline *Pipeline) hcl.Diagnostics {
+	var diags hcl.Diagnostics
+
+	defs := make(map[string]*StageDefinition)
+	for _, sd := range pipeline.Stages {
+		// A stage definition whose source cannot be imported has already
+		// been reported while loading the configuration.
+		if _, ok := c.StageFor(sd); !ok && sd.Source == "" {
+			diags = append(diags, &hcl.Diagnostic{
+				Severity: hcl.DiagError,
+				Summary:  "Reference to undeclared stage",
+				Detail:   fmt.Sprintf("Pipeline %q references a stage named %q, but no stage with that name is declared.", pipeline.Name, sd.Name),
+				Subject:  sd.NameRange.Ptr(),
+			})
+		}
+
+		if existing, ok := defs[sd.Name]; ok {
+			diags = append(diags, &hcl.Diagnostic{
+				Severity: hcl.DiagError,
+				Summary:  "Duplicate stage definition",
+				Detail:   fmt.Sprintf("Stage %q is already part of pipeline %q at %s. Each stage can only be listed once.", sd.Name, pipeline.Name, existing.DeclRange),
+				Subject:  sd.NameRange.Ptr(),
+			})
+			continue
+		}
+		defs[sd.Name] = sd
+	}
+
+	for _, sd := range pipeline.Stages {
+		if defs[sd.Name] != sd {
+			continue
+		}
+		for i, dep := range sd.DependsOn {
+			if _, ok := defs[dep]; !ok {
+				diags = append(diags, &hcl.Diagnostic{
+					Severity: hcl.DiagError,
+					Summary:  "Invalid stage dependency",
+					Detail:   fmt.Sprintf("Stage %q depends on %q, which is not part of pipeline %q.", sd.Name, dep, pipeline.Name),
+					Subject:  sd.DependsOnRanges[i].Ptr(),
+				})
+			}
+		}
+	}
+
+	diags = append(diags, validateDependencyCycles(pipeline, defs)...)
+
+	for _, sd := range pipeline.Stages {
+		if stage, ok := c.StageFor(sd); ok && defs[sd.Name] == sd {
+			diags = append(diags, c.validateOutputReferences(pipeline, defs, sd, stage)...)
+		}
+	}
+
+	for _, sd := range pipeline.Stages {
+		if stage, ok := c.StageFor(sd); ok && defs[sd.Name] == sd {
+			diags = append(diags, validateStageInPipeline(pipeline, sd, stage)...)
+		}
+	}
+
+	return diags
+}
+
+// validateDependencyCycles reports every dependency cycle between the stages
+// of a pipeline, pointing at the depends_on entry that closes the cycle.
+func validateDependencyCycles(pipeline *Pipeline, defs map[string]*StageDefinition) hcl.Diagnostics {
+	var diags hcl.Diagnostics
+
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := make(map[string]int)
+	var path []string
+
+	var visit func(sd *StageDefinition)
+	visit = func(sd *StageDefinition) {
+		state[sd.Name] = visiting
+		path = append(path, sd.Name)
+
+		for i, dep := range sd.DependsOn {
+			depDef, ok := defs[dep]
+			if !ok {
+				continue
+			}
+
+			switch state[dep] {
+			case unvisited:
+				visit(depDef)
+			case visiting:
+				var start int
+				for start = range path {
+					if path[start] == dep {
+						break
+					}
+				}
+				cycle := append(append([]string{}, path[start:]...), dep)
+				diags = append(diags, &hcl.Diagnostic{
+					Severity: hcl.DiagError,
+					Summary:  "Dependency cycle",
+					Detail:   fmt.Sprintf("The stages of pipeline %q depend on each other in a cycle: %s.", pipeline.Name, strings.Join(cycle, " -> ")),
+					Subject:  sd.DependsOnRanges[i].Ptr(),
+				})
+			}
+		}
+
+		path = path[:len(path)-1]
+		state[sd.Name] = visited
+	}
+
+	for _, sd := range pipeline.Stages {
+		if defs[sd.Name] == sd && state[sd.Name] == unvisited {
+			visit(sd)
+		}
+	}
+
+	return diags
+}
+
+// validateOutputReferences reports the references of a stage to the outputs
+// of other stages that cannot be resolved when pipeline runs it: references
+// to stages that are not part of the pipeline or that the stage does not
+// depend on, and to outputs that the stage referred to does not declare.
+func (c *Config) validateOutputReferences(pipeline *Pipeline, defs map[string]*StageDefinition, sd *StageDefinition, stage *Stage) hcl.Diagnostics {
+	var diags hcl.Diagnostics
+
+	for _, ref := range stage.outputReferences {
+		name := ref[1].(hcl.TraverseAttr).Name
+		if len(ref) < 3 || !isAttr(ref[2], "outputs") {
+			diags = append(diags, &hcl.Diagnostic{
+				Severity: hcl.DiagError,
+				Summary:  "Invalid stage reference",
+				Detail:   fmt.Sprintf("Stage %q refers to stage %q, but only the outputs of another stage can be referred to, as stage.%s.outputs.<key>.", sd.Name, name, name),
+				Subject:  ref.SourceRange().Ptr(),
+			})
+			continue
+		}
+
+		producerDef, ok := defs[name]
+		if !ok {
+			diags = append(diags, &hcl.Diagnostic{
+				Severity: hcl.DiagError,
+				Summary:  "Reference to undeclared stage",
+				Detail:   fmt.Sprintf("Stage %q refers to the outputs of stage %q, which is not part of pipeline %q.", sd.Name, name, pipeline.Name),
+				Subject:  ref.SourceRange().Ptr(),
+			})
+			continue
+		}
+		if !contains(sd.DependsOn, name) {
+			diags = append(diags, &hcl.Diagnostic{
+				Severity: hcl.DiagError,
+				Summary:  "Missing stage dependency",
+				Detail:   fmt.Sprintf("Stage %q refers to the outputs of stage %q, so it must list %q in its depends_on in pipeline %q.", sd.Name, name, name, pipeline.Name),
+				Subject:  ref.SourceRange().Ptr(),
+			})
+		}
+
+		producer, ok := c.StageFor(producerDef)
+		if !ok || len(ref) < 4 {
+			continue
+		}
+		var key string
+		switch step := ref[3].(type) {
+		case hcl.TraverseAttr:
+			key = step.Name
+		case hcl.TraverseIndex:
+			if step.Key.Type() != cty.String || !step.Key.IsKnown() {
+				continue
+			}
+			key = step.Key.AsString()
+		default:
+			continue
+		}
+		if !declaresOutput(producer, key) {
+			diags = append(diags, &hcl.Diagnostic{
+				Severity: hcl.DiagError,
+				Summary:  "Undeclared output",
+				Detail:   fmt.Sprintf("Stage %q refers to output %q of stage %q, but no run block of stage %q declares it.", sd.Name, key, name, name),
+				Subject:  ref.SourceRange().Ptr(),
+			})
+		}
+	}
+
+	return diags
+}
+
+// declaresOutput reports whether a run block of stage declares the named
+// output.
+func declaresOutput(stage *Stage, name string) bool {
+	for _, rb := range stage.RunBlocks {
+		if contains(rb.Outputs, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// validateStageInPipeline type-checks the variables, locals and run blocks
+// of a stage in the context a pipeline runs it in, with the run context and
+// secrets unknown. Only the problems caused by the variables of the
+// pipeline or of its stage definition are reported, as the others are
+// reported when the stage is decoded.
+func validateStageInPipeline(pipeline *Pipeline, sd *StageDefinition, stage *Stage) hcl.Diagnostics {
+	if len(pipeline.Variables) == 0 && len(sd.Variables) == 0 {
+		return nil
+	}
+
+	reported := make(map[string]bool)
+	for _, diag := range checkStage(stage, nil, nil) {
+		reported[diagnosticKey(diag)] = true
+	}
+
+	var diags hcl.Diagnostics
+	for _, diag := range checkStage(stage, pipeline, sd) {
+		if !reported[diagnosticKey(diag)] {
+			diags = append(diags, diag)
+		}
+	}
+	return diags
+}
+
+// checkStage evaluates the variables, locals and run blocks of a stage as
+// pipeline runs it, see Stage.EvalContext, and returns the problems found.
+func checkStage(stage *Stage, pipeline *Pipeline, sd *StageDefinition) hcl.Diagnostics {
+	ctx, diags := stage.EvalContext(pipeline, sd, nil, nil)
+	for _, rb := range stage.RunBlocks {
+		diags = append(diags, rb.check(ctx)...)
+	}
+	return diags
+}
+
+// diagnosticKey identifies a diagnostic by its summary, detail and subject.
+func diagnosticKey(diag *hcl.Diagnostic) string {
+	key := diag.Summary + "\x00" + diag.Detail
+	if diag.Subject != nil {
+		key += "\x00" + diag.Subject.String()
+	}
+	return key
+}
+
+func validateStage(stage *Stage) hcl.Diagnostics {
+	var diags hcl.Diagnostics
+
+	runs := make(map[string]RunBlock)
+	outputs := make(map[string]hcl.Range)
+	for _, rb := range stage.RunBlocks {
+		for i, name := range rb.Outputs {
+			if existing, ok := outputs[name]; ok {
+				diags = append(diags, &hcl.Diagnostic{
+					Severity: hcl.DiagError,
+					Summary:  "Duplicate output",
+					Detail:   fmt.Sprintf("Stage %q already declares output %q at %s. Output names must be unique within a stage.", stage.Name, name, existing),
+					Subject:  rb.OutputRanges[i].Ptr(),
+				})
+			} else {
+				outputs[name] = rb.OutputRanges[i]
+			}
+		}
+
+		if existing, ok := runs[rb.Name]; ok {
+			diags = append(diags, &hcl.Diagnostic{
+				Severity: hcl.DiagError,
+				Summary:  "Duplicate run block",
+				Detail:   fmt.Sprintf("Stage %q already has a run block labelled %q at %s. Run labels must be unique within a stage.", stage.Name, rb.Name, existing.DeclRange),
+				Subject:  rb.DeclRange.Ptr(),
+			})
+		} else {
+			runs[rb.Name] = rb
+		}
+
+		switch {
+		case rb.CommandExpr == nil && rb.FileExpr == nil:
+			diags = append(diags, &hcl.Diagnostic{
+				Severity: hcl.DiagError,
+				Summary:  "Missing command or file",
+				Detail:   fmt.Sprintf("Run block %q in stage %q must set either the \"command\" or the \"file\" attribute.", rb.Name, stage.Name),
+				Subject:  rb.DeclRange.Ptr(),
+			})
+		case rb.CommandExpr != nil && rb.FileExpr != nil:
+			diags = append(diags, &hcl.Diagnostic{
+				Severity: hcl.DiagError,
+				Summary:  "Conflicting command and file",
+				Detail:   fmt.Sprintf("Run block %q in stage %q sets both \"command\" and \"file\". Only one of them may be set.", rb.Name, stage.Name),
+				Subject:  rb.DeclRange.Ptr(),
+			})
+		}
+	}
+
+	return diags
+}
+
+// isAttr reports whether step is an attribute access of the given name.
+func isAttr(step hcl.Traverser, name string) bool {
+	attr, ok := step.(hcl.TraverseAttr)
+	return ok && attr.Name == name
+}
+
+// contains reports whether list contains s.
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// sortedKeys returns the keys of m in lexical order.
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,230 @@
+package factory
+
+import (
+	"testing"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+)
+
+func loadTestConfig(t *testing.T, src string) *Config {
+	t.Helper()
+
+	fs := afero.NewMemMapFs()
+	afero.WriteFile(fs, "test.hcl", []byte(src), 0644)
+
+	files, diags := NewParser(fs).LoadFiles([]string{"test.hcl"})
+	if diags.HasErrors() {
+		t.Fatalf("Error loading files: %s", diags)
+	}
+
+	config, diags := NewConfig(files)
+	if diags.HasErrors() {
+		t.Fatalf("Error merging files: %s", diags)
+	}
+
+	return config
+}
+
+func TestValidateValidConfig(t *testing.T) {
+	config := loadTestConfig(t, `
+pipeline "test" {
+  stages = [
+    { name = "stage1" },
+    { name = "stage2", depends_on = ["stage1"] },
+  ]
+}
+stage "stage1" {
+  run "build" {
+    command = "make"
+  }
+}
+stage "stage2" {
+  run "deploy" {
+    file = "deploy.sh"
+  }
+}
+`)
+
+	diags := config.Validate()
+	assert.Empty(t, diags, "Expected no diagnostics got %s", diags)
+}
+
+func TestValidatePipelineReferences(t *testing.T) {
+	config := loadTestConfig(t, `
+pipeline "test" {
+  stages = [
+    { name = "stage1", depends_on = ["stage3"] },
+    { name = "missing" },
+    { name = "stage1" },
+  ]
+}
+stage "stage1" {
+  run "build" {
+    command = "make"
+  }
+}
+stage "stage3" {
+  run "build" {
+    command = "make"
+  }
+}
+`)
+
+	diags := config.Validate()
+	expected := []struct {
+		Summary string
+		Line    int
+	}{
+		{"Reference to undeclared stage", 5},
+		{"Duplicate stage definition", 6},
+		{"Invalid stage dependency", 4},
+	}
+	if assert.Len(t, diags, len(expected), "Got diagnostics %s", diags) {
+		for i, diag := range diags {
+			assert.Equal(t, expected[i].Summary, diag.Summary)
+			assert.Equal(t, expected[i].Line, diag.Subject.Start.Line, "Expected %q on line %d got %d", diag.Summary, expected[i].Line, diag.Subject.Start.Line)
+		}
+	}
+	assert.Equal(t, hcl.Pos{Line: 4, Column: 38, Byte: 69}, diags[2].Subject.Start)
+}
+
+func TestValidateDependencyCycle(t *testing.T) {
+	config := loadTestConfig(t, `
+pipeline "test" {
+  stages = [
+    { name = "a", depends_on = ["c"] },
+    { name = "b", depends_on = ["a"] },
+    { name = "c", depends_on = ["b"] },
+  ]
+}
+stage "a" {
+  run "x" { command = "true" }
+}
+stage "b" {
+  run "x" { command = "true" }
+}
+stage "c" {
+  run "x" { command = "true" }
+}
+`)
+
+	diags := config.Validate()
+	if assert.Len(t, diags, 1, "Got diagnostics %s", diags) {
+		assert.Equal(t, "Dependency cycle", diags[0].Summary)
+		assert.Contains(t, diags[0].Detail, "a -> c -> b -> a")
+		assert.Equal(t, 5, diags[0].Subject.Start.Line)
+	}
+}
+
+func TestValidateRunBlocks(t *testing.T) {
+	config := loadTestConfig(t, `
+stage "stage1" {
+  run "build" {
+    command = "make"
+  }
+  run "build" {
+    command = "make test"
+  }
+  run "empty" {
+  }
+  run "both" {
+    command = "make"
+    file    = "make.sh"
+  }
+}
+`)
+
+	diags := config.Validate()
+	expected := []struct {
+		Summary string
+		Line    int
+	}{
+		{"Duplicate run block", 6},
+		{"Missing command or file", 9},
+		{"Conflicting command and file", 11},
+	}
+	if assert.Len(t, diags, len(expected), "Got diagnostics %s", diags) {
+		for i, diag := range diags {
+			assert.Equal(t, expected[i].Summary, diag.Summary)
+			assert.Equal(t, expected[i].Line, diag.Subject.Start.Line)
+		}
+	}
+}
+
+func TestValidateStageInPipeline(t *testing.T) {
+	config := loadTestConfig(t, `
+variables {
+  tags = "latest"
+}
+pipeline "release" {
+  stages = [{ name = "push" }, { name = "deploy" }]
+  variables {
+    tags = ["a", "b"]
+  }
+}
+stage "push" {
+  run "push" {
+    command = "docker push app:${var.tags}"
+  }
+}
+stage "deploy" {
+  run "deploy" {
+    command = "deploy --token ${secret.TOKEN} --sha ${git.sha}"
+  }
+}
+`)
+
+	diags := config.Validate()
+	if assert.Len(t, diags, 1, "Got diagnostics %s", diags) {
+		assert.Equal(t, "Invalid template interpolation value", diags[0].Summary)
+		assert.Equal(t, 13, diags[0].Subject.Start.Line)
+	}
+}
+
+func TestValidateOutputReferences(t *testing.T) {
+	config := loadTestConfig(t, `
+pipeline "release" {
+  stages = [
+    { name = "build" },
+    { name = "push", depends_on = ["build"] },
+    { name = "deploy" },
+  ]
+}
+stage "build" {
+  run "build" {
+    command = "make"
+    outputs = ["tag", "tag"]
+  }
+}
+stage "push" {
+  run "push" {
+    command = "docker push app:${stage.build.outputs.tag} ${stage.build.outputs.digest}"
+  }
+}
+stage "deploy" {
+  run "deploy" {
+    command = "deploy ${stage.build.outputs.tag} ${stage.test.outputs.tag} ${stage.push.name}"
+  }
+}
+`)
+
+	diags := config.Validate()
+	expected := []struct {
+		Summary string
+		Line    int
+	}{
+		{"Undeclared output", 17},
+		{"Missing stage dependency", 22},
+		{"Reference to undeclared stage", 22},
+		{"Invalid stage reference", 22},
+		{"Duplicate output", 12},
+	}
+	if assert.Len(t, diags, len(expected), "Got diagnostics %s", diags) {
+		for i, diag := range diags {
+			assert.Equal(t, expected[i].Summary, diag.Summary)
+			assert.Equal(t, expected[i].Line, diag.Subject.Start.Line)
+		}
+	}
+}
@@ -1,6 +1,8 @@
 package factory
 
 import (
+	"fmt"
+
 	"github.com/hashicorp/hcl/v2"
 	"github.com/zclconf/go-cty/cty"
 )
@@ -13,19 +15,43 @@ const (
 )
 
 /*
-Current issues with variables. Resolving the variables such as var.foo or resolving objects var.foo.bar
+Variables are resolved as attributes of the var object, so values of any
+type can be referenced, including nested attributes such as var.foo.bar.
 
 Scopes, there is only 1 global scope but can be many module and resource scopes.
+Stage variables are merged into the global scope, objects attribute by
+attribute. The variables of pipelines and of their stage definitions are
+held by Pipeline and StageDefinition, and layered with these scopes when a
+pipeline runs a stage, see Stage.EvalContext.
 */
 type Variables struct {
 	GlobalVariables map[string]cty.Value
 	StageVariables  map[string]map[string]cty.Value
+
+	// GlobalVariableRanges records where each global variable was declared.
+	GlobalVariableRanges map[string]hcl.Range
+
+	// Overrides take precedence over both global and stage variables. They
+	// hold the values a caller passes to an imported pipeline or stage.
+	Overrides map[string]cty.Value
+
+	// rawOverrides names the overrides that still hold the raw text of an
+	// input value, see InputValue.
+	rawOverrides map[string]bool
+
+	// Declarations holds the typed variables declared with variable blocks.
+	// Their defaults are used when no other value is assigned.
+	Declarations map[string]*Variable
 }
 
 func NewVariables() *Variables {
 	return &Variables{
-		GlobalVariables: make(map[string]cty.Value),
-		StageVariables:  make(map[string]map[string]cty.Value),
+		GlobalVariables:      make(map[string]cty.Value),
+		StageVariables:       make(map[string]map[string]cty.Value),
+		GlobalVariableRanges: make(map[string]hcl.Range),
+		Overrides:            make(map[string]cty.Value),
+		rawOverrides:         make(map[string]bool),
+		Declarations:         make(map[string]*Variable),
 	}
 }
 
@@ -41,27 +67,229 @@ func (v *Variables) InsertGlobal(key string, value *cty.Value) {
 	v.GlobalVariables[key] = *value
 }
 
+// merge copies the variables of other into v. A global variable or
+// declaration that already exists in v is reported as a duplicate and left
+// untouched.
+func (v *Variables) merge(other *Variables) hcl.Diagnostics {
+	var diags hcl.Diagnostics
+
+	for name, decl := range other.Declarations {
+		if existing, ok := v.Declarations[name]; ok {
+			diags = append(diags, duplicateDiagnostic("variable", name, existing.DeclRange, decl.DeclRange))
+			continue
+		}
+		v.Declarations[name] = decl
+	}
+
+	for name, value := range other.Overrides {
+		v.Overrides[name] = value
+		if other.rawOverrides[name] {
+			v.rawOverrides[name] = true
+		} else {
+			delete(v.rawOverrides, name)
+		}
+	}
+
+	for name, value := range other.GlobalVariables {
+		value := value
+		if _, ok := v.GlobalVariables[name]; ok {
+			diags = append(diags, duplicateDiagnostic("variable", name, v.GlobalVariableRanges[name], other.GlobalVariableRanges[name]))
+			continue
+		}
+		v.InsertGlobal(name, &value)
+		v.GlobalVariableRanges[name] = other.GlobalVariableRanges[name]
+	}
+
+	for scopeID, scope := range other.StageVariables {
+		for name, value := range scope {
+			value := value
+			v.InsertStage(name, &value, scopeID)
+		}
+	}
+
+	return diags
+}
+
+// prepareOverrides converts every override that has a declaration to its
+// declared type, leaving the overrides that cannot be converted as they are.
+// Problems with the values are reported once all files are merged, see
+// prepareDeclared.
+func (v *Variables) prepareOverrides() {
+	for name, value := range v.Overrides {
+		decl, ok := v.Declarations[name]
+		if !ok {
+			continue
+		}
+		if v.rawOverrides[name] {
+			parsed, d := decl.parseInput(value)
+			if d.HasErrors() {
+				continue
+			}
+			value = parsed
+		}
+		if prepared, d := decl.Prepare(value, decl.DeclRange); !d.HasErrors() {
+			v.Overrides[name] = prepared
+			delete(v.rawOverrides, name)
+		}
+	}
+}
+
+// prepareDeclared converts every global variable and override that has a
+// declaration to its declared type and validates it, then reports every
+// required variable that has no value.
+func (v *Variables) prepareDeclared() hcl.Diagnostics {
+	var diags hcl.Diagnostics
+
+	for _, name := range sortedKeys(v.Declarations) {
+		decl := v.Declarations[name]
+		if value, ok := v.Overrides[name]; ok {
+			if v.rawOverrides[name] {
+				parsed, d := decl.parseInput(value)
+				diags = append(diags, d...)
+				if d.HasErrors() {
+					continue
+				}
+				value = parsed
+				delete(v.rawOverrides, name)
+			}
+			prepared, d := decl.Prepare(value, decl.DeclRange)
+			diags = append(diags, d...)
+			v.Overrides[name] = prepared
+			continue
+		}
+
+		if value, ok := v.GlobalVariables[name]; ok {
+			prepared, d := decl.Prepare(value, v.GlobalVariableRanges[name])
+			diags = append(diags, d...)
+			v.GlobalVariables[name] = prepared
+			continue
+		}
+
+		if decl.Required() {
+			diags = append(diags, &hcl.Diagnostic{
+				Severity: hcl.DiagError,
+				Summary:  "No value for required variable",
+				Detail:   fmt.Sprintf("The variable %q has no default, so a value must be assigned to it.", name),
+				Subject:  decl.DeclRange.Ptr(),
+			})
+		}
+	}
+
+	return diags
+}
+
+// globalAssignment is an assignment of a global variables block, together
+// with the file it is written in.
+type globalAssignment struct {
+	*namedExpr
+	File *File
+}
+
 func decodeGlobalVariableBlock(block *hcl.Block, file *File) hcl.Diagnostics {
-	vars, diags := block.Body.JustAttributes()
+	assignments, diags := decodeAssignments(block.Body)
+
+	globals := make([]*globalAssignment, len(assignments))
+	for i, assignment := range assignments {
+		globals[i] = &globalAssignment{namedExpr: assignment, File: file}
+	}
+	diags = append(diags, evaluateGlobalVariables(globals)...)
+
+	return diags
+}
 
-	for _, attr := range vars {
-		name := attr.Name
-		value, d := attr.Expr.Value(file.GetEvalContext(nil))
+// evaluateGlobalVariables evaluates the assignments of global variables
+// blocks in dependency order, so that they can refer to each other no matter
+// which block or file they are written in. Each value is stored in the file
+// the assignment is written in. Assignments that are part of a cycle, or
+// fail to evaluate, are set to an unknown value.
+func evaluateGlobalVariables(globals []*globalAssignment) hcl.Diagnostics {
+	exprs := make([]*namedExpr, len(globals))
+	files := make(map[*namedExpr]*File, len(globals))
+	for i, global := range globals {
+		exprs[i] = global.namedExpr
+		files[global.namedExpr] = global.File
+	}
+
+	order, diags := sortByReferences(exprs, "var", "variables", false)
+	evaluated := make(map[*namedExpr]bool, len(order))
+	for _, expr := range order {
+		file := files[expr]
+		value, d := expr.Expr.Value(file.GetEvalContext(nil))
 		diags = append(diags, d...)
-		file.Variables.InsertGlobal(name, &value)
+		if d.HasErrors() {
+			value = cty.DynamicVal
+		}
+		file.insertGlobal(expr.Name, value, expr.NameRange)
+		evaluated[expr] = true
+	}
+
+	// Assignments left out of the order are part of a cycle, or refer to one.
+	for _, expr := range exprs {
+		if !evaluated[expr] {
+			files[expr].insertGlobal(expr.Name, cty.DynamicVal, expr.NameRange)
+		}
 	}
 
 	return diags
 }
 
-func decodeVariableBlock(block *hcl.Block, file *File, scopeID string) hcl.Diagnostics {
-	vars, diags := block.Body.JustAttributes()
+// prepareAssignment converts a value assigned to the variable decl in the
+// variables block of a stage, a pipeline or a stage definition to the
+// declared type and validates it. Objects
+// are merged into the value they override, see deepMerge, so the merged
+// value is prepared rather than the assigned one, taking the value it
+// overrides from ctx.
+func prepareAssignment(decl *Variable, value cty.Value, ctx *hcl.EvalContext, rng hcl.Range) (cty.Value, hcl.Diagnostics) {
+	if vars := ctx.Variables["var"]; vars.Type().IsObjectType() && vars.Type().HasAttribute(decl.Name) {
+		value = deepMerge(vars.GetAttr(decl.Name), value)
+	}
+	return decl.Prepare(value, rng)
+}
 
-	for _, attr := range vars {
-		name := attr.Name
-		value, d := attr.Expr.Value(file.GetEvalContext(&scopeID))
+// decodeVariableBlock decodes the variables block of the stage scopeID and
+// returns its assignments, so that they can be evaluated again for each
+// pipeline that runs the stage, see Stage.EvalContext.
+func decodeVariableBlock(block *hcl.Block, file *File, scopeID string) ([]*namedExpr, hcl.Diagnostics) {
+	assignments, diags := decodeAssignments(block.Body)
+	diags = append(diags, evaluateScopedVariables(assignments, file.scopeVariables().Declarations, func() *hcl.EvalContext {
+		return file.GetEvalContext(&scopeID)
+	}, func(name string, value cty.Value) {
+		file.insertStage(name, value, scopeID)
+	})...)
+
+	return assignments, diags
+}
+
+// evaluateScopedVariables evaluates the assignments of the variables blocks
+// of a stage, a pipeline or a pipeline's stage definition in dependency order, passing each value to store as soon as it is
+// known so that the assignments evaluated after it can refer to it through
+// the context returned by evalCtx. A variable that refers to its own name
+// refers to the value it overrides. Values of variables declared in decls
+// are converted to their type and validated, see prepareAssignment.
+// Assignments that are part of a cycle, or fail to evaluate, are set to an
+// unknown value.
+func evaluateScopedVariables(assignments []*namedExpr, decls map[string]*Variable, evalCtx func() *hcl.EvalContext, store func(name string, value cty.Value)) hcl.Diagnostics {
+	order, diags := sortByReferences(assignments, "var", "variables", true)
+	evaluated := make(map[*namedExpr]bool, len(order))
+	for _, assignment := range order {
+		ctx := evalCtx()
+		value, d := assignment.Expr.Value(ctx)
 		diags = append(diags, d...)
-		file.Variables.InsertStage(name, &value, scopeID)
+		if d.HasErrors() {
+			value = cty.DynamicVal
+		} else if decl, ok := decls[assignment.Name]; ok {
+			value, d = prepareAssignment(decl, value, ctx, assignment.NameRange)
+			diags = append(diags, d...)
+		}
+		store(assignment.Name, value)
+		evaluated[assignment] = true
+	}
+
+	// Assignments left out of the order are part of a cycle, or refer to one.
+	for _, assignment := range assignments {
+		if !evaluated[assignment] {
+			store(assignment.Name, cty.DynamicVal)
+		}
 	}
 
 	return diags
@@ -0,0 +1,308 @@
+package factory
+
+import (
+	"fmt"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/ext/typeexpr"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/zclconf/go-cty/cty"
+	"github.com/zclconf/go-cty/cty/convert"
+)
+
+// Variable is a typed variable declared with a variable block:
+//
+//	variable "name" {
+//	  type        = string
+//	  default     = "value"
+//	  description = "What the variable is for"
+//	  sensitive   = false
+//
+//	  validation {
+//	    condition     = var.name != ""
+//	    error_message = "The name must not be empty."
+//	  }
+//	}
+//
+// Values assigned in a variables block are converted to the declared type
+// and checked against every validation rule.
+type Variable struct {
+	Name        string
+	Description string
+
+	// Type is the type constraint values are converted to. It is
+	// cty.DynamicPseudoType when no type is declared.
+	Type cty.Type
+
+	// TypeDefaults holds the defaults of optional object attributes
+	// declared in the type constraint, if any.
+	TypeDefaults *typeexpr.Defaults
+
+	// Default is the value used when none is assigned, or cty.NilVal if
+	// the variable is required.
+	Default cty.Value
+
+	// Sensitive marks the value of the variable as confidential.
+	Sensitive bool
+
+	Validations []*VariableValidation
+
+	// DeclRange is the range of the variable block header.
+	DeclRange hcl.Range
+}
+
+// VariableValidation is a validation rule of a Variable.
+type VariableValidation struct {
+	// Condition is an expression that refers to the variable and returns
+	// true if its value is valid.
+	Condition hcl.Expression
+
+	// ErrorMessage is reported when Condition returns false.
+	ErrorMessage string
+
+	// DeclRange is the range of the validation block header.
+	DeclRange hcl.Range
+}
+
+// Required reports whether the variable has no default value.
+func (v *Variable) Required() bool {
+	return v.Default == cty.NilVal
+}
+
+var variableBlockSchema = &hcl.BodySchema{
+	Attributes: []hcl.AttributeSchema{
+		{Name: "type"},
+		{Name: "default"},
+		{Name: "description"},
+		{Name: "sensitive"},
+	},
+	Blocks: []hcl.BlockHeaderSchema{
+		{Type: "validation"},
+	},
+}
+
+var variableValidationBlockSchema = &hcl.BodySchema{
+	Attributes: []hcl.AttributeSchema{
+		{Name: "condition", Required: true},
+		{Name: "error_message", Required: true},
+	},
+}
+
+func decodeVariableDeclBlock(block *hcl.Block, file *File) hcl.Diagnostics {
+	content, diags := block.Body.Content(variableBlockSchema)
+	v := &Variable{
+		Name:      block.Labels[0],
+		Type:      cty.DynamicPseudoType,
+		DeclRange: block.DefRange,
+	}
+
+	if !hclsyntax.ValidIdentifier(v.Name) {
+		diags = append(diags, &hcl.Diagnostic{
+			Severity: hcl.DiagError,
+			Summary:  "Invalid variable name",
+			Detail:   "A variable name must start with a letter or underscore and may contain only letters, digits, underscores, and dashes.",
+			Subject:  block.LabelRanges[0].Ptr(),
+		})
+	}
+
+	if attr, ok := content.Attributes["type"]; ok {
+		ty, defaults, d := typeexpr.TypeConstraintWithDefaults(attr.Expr)
+		diags = append(diags, d...)
+		if !d.HasErrors() {
+			v.Type = ty
+			v.TypeDefaults = defaults
+		}
+	}
+
+	// Declarations are evaluated before any variable is known, so their
+	// attributes can call functions but not refer to variables.
+	ctx := file.functionContext()
+
+	if attr, ok := content.Attributes["description"]; ok {
+		val, d := attr.Expr.Value(ctx)
+		diags = append(diags, d...)
+		if str, ok := decodeStaticString(attr, val, d, &diags); ok {
+			v.Description = str
+		}
+	}
+
+	if attr, ok := content.Attributes["sensitive"]; ok {
+		val, d := attr.Expr.Value(ctx)
+		diags = append(diags, d...)
+		if !d.HasErrors() {
+			b, err := convert.Convert(val, cty.Bool)
+			if err != nil || b.IsNull() || !b.IsKnown() {
+				diags = append(diags, &hcl.Diagnostic{
+					Severity: hcl.DiagError,
+					Summary:  "Invalid sensitive value",
+					Detail:   "The sensitive attribute must be true or false.",
+					Subject:  attr.Expr.Range().Ptr(),
+				})
+			} else {
+				v.Sensitive = b.True()
+			}
+		}
+	}
+
+	for _, inner := range content.Blocks {
+		validation, d := decodeVariableValidationBlock(v.Name, inner, ctx)
+		diags = append(diags, d...)
+		if validation != nil {
+			v.Validations = append(v.Validations, validation)
+		}
+	}
+
+	if attr, ok := content.Attributes["default"]; ok {
+		val, d := attr.Expr.Value(ctx)
+		diags = append(diags, d...)
+		if !d.HasErrors() {
+			val, d = v.Prepare(val, attr.Expr.Range())
+			diags = append(diags, d...)
+			v.Default = val
+		}
+	}
+
+	if existing, ok := file.Variables.Declarations[v.Name]; ok {
+		return append(diags, duplicateDiagnostic("variable", v.Name, existing.DeclRange, v.DeclRange))
+	}
+	file.Variables.Declarations[v.Name] = v
+
+	return diags
+}
+
+func decodeVariableValidationBlock(name string, block *hcl.Block, ctx *hcl.EvalContext) (*VariableValidation, hcl.Diagnostics) {
+	content, diags := block.Body.Content(variableValidationBlockSchema)
+	if diags.HasErrors() {
+		return nil, diags
+	}
+
+	validation := &VariableValidation{
+		Condition: content.Attributes["condition"].Expr,
+		DeclRange: block.DefRange,
+	}
+
+	// The condition may only refer to the variable being validated, so
+	// that it can be checked without knowing any other value.
+	for _, traversal := range validation.Condition.Variables() {
+		if ref, ok := variableReference(traversal); !ok || ref != name {
+			diags = append(diags, &hcl.Diagnostic{
+				Severity: hcl.DiagError,
+				Summary:  "Invalid variable validation condition",
+				Detail:   fmt.Sprintf("The condition for variable %q can only refer to the variable itself, using var.%s.", name, name),
+				Subject:  traversal.SourceRange().Ptr(),
+			})
+		}
+	}
+
+	attr := content.Attributes["error_message"]
+	val, d := attr.Expr.Value(ctx)
+	diags = append(diags, d...)
+	if str, ok := decodeStaticString(attr, val, d, &diags); ok {
+		validation.ErrorMessage = str
+	}
+
+	return validation, diags
+}
+
+// Prepare converts the given value to the variable's type and checks it
+// against every validation rule. rng is the range the value was assigned
+// at, which diagnostics point to. The value of a sensitive variable is
+// marked with SensitiveMark.
+func (v *Variable) Prepare(value cty.Value, rng hcl.Range) (cty.Value, hcl.Diagnostics) {
+	var diags hcl.Diagnostics
+
+	// Validation rules work on the unmarked value, the marks are put back
+	// on the result.
+	value, marks := value.UnmarkDeep()
+	if v.Sensitive {
+		marks = cty.NewValueMarks(SensitiveMark, marks)
+	}
+
+	if v.TypeDefaults != nil {
+		value = v.TypeDefaults.Apply(value)
+	}
+
+	converted, err := convert.Convert(value, v.Type)
+	if err != nil {
+		return cty.UnknownVal(v.Type).WithMarks(marks), append(diags, &hcl.Diagnostic{
+			Severity: hcl.DiagError,
+			Summary:  "Invalid value for variable",
+			Detail:   fmt.Sprintf("The value for variable %q is not compatible with its type %s: %s.", v.Name, typeexpr.TypeString(v.Type), err),
+			Subject:  rng.Ptr(),
+		})
+	}
+
+	for _, validation := range v.Validations {
+		ctx := &hcl.EvalContext{
+			Variables: map[string]cty.Value{
+				"var": cty.ObjectVal(map[string]cty.Value{v.Name: converted}),
+			},
+			Functions: Functions(nil, "."),
+		}
+		result, d := validation.Condition.Value(ctx)
+		diags = append(diags, d...)
+		if d.HasErrors() || !result.IsKnown() {
+			continue
+		}
+
+		result, err := convert.Convert(result, cty.Bool)
+		if err != nil || result.IsNull() {
+			diags = append(diags, &hcl.Diagnostic{
+				Severity: hcl.DiagError,
+				Summary:  "Invalid validation result",
+				Detail:   "The condition of a variable validation must return true or false.",
+				Subject:  validation.Condition.Range().Ptr(),
+			})
+			continue
+		}
+
+		if result.False() {
+			diags = append(diags, &hcl.Diagnostic{
+				Severity: hcl.DiagError,
+				Summary:  "Invalid value for variable",
+				Detail:   fmt.Sprintf("%s\n\nThis was checked by the validation rule at %s.", validation.ErrorMessage, validation.DeclRange),
+				Subject:  rng.Ptr(),
+			})
+		}
+	}
+
+	return converted.WithMarks(marks), diags
+}
+
+// variableReference returns the name of the variable a traversal such as
+// var.name refers to.
+func variableReference(traversal hcl.Traversal) (string, bool) {
+	if traversal.RootName() != "var" || len(traversal) < 2 {
+		return "", false
+	}
+
+	switch step := traversal[1].(type) {
+	case hcl.TraverseAttr:
+		return step.Name, true
+	case hcl.TraverseIndex:
+		if step.Key.Type() == cty.String && step.Key.IsKnown() && !step.Key.IsNull() {
+			return step.Key.AsString(), true
+		}
+	}
+	return "", false
+}
+
+// decodeStaticString returns the string value of an attribute that does
+// not refer to any variable, adding a diagnostic if it is not a string.
+func decodeStaticString(attr *hcl.Attribute, val cty.Value, valDiags hcl.Diagnostics, diags *hcl.Diagnostics) (string, bool) {
+	if valDiags.HasErrors() {
+		return "", false
+	}
+
+	str, err := convert.Convert(val, cty.String)
+	if err != nil || str.IsNull() || !str.IsKnown() {
+		*diags = append(*diags, &hcl.Diagnostic{
+			Severity: hcl.DiagError,
+			Summary:  fmt.Sprintf("Invalid %s", attr.Name),
+			Detail:   fmt.Sprintf("The %s attribute must be a string.", attr.Name),
+			Subject:  attr.Expr.Range().Ptr(),
+		})
+		return "", false
+	}
+	return str.AsString(), true
+}
@@ -0,0 +1,295 @@
+package factory
+
+import (
+	"testing"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/zclconf/go-cty/cty"
+)
+
+func loadTestFiles(t *testing.T, sources map[string]string) (*Config, hcl.Diagnostics) {
+	t.Helper()
+
+	fs := afero.NewMemMapFs()
+	var paths []string
+	for path, src := range sources {
+		afero.WriteFile(fs, path, []byte(src), 0644)
+		paths = append(paths, path)
+	}
+
+	files, diags := NewParser(fs).LoadFiles(paths)
+	config, configDiags := NewConfig(files)
+	return config, append(diags, configDiags...)
+}
+
+func TestDecodeVariableDeclBlock(t *testing.T) {
+	config, diags := loadTestFiles(t, map[string]string{"test.hcl": `
+variable "replicas" {
+  type        = string
+  default     = 3
+  description = "Number of replicas"
+}
+variable "image" {
+  type      = string
+  sensitive = true
+  validation {
+    condition     = var.image != ""
+    error_message = "The image must not be empty."
+  }
+}
+variable "env" {
+  type        = string
+  default     = lower("DEV")
+  description = format("Deployment %s", "environment")
+}
+variables {
+  image = "my-image"
+}
+stage "build" {
+  run "build" {
+    command = "docker build -t ${var.image}-${var.env} --replicas ${var.replicas}"
+  }
+}
+`})
+	if diags.HasErrors() {
+		t.Fatalf("Error loading config: %s", diags)
+	}
+
+	replicas := config.Variables.Declarations["replicas"]
+	assert.Equal(t, cty.String, replicas.Type)
+	assert.Equal(t, cty.StringVal("3"), replicas.Default)
+	assert.Equal(t, "Number of replicas", replicas.Description)
+	assert.False(t, replicas.Required())
+
+	image := config.Variables.Declarations["image"]
+	assert.True(t, image.Sensitive)
+	assert.True(t, image.Required())
+	assert.Len(t, image.Validations, 1)
+
+	assert.Equal(t, "Deployment environment", config.Variables.Declarations["env"].Description)
+
+	assert.Equal(t, []string{"docker build -t my-image-dev --replicas 3"}, config.Stages["build"].RunBlocks[0].Commands)
+}
+
+func TestDecodeVariableDeclBlockReturnsErrors(t *testing.T) {
+	tests := []struct {
+		Src     string
+		Summary string
+		Line    int
+	}{
+		{`variable "count" {
+			type    = number
+			default = "three"
+		}`, "Invalid value for variable", 3},
+		{`variable "name" {
+			type = string
+			validation {
+				condition     = length(var.other) > 0
+				error_message = "Invalid."
+			}
+		}`, "Invalid variable validation condition", 4},
+		{`variable "name" {
+			type = string
+		}`, "No value for required variable", 1},
+		{`variable "name" {
+			type = string
+			validation {
+				condition     = var.name != "bad"
+				error_message = "The name must not be bad."
+			}
+		}
+		variables {
+			name = "bad"
+		}`, "Invalid value for variable", 9},
+		{`variable "tags" {
+			type = list(string)
+		}
+		variables {
+			tags = "not-a-list"
+		}`, "Invalid value for variable", 5},
+		{`variable "replicas" {
+			type    = number
+			default = 1
+		}
+		stage "deploy" {
+			variables {
+				replicas = "lots"
+			}
+		}`, "Invalid value for variable", 7},
+		{`variable "replicas" {
+			type    = number
+			default = 1
+			validation {
+				condition     = var.replicas < 5
+				error_message = "Too many replicas."
+			}
+		}
+		stage "deploy" {
+			variables {
+				replicas = 99
+			}
+		}`, "Invalid value for variable", 11},
+		{`variable "replicas" {
+			type    = number
+			default = 1
+		}
+		pipeline "p" {
+			stages = []
+			variables {
+				replicas = "lots"
+			}
+		}`, "Invalid value for variable", 8},
+		{`variable "replicas" {
+			type    = number
+			default = 1
+			validation {
+				condition     = var.replicas < 5
+				error_message = "Too many replicas."
+			}
+		}
+		pipeline "p" {
+			stages = []
+			variables {
+				replicas = 99
+			}
+		}`, "Invalid value for variable", 12},
+		{`variable "replicas" {
+			type    = number
+			default = 1
+			validation {
+				condition     = var.replicas < 5
+				error_message = "Too many replicas."
+			}
+		}
+		pipeline "p" {
+			stages {
+				deploy {
+					variables {
+						replicas = 99
+					}
+				}
+			}
+		}
+		stage "deploy" {
+			run "deploy" {
+				command = "deploy"
+			}
+		}`, "Invalid value for variable", 13},
+		{`variable "name" {
+			type = strin
+		}`, "Invalid type specification", 2},
+		{`variable "name" {
+			default   = "a"
+			sensitive = "maybe"
+		}`, "Invalid sensitive value", 3},
+	}
+
+	for _, test := range tests {
+		_, diags := loadTestFiles(t, map[string]string{"test.hcl": test.Src})
+		if assert.True(t, diags.HasErrors(), "Expected errors for %s", test.Src) {
+			assert.Equal(t, test.Summary, diags[0].Summary, "Got %s", diags)
+			assert.Equal(t, test.Line, diags[0].Subject.Start.Line)
+		}
+	}
+}
+
+func TestVariableDeclAcrossFiles(t *testing.T) {
+	config, diags := loadTestFiles(t, map[string]string{
+		"a.hcl": `
+variable "replicas" {
+  type = number
+}
+`,
+		"b.hcl": `
+variables {
+  replicas = "5"
+}
+`,
+	})
+	if diags.HasErrors() {
+		t.Fatalf("Error loading config: %s", diags)
+	}
+
+	replicas := config.Variables.GlobalVariables["replicas"]
+	assert.Equal(t, cty.Number, replicas.Type())
+	assert.True(t, replicas.Equals(cty.NumberIntVal(5)).True())
+}
+
+func TestStageVariableDecl(t *testing.T) {
+	config, diags := loadTestFiles(t, map[string]string{"test.hcl": `
+variable "replicas" {
+  type    = number
+  default = 1
+}
+variable "docker" {
+  type = object({ registry = string, tag = string })
+  default = {
+    registry = "docker.io"
+    tag      = "latest"
+  }
+}
+stage "deploy" {
+  variables {
+    replicas = "5"
+    docker   = { tag = "v1" }
+  }
+  run "deploy" {
+    command = "deploy ${var.docker.registry}:${var.docker.tag} ${var.replicas + 1}"
+  }
+}
+`})
+	if diags.HasErrors() {
+		t.Fatalf("Error loading config: %s", diags)
+	}
+
+	// A partial object is checked once it is merged into the value it
+	// overrides.
+	assert.Equal(t, []string{"deploy docker.io:v1 6"}, config.Stages["deploy"].RunBlocks[0].Commands)
+	if diags := config.Validate(); diags.HasErrors() {
+		t.Fatalf("Error validating config: %s", diags)
+	}
+}
+
+func TestPipelineVariableDecl(t *testing.T) {
+	config, diags := loadTestFiles(t, map[string]string{"test.hcl": `
+variable "replicas" {
+  type    = number
+  default = 1
+}
+variables {
+  prefix = "global"
+  name   = "global-app"
+}
+pipeline "deploy" {
+  variables {
+    name     = "${var.prefix}-app"
+    prefix   = "${var.prefix}-deploy"
+    replicas = "3"
+  }
+  stages {
+    deploy {
+      variables {
+        replicas = var.replicas + 1
+      }
+    }
+  }
+}
+stage "deploy" {
+  run "deploy" {
+    command = "deploy ${var.name} ${var.replicas}"
+  }
+}
+`})
+	if diags.HasErrors() {
+		t.Fatalf("Error loading config: %s", diags)
+	}
+
+	// Variables are evaluated in the order their references require, and
+	// converted to their declared type.
+	pipeline := config.Pipelines["deploy"]
+	assert.Equal(t, cty.StringVal("global-deploy-app"), pipeline.Variables["name"])
+	assert.True(t, pipeline.Variables["replicas"].Equals(cty.NumberIntVal(3)).True())
+	assert.True(t, pipeline.Stages[0].Variables["replicas"].Equals(cty.NumberIntVal(4)).True())
+}
@@ -0,0 +1,156 @@
+package factory
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/zclconf/go-cty/cty"
+)
+
+// VarEnvPrefix is the prefix of the environment variables that assign
+// values to variables: FACTORY_VAR_foo=bar assigns "bar" to var.foo.
+const VarEnvPrefix = "FACTORY_VAR_"
+
+// VarFileExt is the extension of variable definition files. They hold
+// nothing but attributes, e.g. foo = "bar", and are never loaded as
+// configuration files.
+const VarFileExt = ".factoryvars"
+
+// InputValue is a variable value supplied from outside the configuration,
+// by a -var flag, a variable definitions file or an environment variable.
+//
+// Input values take precedence over every value assigned in the
+// configuration, from lowest to highest:
+//
+//  1. the default of a variable block
+//  2. the global variables block
+//  3. the variables block of a pipeline
+//  4. the variables block of a stage
+//  5. the variables block of a stage in a pipeline's stages block
+//  6. FACTORY_VAR_ environment variables
+//  7. -var and -var-file flags, in the order they are given
+type InputValue struct {
+	Value cty.Value
+
+	// Raw reports whether Value is the text of a flag or an environment
+	// variable. Raw values of variables declared with a complex type, such
+	// as list(string), are parsed as HCL expressions.
+	Raw bool
+
+	// SourceRange is the range of the assignment in a variable definitions
+	// file, if the value came from one.
+	SourceRange hcl.Range
+}
+
+// InputValues holds input values keyed by variable name.
+type InputValues map[string]*InputValue
+
+// Override copies every value of other into iv, replacing the values that
+// iv already holds.
+func (iv InputValues) Override(other InputValues) {
+	for name, value := range other {
+		iv[name] = value
+	}
+}
+
+// ParseVarFlag parses the argument of a -var flag, in the form name=value.
+// The value is always raw text.
+func ParseVarFlag(arg string) (string, *InputValue, hcl.Diagnostics) {
+	name, value, ok := strings.Cut(arg, "=")
+	if !ok {
+		return "", nil, hcl.Diagnostics{{
+			Severity: hcl.DiagError,
+			Summary:  "Invalid -var option",
+			Detail:   fmt.Sprintf("The given -var option %q is not correctly specified. It must be a variable name and value separated by an equals sign, like -var=\"key=value\".", arg),
+		}}
+	}
+
+	name = strings.TrimSpace(name)
+	if !hclsyntax.ValidIdentifier(name) {
+		return "", nil, hcl.Diagnostics{{
+			Severity: hcl.DiagError,
+			Summary:  "Invalid -var option",
+			Detail:   fmt.Sprintf("The given -var option %q does not start with a valid variable name.", arg),
+		}}
+	}
+
+	return name, &InputValue{Value: cty.StringVal(value), Raw: true}, nil
+}
+
+// EnvInputValues returns the values assigned by the FACTORY_VAR_
+// environment variables in environ, which is in the form returned by
+// os.Environ.
+func EnvInputValues(environ []string) InputValues {
+	inputs := make(InputValues)
+	for _, env := range environ {
+		if !strings.HasPrefix(env, VarEnvPrefix) {
+			continue
+		}
+		name, value, ok := strings.Cut(strings.TrimPrefix(env, VarEnvPrefix), "=")
+		if !ok || !hclsyntax.ValidIdentifier(name) {
+			continue
+		}
+		inputs[name] = &InputValue{Value: cty.StringVal(value), Raw: true}
+	}
+	return inputs
+}
+
+// LoadVarFile reads the variable definitions file at the given path. Every
+// attribute of the file assigns a value to the variable of the same name,
+// and may call functions but not refer to other variables. Functions that
+// read files resolve paths against the directory of the file.
+func (p *Parser) LoadVarFile(path string) (InputValues, hcl.Diagnostics) {
+	body, diags := p.LoadHCLFile(path)
+	if body == nil {
+		return nil, diags
+	}
+
+	attrs, attrDiags := body.JustAttributes()
+	diags = append(diags, attrDiags...)
+
+	ctx := &hcl.EvalContext{Functions: Functions(p.fs, filepath.Dir(path))}
+	inputs := make(InputValues)
+	for name, attr := range attrs {
+		value, valDiags := attr.Expr.Value(ctx)
+		diags = append(diags, valDiags...)
+		if valDiags.HasErrors() {
+			continue
+		}
+		inputs[name] = &InputValue{Value: value, SourceRange: attr.NameRange}
+	}
+
+	return inputs, diags
+}
+
+// SetInputValues sets the input values that override the variables of
+// every configuration file the parser loads. Imported sources only see the
+// variables their importer passes to them.
+func (p *Parser) SetInputValues(inputs InputValues) {
+	p.inputs = inputs
+}
+
+// parseInput parses the raw text of an input value as an HCL expression if
+// the variable is declared with a type that is not primitive. The expression
+// can call functions, which resolve paths against the working directory.
+// Other values are returned unchanged.
+func (v *Variable) parseInput(value cty.Value) (cty.Value, hcl.Diagnostics) {
+	if v.Type.IsPrimitiveType() || v.Type == cty.DynamicPseudoType {
+		return value, nil
+	}
+	if !value.IsKnown() || value.IsNull() || value.Type() != cty.String {
+		return value, nil
+	}
+
+	filename := fmt.Sprintf("<value for var.%s>", v.Name)
+	expr, diags := hclsyntax.ParseExpression([]byte(value.AsString()), filename, hcl.InitialPos)
+	if diags.HasErrors() {
+		return cty.DynamicVal, diags
+	}
+
+	parsed, valDiags := expr.Value(&hcl.EvalContext{Functions: Functions(nil, ".")})
+	diags = append(diags, valDiags...)
+	return parsed, diags
+}
@@ -0,0 +1,129 @@
+package factory
+
+import (
+	"testing"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/zclconf/go-cty/cty"
+)
+
+func TestParseVarFlag(t *testing.T) {
+	name, input, diags := ParseVarFlag("image=my-image:1.0=latest")
+	if diags.HasErrors() {
+		t.Fatalf("Error parsing flag: %s", diags)
+	}
+	assert.Equal(t, "image", name)
+	assert.Equal(t, cty.StringVal("my-image:1.0=latest"), input.Value)
+	assert.True(t, input.Raw)
+
+	for _, arg := range []string{"image", "=value", "1image=value"} {
+		_, _, diags := ParseVarFlag(arg)
+		if assert.True(t, diags.HasErrors(), "Expected errors for %s", arg) {
+			assert.Equal(t, "Invalid -var option", diags[0].Summary)
+		}
+	}
+}
+
+func TestEnvInputValues(t *testing.T) {
+	inputs := EnvInputValues([]string{
+		"PATH=/usr/bin",
+		"FACTORY_VAR_env=prod",
+		"FACTORY_VAR_image=a=b",
+		"FACTORY_VAR_=ignored",
+	})
+
+	assert.Len(t, inputs, 2)
+	assert.Equal(t, cty.StringVal("prod"), inputs["env"].Value)
+	assert.Equal(t, cty.StringVal("a=b"), inputs["image"].Value)
+}
+
+func TestLoadVarFile(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	afero.WriteFile(fs, "prod.factoryvars", []byte(`
+env   = "prod"
+zones = ["a", "b"]
+owner = upper("ops")
+`), 0644)
+
+	inputs, diags := NewParser(fs).LoadVarFile("prod.factoryvars")
+	if diags.HasErrors() {
+		t.Fatalf("Error loading var file: %s", diags)
+	}
+
+	assert.Equal(t, cty.StringVal("prod"), inputs["env"].Value)
+	assert.Equal(t, cty.TupleVal([]cty.Value{cty.StringVal("a"), cty.StringVal("b")}), inputs["zones"].Value)
+	assert.False(t, inputs["zones"].Raw)
+	assert.Equal(t, 3, inputs["zones"].SourceRange.Start.Line)
+	assert.Equal(t, cty.StringVal("OPS"), inputs["owner"].Value)
+
+	afero.WriteFile(fs, "bad.factoryvars", []byte(`env = var.other`), 0644)
+	_, diags = NewParser(fs).LoadVarFile("bad.factoryvars")
+	assert.True(t, diags.HasErrors())
+}
+
+func TestInputValuesOverrideVariables(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	afero.WriteFile(fs, "test.hcl", []byte(`
+variables {
+  env   = "dev"
+  image = "my-image"
+}
+stage "deploy" {
+  variables {
+    env = "staging"
+  }
+  run "deploy" {
+    command = "deploy ${var.image} to ${var.env}"
+  }
+}
+`), 0644)
+
+	parser := NewParser(fs)
+	parser.SetInputValues(InputValues{"env": {Value: cty.StringVal("prod"), Raw: true}})
+	config, diags := loadTestConfigWithParser(t, parser, "test.hcl")
+	if diags.HasErrors() {
+		t.Fatalf("Error loading config: %s", diags)
+	}
+
+	assert.Equal(t, []string{"deploy my-image to prod"}, config.Stages["deploy"].RunBlocks[0].Commands)
+}
+
+func TestRawInputValuesParsedForComplexTypes(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	afero.WriteFile(fs, "a.hcl", []byte(`
+stage "build" {
+  run "build" {
+    command = "build"
+  }
+}
+`), 0644)
+	afero.WriteFile(fs, "b.hcl", []byte(`
+variable "zones" {
+  type = list(string)
+}
+`), 0644)
+
+	parser := NewParser(fs)
+	parser.SetInputValues(InputValues{"zones": {Value: cty.StringVal(`["a", "b"]`), Raw: true}})
+	config, diags := loadTestConfigWithParser(t, parser, "a.hcl", "b.hcl")
+	if diags.HasErrors() {
+		t.Fatalf("Error loading config: %s", diags)
+	}
+
+	assert.Equal(t, cty.ListVal([]cty.Value{cty.StringVal("a"), cty.StringVal("b")}), config.Variables.Overrides["zones"])
+
+	parser = NewParser(fs)
+	parser.SetInputValues(InputValues{"zones": {Value: cty.StringVal(`["a", `), Raw: true}})
+	_, diags = loadTestConfigWithParser(t, parser, "a.hcl", "b.hcl")
+	assert.True(t, diags.HasErrors())
+}
+
+func loadTestConfigWithParser(t *testing.T, parser *Parser, paths ...string) (*Config, hcl.Diagnostics) {
+	t.Helper()
+
+	files, diags := parser.LoadFiles(paths)
+	config, configDiags := NewConfig(files)
+	return config, append(diags, configDiags...)
+}
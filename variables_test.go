@@ -50,3 +50,153 @@ func TestDecodeGlobalVariableBlock(t *testing.T) {
 
 	assert.Equal(t, "this is a test bar-hello", stageBlock.RunBlocks[0].Commands[0])
 }
+
+func TestNestedVariableReferences(t *testing.T) {
+	parser := hclparse.NewParser()
+	file, _ := parser.ParseHCL([]byte(`
+		variables {
+			image    = "my-image"
+			replicas = 3
+			zones    = ["a", "b"]
+			debug    = true
+			docker = {
+				registry = "registry.example.com"
+				tags     = { stable = "1.0", latest = "1.1" }
+			}
+		}
+		stage "build" {
+			variables {
+				docker = {
+					tags = { latest = "2.0" }
+				}
+			}
+			run "Build" {
+				command = "${var.docker.registry}/${var.image}:${var.docker.tags.stable}-${var.docker.tags.latest} ${var.zones[1]} ${var.replicas} ${var.debug}"
+			}
+		}
+	`), "test")
+
+	config, diags := file.Body.Content(configFileSchema)
+	if diags.HasErrors() {
+		t.Fatalf("Error decoding config: %s", diags)
+	}
+
+	f := NewFile()
+	if d := decodeGlobalVariableBlock(config.Blocks[0], f); d.HasErrors() {
+		t.Fatalf("Error decoding global variable block: %s", d)
+	}
+
+	stageBlock, sd := decodeStageBlock(config.Blocks[1], f)
+	if sd.HasErrors() {
+		t.Fatalf("Error decoding stage block: %s", sd)
+	}
+
+	assert.Equal(t, "registry.example.com/my-image:1.0-2.0 b 3 true", stageBlock.RunBlocks[0].Commands[0])
+
+	// The global object is left untouched by the stage
+	global := f.Variables.GlobalVariables["docker"].GetAttr("tags").GetAttr("latest")
+	assert.Equal(t, cty.StringVal("1.1"), global)
+}
+
+func TestDeepMerge(t *testing.T) {
+	base := cty.ObjectVal(map[string]cty.Value{
+		"name": cty.StringVal("base"),
+		"nested": cty.ObjectVal(map[string]cty.Value{
+			"a": cty.StringVal("a"),
+			"b": cty.StringVal("b"),
+		}),
+		"list": cty.ListVal([]cty.Value{cty.StringVal("x")}),
+	})
+	override := cty.ObjectVal(map[string]cty.Value{
+		"nested": cty.MapVal(map[string]cty.Value{
+			"b": cty.StringVal("B"),
+			"c": cty.StringVal("C"),
+		}),
+		"list": cty.ListVal([]cty.Value{cty.StringVal("y")}),
+	})
+
+	merged := deepMerge(base, override)
+	assert.Equal(t, cty.ObjectVal(map[string]cty.Value{
+		"name": cty.StringVal("base"),
+		"nested": cty.ObjectVal(map[string]cty.Value{
+			"a": cty.StringVal("a"),
+			"b": cty.StringVal("B"),
+			"c": cty.StringVal("C"),
+		}),
+		"list": cty.ListVal([]cty.Value{cty.StringVal("y")}),
+	}), merged)
+
+	// Values that are not objects replace each other
+	assert.Equal(t, cty.StringVal("new"), deepMerge(base, cty.StringVal("new")))
+	assert.Equal(t, base, deepMerge(cty.StringVal("old"), base))
+	assert.Equal(t, base, deepMerge(cty.NilVal, base))
+}
+
+func TestVariablesOrderIndependent(t *testing.T) {
+	config, diags := loadTestFiles(t, map[string]string{
+		"a.hcl": `
+stage "deploy" {
+  variables {
+    target = "${var.env}-${var.region}"
+    env    = "${var.env}-blue"
+  }
+  run "deploy" {
+    command = "deploy ${var.image} to ${var.target}"
+  }
+}
+variables {
+  image = "${var.registry}/${var.name}:${var.tag}"
+  tag   = var.version
+}
+`,
+		"b.hcl": `
+variables {
+  registry = "registry.example.com"
+  name     = "app"
+  version  = "1.0"
+  env      = "prod"
+  region   = "eu"
+}
+`,
+	})
+	if diags.HasErrors() {
+		t.Fatalf("Error loading config: %s", diags)
+	}
+
+	assert.Equal(t, []string{"deploy registry.example.com/app:1.0 to prod-blue-eu"}, config.Stages["deploy"].RunBlocks[0].Commands)
+	assert.Equal(t, cty.StringVal("registry.example.com/app:1.0"), config.Variables.GlobalVariables["image"])
+	assert.Equal(t, cty.StringVal("prod"), config.Variables.GlobalVariables["env"])
+}
+
+func TestVariablesReturnErrors(t *testing.T) {
+	tests := []struct {
+		Src     string
+		Summary string
+		Detail  string
+	}{
+		{`variables {
+			a = var.b
+			b = "${var.a}-suffix"
+		}`, "Cycle in variables", "var.a -> var.b -> var.a"},
+		{`variables {
+			a = var.a
+		}`, "Cycle in variables", "var.a -> var.a"},
+		{`stage "s" {
+			variables {
+				a = var.b
+				b = var.a
+			}
+		}`, "Cycle in variables", "var.a -> var.b -> var.a"},
+		{`variables {
+			a = var.missing
+		}`, "Unsupported attribute", ""},
+	}
+
+	for _, test := range tests {
+		_, diags := loadTestFiles(t, map[string]string{"test.hcl": test.Src})
+		if assert.True(t, diags.HasErrors(), "Expected errors for %s", test.Src) {
+			assert.Equal(t, test.Summary, diags[0].Summary, "Got %s", diags)
+			assert.Contains(t, diags[0].Detail, test.Detail)
+		}
+	}
+}